@@ -7,6 +7,7 @@
 package user
 
 import (
+	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
@@ -29,6 +30,9 @@ var MEMBERS = []string{USER, SYS_USER}
 // Stores the ids at creating the groups.
 var GID, SYS_GID int
 
+// testDB is the database used in tests, placed into a temporary directory.
+var testDB *DB
+
 // == Copy the system files before of be edited.
 
 func init() {
@@ -37,31 +41,43 @@ func init() {
 		log.Fatalf("%s", err)
 	}
 
-	if fileUser, err = file.CopytoTemp(fileUser, "test-user_"); err != nil {
-		goto _error
-	}
-	if fileGroup, err = file.CopytoTemp(fileGroup, "test-group_"); err != nil {
-		goto _error
-	}
-	if fileShadow, err = file.CopytoTemp(fileShadow, "test-shadow_"); err != nil {
-		goto _error
+	root, err := ioutil.TempDir("", file.PREFIX_TEMP+"user-root_")
+	if err != nil {
+		log.Fatalf("%s", err)
 	}
-	if fileGShadow, err = file.CopytoTemp(fileGShadow, "test-gshadow_"); err != nil {
-		goto _error
+	testDB = NewDB(root)
+
+	for _, name := range []string{
+		fileUser, fileGroup, fileShadow, fileGShadow,
+		fileLogin, fileUseradd, fileAdduser, fileLibuser,
+		fileSubUID, fileSubGID,
+	} {
+		if err = copyToRoot(name); err != nil {
+			removeTempFiles()
+			log.Fatalf("%s", err)
+		}
 	}
+}
 
-	return
+// copyToRoot copies a file of the system into the root directory of testDB.
+// The optional files which are not found are skipped.
+func copyToRoot(name string) error {
+	if found, err := exist(name); !found {
+		return err
+	}
 
-_error:
-	removeTempFiles()
-	log.Fatalf("%s", err)
+	dest := testDB.path(name)
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	return file.Copy(name, dest)
 }
 
 func removeTempFiles() {
 	files, _ := filepath.Glob(filepath.Join(os.TempDir(), file.PREFIX_TEMP+"*"))
 
 	for _, f := range files {
-		if err := os.Remove(f); err != nil {
+		if err := os.RemoveAll(f); err != nil {
 			log.Printf("%s", err)
 		}
 	}
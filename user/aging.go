@@ -0,0 +1,236 @@
+// Copyright 2021 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// Password aging
+//
+// The aging information is evaluated like "shadow(5)" describes it, where the
+// dates are expressed in days since Jan 1, 1970 (UTC). The numeric fields are
+// parsed to Never when they are empty, which is different to 0: a maximum age
+// of 0 expires the password at once, an inactivity period of 0 disables the
+// password once it expires, and an expiration date of 0 disables the account.
+// In "Min" and "Warn", 0 and empty have the same meaning.
+
+package user
+
+import (
+	"fmt"
+	"time"
+)
+
+// agingEnabled reports whether the features of password aging are enabled, and
+// the password has a maximum age.
+func (s *Shadow) agingEnabled() bool {
+	return s.changed != _DISABLE_AGING && s.Max >= 0
+}
+
+// PasswordChangedAt returns the date of the last password change.
+// It returns false whether the password aging is disabled, or the user must
+// change their password the next time they log in.
+func (s *Shadow) PasswordChangedAt() (time.Time, bool) {
+	if s.changed <= _CHANGE_PASSWORD {
+		return time.Time{}, false
+	}
+	return dayToTime(int(s.changed)), true
+}
+
+// PasswordExpiresAt returns the date of expiration of the password.
+// It returns false whether the password never expires.
+//
+// If the user must change their password the next time they log in, the date
+// is Jan 1, 1970.
+func (s *Shadow) PasswordExpiresAt() (time.Time, bool) {
+	if s.changed == _CHANGE_PASSWORD {
+		return dayToTime(0), true
+	}
+	if !s.agingEnabled() {
+		return time.Time{}, false
+	}
+	return dayToTime(int(s.changed) + s.Max), true
+}
+
+// PasswordInactiveAt returns the date when the password becomes inactive, so
+// no login is possible using it.
+// It returns false whether there is not an inactivity period.
+func (s *Shadow) PasswordInactiveAt() (time.Time, bool) {
+	inactive, _, _ := s.optionalAges()
+	if !s.agingEnabled() || inactive < 0 || s.changed == _CHANGE_PASSWORD {
+		return time.Time{}, false
+	}
+	return dayToTime(int(s.changed) + s.Max + inactive), true
+}
+
+// AccountExpiresAt returns the date of expiration of the account.
+// It returns false whether the account never expires.
+func (s *Shadow) AccountExpiresAt() (time.Time, bool) {
+	_, expire, _ := s.optionalAges()
+	if expire < 0 {
+		return time.Time{}, false
+	}
+	return dayToTime(expire), true
+}
+
+// MustChangeNow reports whether the user has to change their password at the
+// given time, since it has expired or it was required by the administrator.
+func (s *Shadow) MustChangeNow(now time.Time) bool {
+	if s.changed == _CHANGE_PASSWORD {
+		return true
+	}
+	if !s.agingEnabled() {
+		return false
+	}
+	return secToDay(now.Unix()) >= int(s.changed)+s.Max
+}
+
+// InWarningPeriod reports whether the user should be warned at the given time,
+// since their password is going to expire.
+func (s *Shadow) InWarningPeriod(now time.Time) bool {
+	if !s.agingEnabled() || s.Warn <= 0 || s.changed == _CHANGE_PASSWORD {
+		return false
+	}
+
+	today := secToDay(now.Unix())
+	expireDay := int(s.changed) + s.Max
+	return today < expireDay && today >= expireDay-s.Warn
+}
+
+// IsInactive reports whether the inactivity period, after the password has
+// expired, is elapsed at the given time. Then, no login is possible using the
+// password.
+func (s *Shadow) IsInactive(now time.Time) bool {
+	inactive, _, _ := s.optionalAges()
+	if !s.agingEnabled() || inactive < 0 || s.changed == _CHANGE_PASSWORD {
+		return false
+	}
+	return secToDay(now.Unix()) >= int(s.changed)+s.Max+inactive
+}
+
+// AccountExpired reports whether the account has expired at the given time.
+// Then, the user shall not be allowed to login.
+func (s *Shadow) AccountExpired(now time.Time) bool {
+	_, expire, _ := s.optionalAges()
+	if expire < 0 {
+		return false
+	}
+	return secToDay(now.Unix()) >= expire
+}
+
+// CanChangePassword reports whether the user is allowed to change their
+// password at the given time, since the minimum password age is elapsed.
+//
+// The user cannot change their password whether the maximum password age is
+// lower than the minimum one.
+func (s *Shadow) CanChangePassword(now time.Time) bool {
+	if s.changed <= _CHANGE_PASSWORD {
+		return true
+	}
+	if s.Min <= 0 {
+		return true
+	}
+	if s.Max >= 0 && s.Max < s.Min {
+		return false
+	}
+	return secToDay(now.Unix()) >= int(s.changed)+s.Min
+}
+
+// == Editing
+//
+
+// Never is the value of an AgeMod field which removes that aging information,
+// so it is never applied.
+const Never = -1
+
+// Day returns the number of days since Jan 1, 1970 of the given time, to be
+// used in the dates of AgeMod.
+func Day(t time.Time) int { return secToDay(t.Unix()) }
+
+// An AgeMod represents the changes to do in the aging information of an user,
+// like it is done by "chage(1)". The fields which are nil are not changed.
+//
+// The numbers of days and the dates, expressed in days since Jan 1, 1970, can
+// be set to Never to empty their field.
+type AgeMod struct {
+	// Date of last password change. The value 0 forces the user to change
+	// their password the next time they log in, and Never disables the
+	// password aging.
+	LastChange *int
+
+	Min      *int // Minimum password age.
+	Max      *int // Maximum password age.
+	Warn     *int // Password warning period.
+	Inactive *int // Password inactivity period.
+
+	// Date of expiration of the account. The value 0 disables the account,
+	// like "chage -E 0".
+	Expire *int
+}
+
+// ChAge changes the aging information of an user of the system.
+func ChAge(name string, changes *AgeMod) error { return defaultDB.ChAge(name, changes) }
+
+// ChAge changes the aging information of an user of the database.
+func (db *DB) ChAge(name string, changes *AgeMod) error {
+	return db.update(func(tx *Tx) error { return tx.ChAge(name, changes) })
+}
+
+// ChAge stages the changes of the aging information of an user.
+// A nil changes does not change anything.
+func (tx *Tx) ChAge(name string, changes *AgeMod) error {
+	if changes == nil {
+		changes = &AgeMod{}
+	}
+
+	s, err := tx.LookupShadow(name)
+	if err != nil {
+		return err
+	}
+
+	for _, v := range []struct {
+		field string
+		value *int
+	}{
+		{"LastChange", changes.LastChange},
+		{"Min", changes.Min},
+		{"Max", changes.Max},
+		{"Warn", changes.Warn},
+		{"Inactive", changes.Inactive},
+		{"Expire", changes.Expire},
+	} {
+		if v.value != nil && *v.value < Never {
+			return &AgeError{v.field, *v.value}
+		}
+	}
+
+	if changes.LastChange != nil {
+		s.changed = changeType(*changes.LastChange) // Never is _DISABLE_AGING.
+	}
+	if changes.Min != nil {
+		s.Min = *changes.Min
+	}
+	if changes.Max != nil {
+		s.Max = *changes.Max
+	}
+	if changes.Warn != nil {
+		s.Warn = *changes.Warn
+	}
+	if changes.Inactive != nil {
+		s.Inactive = *changes.Inactive
+	}
+	if changes.Expire != nil {
+		s.expire = *changes.Expire
+	}
+
+	return tx.edit(name, s)
+}
+
+// An AgeError reports a value not valid for a field of password aging.
+type AgeError struct {
+	field string
+	value int
+}
+
+func (e *AgeError) Error() string {
+	return fmt.Sprintf("value not valid for the password aging: %s: %d", e.field, e.value)
+}
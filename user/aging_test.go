@@ -0,0 +1,269 @@
+// Copyright 2021 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package user
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAging(t *testing.T) {
+	const changed = 18000
+	day := func(n int) time.Time { return dayToTime(changed + n).Add(time.Hour) }
+
+	s, err := parseShadow("u:$6$hash:18000:5:30:7:10:18100:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if at, ok := s.PasswordChangedAt(); !ok || !at.Equal(dayToTime(changed)) {
+		t.Errorf("PasswordChangedAt: got %s, %v", at, ok)
+	}
+	if at, ok := s.PasswordExpiresAt(); !ok || !at.Equal(dayToTime(changed+30)) {
+		t.Errorf("PasswordExpiresAt: got %s, %v", at, ok)
+	}
+	if at, ok := s.PasswordInactiveAt(); !ok || !at.Equal(dayToTime(changed+40)) {
+		t.Errorf("PasswordInactiveAt: got %s, %v", at, ok)
+	}
+	if at, ok := s.AccountExpiresAt(); !ok || !at.Equal(dayToTime(18100)) {
+		t.Errorf("AccountExpiresAt: got %s, %v", at, ok)
+	}
+
+	data := []struct {
+		day                                      int
+		canChange, warning, mustChange, inactive bool
+		accountExpired                           bool
+	}{
+		{0, false, false, false, false, false},
+		{5, true, false, false, false, false},
+		{22, true, false, false, false, false},
+		{23, true, true, false, false, false},
+		{29, true, true, false, false, false},
+		{30, true, false, true, false, false},
+		{40, true, false, true, true, false},
+		{100, true, false, true, true, true},
+	}
+	for _, d := range data {
+		now := day(d.day)
+
+		if v := s.CanChangePassword(now); v != d.canChange {
+			t.Errorf("day %d: CanChangePassword: expected %v", d.day, d.canChange)
+		}
+		if v := s.InWarningPeriod(now); v != d.warning {
+			t.Errorf("day %d: InWarningPeriod: expected %v", d.day, d.warning)
+		}
+		if v := s.MustChangeNow(now); v != d.mustChange {
+			t.Errorf("day %d: MustChangeNow: expected %v", d.day, d.mustChange)
+		}
+		if v := s.IsInactive(now); v != d.inactive {
+			t.Errorf("day %d: IsInactive: expected %v", d.day, d.inactive)
+		}
+		if v := s.AccountExpired(now); v != d.accountExpired {
+			t.Errorf("day %d: AccountExpired: expected %v", d.day, d.accountExpired)
+		}
+	}
+
+	// Empty fields
+	if s, err = parseShadow("u:$6$hash:18000::::::"); err != nil {
+		t.Fatal(err)
+	}
+	now := day(100000)
+	if _, ok := s.PasswordExpiresAt(); ok {
+		t.Error("expected the password to never expire")
+	}
+	if _, ok := s.AccountExpiresAt(); ok {
+		t.Error("expected the account to never expire")
+	}
+	if s.MustChangeNow(now) || s.InWarningPeriod(now) || s.IsInactive(now) ||
+		s.AccountExpired(now) || !s.CanChangePassword(now) {
+		t.Error("expected no restrictions without aging information")
+	}
+
+	// Password aging disabled
+	if s, err = parseShadow("u:$6$hash::5:30:7:10::"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.PasswordExpiresAt(); ok || s.MustChangeNow(now) || !s.CanChangePassword(now) {
+		t.Error("expected password aging disabled")
+	}
+
+	// The user must change the password.
+	if s, err = parseShadow("u:$6$hash:0:5:30:7:10::"); err != nil {
+		t.Fatal(err)
+	}
+	if !s.MustChangeNow(day(0)) || !s.CanChangePassword(day(0)) || s.IsInactive(now) {
+		t.Error("expected to must change the password")
+	}
+	if at, ok := s.PasswordExpiresAt(); !ok || at.Unix() != 0 {
+		t.Errorf("PasswordExpiresAt: expected Jan 1 1970, got %s", at)
+	}
+
+	// The maximum password age is lower than the minimum one.
+	if s, err = parseShadow("u:$6$hash:18000:30:5::::"); err != nil {
+		t.Fatal(err)
+	}
+	if s.CanChangePassword(now) {
+		t.Error("expected to can not change the password")
+	}
+}
+
+func TestChAge(t *testing.T) {
+	const name = "u_chage"
+
+	if _, err := testDB.AddUser(name, GID); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := testDB.DelUser(name); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	lastChange, min, max, warn, inactive := 18000, 1, 60, 5, 10
+	expire := Day(time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	err := testDB.ChAge(name, &AgeMod{
+		LastChange: &lastChange,
+		Min:        &min,
+		Max:        &max,
+		Warn:       &warn,
+		Inactive:   &inactive,
+		Expire:     &expire,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := testDB.LookupShadow(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.changed != 18000 || s.Min != min || s.Max != max || s.Warn != warn ||
+		s.Inactive != inactive || s.expire != expire {
+		t.Fatalf("unexpected aging: %s", s)
+	}
+
+	never := Never
+	if err = testDB.ChAge(name, &AgeMod{Max: &never, Expire: &never}); err != nil {
+		t.Fatal(err)
+	}
+	if s, err = testDB.LookupShadow(name); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.PasswordExpiresAt(); ok {
+		t.Error("expected the password to never expire")
+	}
+	if _, ok := s.AccountExpiresAt(); ok {
+		t.Error("expected the account to never expire")
+	}
+	if s.Min != min || s.changed != 18000 {
+		t.Error("expected to keep the fields not set")
+	}
+
+	if err = testDB.ChAge(name, &AgeMod{LastChange: &never}); err != nil {
+		t.Fatal(err)
+	}
+	if s, err = testDB.LookupShadow(name); err != nil {
+		t.Fatal(err)
+	}
+	if s.changed != _DISABLE_AGING {
+		t.Errorf("expected to disable the password aging, got %q", s.changed)
+	}
+
+	bad := -2
+	if err = testDB.ChAge(name, &AgeMod{Warn: &bad}); err == nil {
+		t.Error("expected error for a negative value")
+	}
+	if err = testDB.ChAge("u_chage_nofound", &AgeMod{Min: &min}); err == nil {
+		t.Error("expected error for an user not found")
+	}
+}
+
+func TestAgingZero(t *testing.T) {
+	const row = "bob:*:18000:0:0:0:0:0:"
+	now := dayToTime(18001)
+
+	s, err := parseShadow(row)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.String() != row+"\n" {
+		t.Errorf("expected to keep the zeros, got: %s", s)
+	}
+
+	if at, ok := s.AccountExpiresAt(); !ok || at.Unix() != 0 || !s.AccountExpired(now) {
+		t.Errorf("Expire 0: expected the account expired, got %s, %v", at, ok)
+	}
+	if at, ok := s.PasswordExpiresAt(); !ok || !at.Equal(dayToTime(18000)) || !s.MustChangeNow(now) {
+		t.Errorf("Max 0: expected the password expired, got %s, %v", at, ok)
+	}
+	if at, ok := s.PasswordInactiveAt(); !ok || !at.Equal(dayToTime(18000)) || !s.IsInactive(now) {
+		t.Errorf("Inactive 0: expected the password inactive, got %s, %v", at, ok)
+	}
+
+	// Empty fields
+	if s, err = parseShadow("bob:*:18000::::::"); err != nil {
+		t.Fatal(err)
+	}
+	if s.Min != Never || s.Max != Never || s.Warn != Never || s.Inactive != Never ||
+		s.expire != Never || s.flag != Never {
+		t.Errorf("expected the empty fields like Never, got: %+v", s)
+	}
+	if s.String() != "bob:*:18000::::::\n" {
+		t.Errorf("expected to keep the empty fields, got: %s", s)
+	}
+	if s.AccountExpired(now) || s.MustChangeNow(now) || s.IsInactive(now) {
+		t.Error("expected no restrictions with empty fields")
+	}
+}
+
+func TestChAgeZero(t *testing.T) {
+	db := newTestDB(t, map[string]string{
+		fileLogin:  "ENCRYPT_METHOD SHA512\n",
+		fileUser:   "bob:x:1000:1000::/home/bob:/bin/sh\n",
+		fileShadow: "bob:*:18000::99999:7:::\n",
+	})
+
+	zero, never := 0, Never
+	for _, v := range []struct {
+		mod      AgeMod
+		expected string
+	}{
+		{AgeMod{Expire: &zero}, "bob:*:18000::99999:7::0:\n"},
+		{AgeMod{Inactive: &zero}, "bob:*:18000::99999:7:0:0:\n"},
+		{AgeMod{Max: &zero, Min: &zero, Warn: &zero}, "bob:*:18000:0:0:0:0:0:\n"},
+		{AgeMod{Max: &never, Min: &never, Warn: &never}, "bob:*:18000::::0:0:\n"},
+		{AgeMod{Inactive: &never, Expire: &never}, "bob:*:18000::::::\n"},
+	} {
+		mod := v.mod
+		if err := db.ChAge("bob", &mod); err != nil {
+			t.Fatal(err)
+		}
+		s, err := db.LookupShadow("bob")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.String() != v.expected {
+			t.Errorf("got %q, expected %q", s, v.expected)
+		}
+	}
+
+	s, err := db.LookupShadow("bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.AccountExpiresAt(); ok {
+		t.Error("expected the account to never expire")
+	}
+
+	// A nil AgeMod does not change anything.
+	if err := db.ChAge("bob", nil); err != nil {
+		t.Fatal(err)
+	}
+	if s, err := db.LookupShadow("bob"); err != nil || s.String() != "bob:*:18000::::::\n" {
+		t.Errorf("expected to not change the aging, got: %v, %v", s, err)
+	}
+}
@@ -0,0 +1,416 @@
+// Copyright 2021 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// Dry-run and audit of the changes
+
+package user
+
+import (
+	"fmt"
+	"strings"
+)
+
+// redactedHash is the value which replaces the hashed passwords in the rows
+// shown by the diffs and the audit events.
+const redactedHash = "REDACTED"
+
+// An AuditEvent represents the change of an entry in a file of the database.
+type AuditEvent struct {
+	Action Action
+	File   string // Name of the file, into the root directory of the database.
+	Name   string // Name of the user or group.
+
+	// Before and After are the rows of the entry, with the hashed passwords
+	// redacted. Before is empty at adding the entry, and After at removing it.
+	// The files of subordinate ids could have several rows for an user, which
+	// are separated by newlines.
+	Before string
+	After  string
+}
+
+func (e *AuditEvent) String() string {
+	return fmt.Sprintf("%s %s: %s", e.Action, e.File, e.Name)
+}
+
+// An AuditHook receives the events of the changes written by a transaction,
+// once it has been committed and its locks released; so the hook can use the
+// database, but other transaction could have changed it in the meanwhile.
+// It is called at the end of Commit, in the same goroutine.
+type AuditHook func(events []*AuditEvent)
+
+// SetAuditHook sets the hook which receives the changes written in the database
+// of the system; nil removes it.
+func SetAuditHook(h AuditHook) { defaultDB.SetAuditHook(h) }
+
+// SetAuditHook sets the hook which receives the changes written in the
+// database; nil removes it.
+// It has to be set before of using the database, since it is not synchronized.
+func (db *DB) SetAuditHook(h AuditHook) { db.audit = h }
+
+// Events returns the changes staged in the transaction, by entry.
+// The files are in the order "passwd", "group", "shadow", "gshadow", "subuid"
+// and "subgid".
+func (tx *Tx) Events() []*AuditEvent {
+	events := make([]*AuditEvent, 0)
+
+	for i := len(commitOrder) - 1; i >= 0; i-- {
+		f := tx.files[commitOrder[i]]
+		if f == nil || !f.changed {
+			continue
+		}
+		events = append(events, f.events(tx.db.path(f.name))...)
+	}
+	return events
+}
+
+// events returns the changes of the entries of the file, comparing its original
+// content with the one staged.
+func (f *txFile) events(filename string) []*AuditEvent {
+	names, before := entryRows(splitLines(f.orig))
+	newNames, after := entryRows(f.lines)
+
+	for _, name := range newNames {
+		if _, ok := before[name]; !ok {
+			names = append(names, name)
+		}
+	}
+
+	events := make([]*AuditEvent, 0)
+	for _, name := range names {
+		old, cur := before[name], after[name]
+		if equalList(old, cur) {
+			continue
+		}
+
+		e := &AuditEvent{
+			Action: ActionUpdate,
+			File:   filename,
+			Name:   name,
+			Before: f.redact(old),
+			After:  f.redact(cur),
+		}
+		if len(old) == 0 {
+			e.Action = ActionAdd
+		} else if len(cur) == 0 {
+			e.Action = ActionRemove
+		}
+		events = append(events, e)
+	}
+	return events
+}
+
+// entryRows returns the rows of every entry of the lines, and the names of the
+// entries in the order they are found. The comments are skipped.
+func entryRows(lines []string) (names []string, rows map[string][]string) {
+	names = make([]string, 0, len(lines))
+	rows = make(map[string][]string, len(lines))
+
+	for _, line := range lines {
+		if isComment(line) {
+			continue
+		}
+		name := line
+		if i := strings.IndexByte(line, ':'); i != -1 {
+			name = line[:i]
+		}
+		if _, ok := rows[name]; !ok {
+			names = append(names, name)
+		}
+		rows[name] = append(rows[name], line)
+	}
+	return
+}
+
+// redact returns the rows joined by newlines, with the hashed passwords
+// redacted.
+func (f *txFile) redact(rows []string) string {
+	redacted := make([]string, len(rows))
+	for i, row := range rows {
+		redacted[i] = f.redactRow(row)
+	}
+	return strings.Join(redacted, "\n")
+}
+
+// redactRow returns the row with the hashed password redacted, whether it is of
+// the files of users or groups; the password is not shadowed in those files at
+// times. The values which are not a hash, like "x", "*" or "!", and the
+// character of locking are kept.
+func (f *txFile) redactRow(row string) string {
+	switch f.name {
+	case fileUser, fileGroup, fileShadow, fileGShadow:
+	default:
+		return row
+	}
+	if isComment(row) {
+		return row
+	}
+
+	fields := strings.SplitN(row, ":", 3)
+	if len(fields) < 3 {
+		return row
+	}
+
+	hash := strings.TrimLeft(fields[1], string(lockChar))
+	switch hash {
+	case "", "*", "x":
+		return row
+	}
+	fields[1] = fields[1][:len(fields[1])-len(hash)] + redactedHash
+	return strings.Join(fields, ":")
+}
+
+// == Dry-run
+//
+
+// A FileDiff represents the changes of a file of the database.
+type FileDiff struct {
+	File string // Name of the file, into the root directory of the database.
+
+	// Diff are the changes in the unified format, with the hashed passwords
+	// redacted.
+	Diff string
+}
+
+// DryRun runs fn into a transaction on the database of the system, returning
+// the changes which would be written. The transaction is rolled back.
+func DryRun(fn func(tx *Tx) error) ([]*FileDiff, error) { return defaultDB.DryRun(fn) }
+
+// DryRun runs fn into a transaction, returning the changes which would be
+// written. The transaction is rolled back, so neither the files nor the home
+// directories are changed.
+//
+//	diffs, err := db.DryRun(func(tx *user.Tx) error {
+//		return tx.DelUser("foo")
+//	})
+func (db *DB) DryRun(fn func(tx *Tx) error) ([]*FileDiff, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err = fn(tx); err != nil {
+		return nil, err
+	}
+	if err = tx.validate(); err != nil {
+		return nil, err
+	}
+	return tx.Diff(), nil
+}
+
+// Diff returns the changes staged in the transaction, by file.
+// The files are in the order of Events.
+func (tx *Tx) Diff() []*FileDiff {
+	diffs := make([]*FileDiff, 0)
+
+	for i := len(commitOrder) - 1; i >= 0; i-- {
+		f := tx.files[commitOrder[i]]
+		if f == nil || !f.changed {
+			continue
+		}
+
+		filename := tx.db.path(f.name)
+		origName := filename
+		if f.missing {
+			origName = "/dev/null"
+		}
+
+		diff := unifiedDiff(origName, filename, splitLines(f.orig), f.lines, f.redactRow)
+		if diff != "" {
+			diffs = append(diffs, &FileDiff{File: filename, Diff: diff})
+		}
+	}
+	return diffs
+}
+
+// diffContext is the number of lines of context around the changes.
+const diffContext = 3
+
+// A diffLine represents a line of a diff: ' ' for the lines in both sides, '-'
+// for the ones removed and '+' for the ones added.
+type diffLine struct {
+	kind byte
+	text string
+}
+
+// unifiedDiff returns the changes from the lines a to the lines b, in the
+// unified format; it is empty whether there are no changes.
+// The function show is applied to the lines before of writing them.
+func unifiedDiff(nameA, nameB string, a, b []string, show func(string) string) string {
+	lines := diffLines(a, b)
+
+	// Positions of the lines changed.
+	changes := make([]int, 0)
+	for i, l := range lines {
+		if l.kind != ' ' {
+			changes = append(changes, i)
+		}
+	}
+	if len(changes) == 0 {
+		return ""
+	}
+
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "--- %s\n+++ %s\n", nameA, nameB)
+
+	for i := 0; i < len(changes); {
+		// The changes which are near are joined into a hunk.
+		j := i
+		for j+1 < len(changes) && changes[j+1]-changes[j] <= 2*diffContext+1 {
+			j++
+		}
+		start := changes[i] - diffContext
+		if start < 0 {
+			start = 0
+		}
+		end := changes[j] + diffContext + 1
+		if end > len(lines) {
+			end = len(lines)
+		}
+
+		// Lines of each side before of the hunk.
+		posA, posB := 0, 0
+		for _, l := range lines[:start] {
+			if l.kind != '+' {
+				posA++
+			}
+			if l.kind != '-' {
+				posB++
+			}
+		}
+		countA, countB := 0, 0
+		for _, l := range lines[start:end] {
+			if l.kind != '+' {
+				countA++
+			}
+			if l.kind != '-' {
+				countB++
+			}
+		}
+
+		fmt.Fprintf(&buf, "@@ -%s +%s @@\n", hunkRange(posA, countA), hunkRange(posB, countB))
+		for _, l := range lines[start:end] {
+			buf.WriteByte(l.kind)
+			buf.WriteString(show(l.text))
+			buf.WriteByte('\n')
+		}
+		i = j + 1
+	}
+	return buf.String()
+}
+
+// hunkRange returns the range of lines of a side of a hunk, like "diff -u":
+// the count is omitted when it is 1, and the start is the line before of the
+// hunk when it is empty.
+func hunkRange(pos, count int) string {
+	switch count {
+	case 0:
+		return fmt.Sprintf("%d,0", pos)
+	case 1:
+		return fmt.Sprintf("%d", pos+1)
+	}
+	return fmt.Sprintf("%d,%d", pos+1, count)
+}
+
+// diffLines returns the lines of both sides, marking the ones removed from a
+// and the ones added into b, with the minimal changes.
+//
+// It uses the algorithm of Myers, "An O(ND) Difference Algorithm and Its
+// Variations", keeping the furthest paths of every step to walk them back; so
+// the memory is bounded by the square of the number of lines changed.
+func diffLines(a, b []string) []diffLine {
+	// The lines in common at the beginning and at the end are skipped, since
+	// the changes of a transaction are usually few.
+	pre := 0
+	for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < len(a)-pre && suf < len(b)-pre && a[len(a)-1-suf] == b[len(b)-1-suf] {
+		suf++
+	}
+	midA, midB := a[pre:len(a)-suf], b[pre:len(b)-suf]
+	n, m := len(midA), len(midB)
+
+	// v[offset+k] is the furthest position in midA of a path on the diagonal k,
+	// where k = x-y. trace[d] keeps the diagonals from -d to d after of the step
+	// d.
+	offset := n + m + 1
+	v := make([]int, 2*offset+1)
+	trace := make([][]int, 0)
+
+	for d := 0; d <= n+m; d++ {
+		found := false
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1] // Line added.
+			} else {
+				x = v[offset+k-1] + 1 // Line removed.
+			}
+			y := x - k
+			for x < n && y < m && midA[x] == midB[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+
+			if x >= n && y >= m {
+				found = true
+				break
+			}
+		}
+		trace = append(trace, append([]int(nil), v[offset-d:offset+d+1]...))
+		if found {
+			break
+		}
+	}
+
+	// Walk the path back, from the end.
+	mid := make([]diffLine, 0, n+m)
+	x, y := n, m
+	for d := len(trace) - 1; d > 0; d-- {
+		prev := trace[d-1] // Diagonals from -(d-1).
+		k := x - y
+
+		var prevK int
+		if k == -d || (k != d && prev[k-1+d-1] < prev[k+1+d-1]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := prev[prevK+d-1]
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			x--
+			y--
+			mid = append(mid, diffLine{' ', midA[x]})
+		}
+		if prevK == k+1 {
+			mid = append(mid, diffLine{'+', midB[prevY]})
+		} else {
+			mid = append(mid, diffLine{'-', midA[prevX]})
+		}
+		x, y = prevX, prevY
+	}
+	for x > 0 {
+		x--
+		mid = append(mid, diffLine{' ', midA[x]})
+	}
+
+	lines := make([]diffLine, 0, len(a)+len(b))
+	for _, v := range a[:pre] {
+		lines = append(lines, diffLine{' ', v})
+	}
+	for i := len(mid) - 1; i >= 0; i-- {
+		lines = append(lines, mid[i])
+	}
+	for _, v := range a[len(a)-suf:] {
+		lines = append(lines, diffLine{' ', v})
+	}
+	return lines
+}
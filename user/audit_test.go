@@ -0,0 +1,259 @@
+// Copyright 2021 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package user
+
+import (
+	"io/ioutil"
+	"math/rand"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDryRun(t *testing.T) {
+	const userData = "u1:x:1000:1000::/home/u1:/bin/sh\nu2:x:1001:1000::/home/u2:/bin/sh\n"
+
+	db := newTestDB(t, map[string]string{
+		fileLogin:   "ENCRYPT_METHOD SHA512\nUID_MIN 1000\nUID_MAX 60000\nGID_MIN 1000\nGID_MAX 60000\n",
+		fileUser:    userData,
+		fileGroup:   "g1:x:1000:u1,u2\n",
+		fileShadow:  "u1:$6$salt$hash:18000:0:99999:7:::\nu2:*:18000:0:99999:7:::\n",
+		fileGShadow: "g1:!::u1,u2\n",
+	})
+
+	diffs, err := db.DryRun(func(tx *Tx) error { return tx.DelUser("u1") })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := ioutil.ReadFile(db.path(fileUser)); string(data) != userData {
+		t.Errorf("expected to not change the file, got:\n%s", data)
+	}
+
+	files := make([]string, len(diffs))
+	for i, d := range diffs {
+		files[i] = d.File
+	}
+	if len(diffs) != 2 || diffs[0].File != db.path(fileUser) {
+		t.Fatalf("unexpected files changed: %v", files)
+	}
+	expected := "--- " + db.path(fileUser) + "\n+++ " + db.path(fileUser) + "\n" +
+		"@@ -1,2 +1 @@\n" +
+		"-u1:x:1000:1000::/home/u1:/bin/sh\n" +
+		" u2:x:1001:1000::/home/u2:/bin/sh\n"
+	if diffs[0].Diff != expected {
+		t.Errorf("unexpected diff:\n%s\nexpected:\n%s", diffs[0].Diff, expected)
+	}
+	for _, d := range diffs {
+		if strings.Contains(d.Diff, "$6$") {
+			t.Errorf("expected the hash redacted:\n%s", d.Diff)
+		}
+	}
+
+	if _, err = db.DryRun(func(tx *Tx) error { return tx.DelUser("u3") }); err == nil {
+		t.Error("expected to report the error of the transaction")
+	}
+}
+
+func TestAuditHook(t *testing.T) {
+	db := newTestDB(t, map[string]string{
+		fileLogin:   "ENCRYPT_METHOD SHA512\nUID_MIN 1000\nUID_MAX 60000\nGID_MIN 1000\nGID_MAX 60000\n",
+		fileUser:    "u1:x:1000:1000::/home/u1:/bin/sh\n",
+		fileGroup:   "g1:x:1000:\n",
+		fileShadow:  "u1:!$6$salt$hash:18000:0:99999:7:::\n",
+		fileGShadow: "g1:!::\n",
+	})
+
+	var events []*AuditEvent
+	db.SetAuditHook(func(e []*AuditEvent) { events = append(events, e...) })
+
+	if err := db.AddUsersToGroup("g1", "u1"); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, got: %v", events)
+	}
+	e := events[0]
+	if e.Action != ActionUpdate || e.File != db.path(fileGroup) || e.Name != "g1" ||
+		e.Before != "g1:x:1000:" || e.After != "g1:x:1000:u1" {
+		t.Errorf("unexpected event: %+v", e)
+	}
+
+	events = nil
+	if err := db.DelUser("u1"); err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range events {
+		if e.File == db.path(fileShadow) {
+			if e.Action != ActionRemove || e.Before != "u1:!"+redactedHash+":18000:0:99999:7:::" {
+				t.Errorf("unexpected event: %+v", e)
+			}
+			return
+		}
+	}
+	t.Errorf("expected an event of the shadowed file, got: %v", events)
+}
+
+func TestAuditHookUseDB(t *testing.T) {
+	db := newTestDB(t, map[string]string{
+		fileLogin:   "ENCRYPT_METHOD SHA512\nUID_MIN 1000\nUID_MAX 60000\nGID_MIN 1000\nGID_MAX 60000\n",
+		fileUser:    "u1:x:1000:1000::/home/u1:/bin/sh\n",
+		fileGroup:   "g1:x:1000:\ng2:x:1001:\n",
+		fileShadow:  "u1:*:18000:0:99999:7:::\n",
+		fileGShadow: "g1:!::\ng2:!::\n",
+	})
+
+	calls := 0
+	hookErr := make(chan error, 2)
+	db.SetAuditHook(func([]*AuditEvent) {
+		calls++
+		if calls > 1 {
+			return
+		}
+		if _, err := db.LookupUser("u1"); err != nil {
+			hookErr <- err
+		}
+		hookErr <- db.AddUsersToGroup("g2", "u1")
+	})
+
+	done := make(chan error, 1)
+	go func() { done <- db.AddUsersToGroup("g1", "u1") }()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected to call the hook without the locks")
+	}
+	if err := <-hookErr; err != nil {
+		t.Fatal(err)
+	}
+	if calls != 2 {
+		t.Errorf("expected 2 calls of the hook, got: %d", calls)
+	}
+	if gr, err := db.LookupGroup("g2"); err != nil || len(gr.UserList) != 1 {
+		t.Errorf("expected the change done by the hook, got: %+v, %v", gr, err)
+	}
+}
+
+func TestRedactNotShadowed(t *testing.T) {
+	db := newTestDB(t, map[string]string{
+		fileLogin:   "ENCRYPT_METHOD SHA512\nUID_MIN 1000\nUID_MAX 60000\nGID_MIN 1000\nGID_MAX 60000\n",
+		fileUser:    "u1:$6$abc$secret:1000:1000::/home/u1:/bin/sh\nu2:x:1001:1000::/home/u2:/bin/sh\n",
+		fileGroup:   "g1:$6$abc$secret:1000:u1,u2\n",
+		fileShadow:  "u1:*:18000:0:99999:7:::\nu2:*:18000:0:99999:7:::\n",
+		fileGShadow: "g1:!::u1,u2\n",
+	})
+
+	var events []*AuditEvent
+	db.SetAuditHook(func(e []*AuditEvent) { events = append(events, e...) })
+
+	diffs, err := db.DryRun(func(tx *Tx) error { return tx.DelUser("u1") })
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range diffs {
+		if strings.Contains(d.Diff, "secret") {
+			t.Errorf("expected the hash redacted:\n%s", d.Diff)
+		}
+	}
+
+	if err = db.DelUser("u1"); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) == 0 {
+		t.Fatal("expected events")
+	}
+	for _, e := range events {
+		if strings.Contains(e.Before, "secret") || strings.Contains(e.After, "secret") {
+			t.Errorf("expected the hash redacted: %+v", e)
+		}
+	}
+
+	f := &txFile{name: fileUser}
+	if row := f.redactRow("u2:x:1001:1000::/home/u2:/bin/sh"); row != "u2:x:1001:1000::/home/u2:/bin/sh" {
+		t.Errorf("expected to keep the value not hashed, got: %s", row)
+	}
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	a := []string{"1", "2", "3", "4", "5", "6", "7", "8", "9", "10", "11", "12"}
+	b := []string{"0", "1", "2", "3", "4", "5", "6", "7", "8", "9", "10", "x"}
+	show := func(s string) string { return s }
+
+	expected := "--- a\n+++ b\n" +
+		"@@ -1,3 +1,4 @@\n+0\n 1\n 2\n 3\n" +
+		"@@ -8,5 +9,4 @@\n 8\n 9\n 10\n-11\n-12\n+x\n"
+	if diff := unifiedDiff("a", "b", a, b, show); diff != expected {
+		t.Errorf("got:\n%s\nexpected:\n%s", diff, expected)
+	}
+	if diff := unifiedDiff("a", "b", nil, []string{"1"}, show); diff != "--- a\n+++ b\n@@ -0,0 +1 @@\n+1\n" {
+		t.Errorf("unexpected diff from an empty file:\n%s", diff)
+	}
+	if diff := unifiedDiff("a", "b", a, a, show); diff != "" {
+		t.Errorf("expected no diff, got:\n%s", diff)
+	}
+}
+
+func TestDiffLines(t *testing.T) {
+	// lcs returns the length of the longest common subsequence.
+	lcs := func(a, b []string) int {
+		l := make([][]int, len(a)+1)
+		for i := range l {
+			l[i] = make([]int, len(b)+1)
+		}
+		for i := len(a) - 1; i >= 0; i-- {
+			for j := len(b) - 1; j >= 0; j-- {
+				switch {
+				case a[i] == b[j]:
+					l[i][j] = l[i+1][j+1] + 1
+				case l[i+1][j] >= l[i][j+1]:
+					l[i][j] = l[i+1][j]
+				default:
+					l[i][j] = l[i][j+1]
+				}
+			}
+		}
+		return l[0][0]
+	}
+
+	rnd := rand.New(rand.NewSource(1))
+	lines := func() []string {
+		list := make([]string, rnd.Intn(12))
+		for i := range list {
+			list[i] = strconv.Itoa(rnd.Intn(4))
+		}
+		return list
+	}
+
+	for i := 0; i < 500; i++ {
+		a, b := lines(), lines()
+		var gotA, gotB []string
+		changes := 0
+
+		for _, l := range diffLines(a, b) {
+			if l.kind != '+' {
+				gotA = append(gotA, l.text)
+			}
+			if l.kind != '-' {
+				gotB = append(gotB, l.text)
+			}
+			if l.kind != ' ' {
+				changes++
+			}
+		}
+		if strings.Join(gotA, ",") != strings.Join(a, ",") ||
+			strings.Join(gotB, ",") != strings.Join(b, ",") {
+			t.Fatalf("diff of %v and %v: wrong sides %v, %v", a, b, gotA, gotB)
+		}
+		if min := len(a) + len(b) - 2*lcs(a, b); changes != min {
+			t.Fatalf("diff of %v and %v: %d changes, expected %d", a, b, changes, min)
+		}
+	}
+}
@@ -0,0 +1,483 @@
+// Copyright 2021 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package user
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ProblemKind represents the kind of a problem found at checking the files of
+// the accounts.
+type ProblemKind int
+
+const (
+	ProblemMalformed     ProblemKind = iota + 1 // Row with a format not valid.
+	ProblemDuplicateName                        // Name used in several rows.
+	ProblemDuplicateID                          // UID or GID used in several rows.
+	ProblemNoShadow                             // Entry with passwd "x" but without shadowed entry.
+	ProblemNoEntry                              // Shadowed entry without its user or group.
+	ProblemNoGroup                              // Primary group of an user which does not exist.
+	ProblemNoMember                             // Member or administrator of a group which does not exist.
+	ProblemNoHome                               // Home directory which does not exist.
+	ProblemInvalidShell                         // Shell which does not exist or is not executable.
+)
+
+func (k ProblemKind) String() string {
+	switch k {
+	case ProblemMalformed:
+		return "malformed row"
+	case ProblemDuplicateName:
+		return "duplicate name"
+	case ProblemDuplicateID:
+		return "duplicate id"
+	case ProblemNoShadow:
+		return "no shadowed entry"
+	case ProblemNoEntry:
+		return "no matching entry"
+	case ProblemNoGroup:
+		return "no group"
+	case ProblemNoMember:
+		return "no member"
+	case ProblemNoHome:
+		return "no home directory"
+	case ProblemInvalidShell:
+		return "invalid shell"
+	}
+	return "ProblemKind(" + strconv.Itoa(int(k)) + ")"
+}
+
+// A Problem represents an inconsistency found in the files of the accounts,
+// like "pwck(8)" and "grpck(8)" report.
+type Problem struct {
+	Kind ProblemKind
+	File string // Path of the file.
+	Line int    // Number of the line, starting at 1.
+
+	// Name of the user or group of the row, if it could be got.
+	Name string
+
+	// Value which causes the problem: the row malformed, the id, the member,
+	// the home directory or the shell.
+	Value string
+
+	// Fixed reports whether the problem was repaired.
+	Fixed bool
+}
+
+func (p *Problem) String() string {
+	s := fmt.Sprintf("%s:%d: %s: %q", p.File, p.Line, p.Kind, p.Name)
+	if p.Value != "" {
+		s += ": " + p.Value
+	}
+	if p.Fixed {
+		s += " (fixed)"
+	}
+	return s
+}
+
+// CheckOptions represents the options to check the files of the accounts.
+type CheckOptions struct {
+	// Fix repairs the problems which are safe to repair:
+	//
+	//   - it adds the shadowed entries not found, with the passwd locked;
+	//   - it removes the shadowed entries without their user or group;
+	//   - it removes the members and administrators of groups which do not
+	//     exist.
+	//
+	// The rest of problems have to be repaired by the administrator.
+	Fix bool
+}
+
+// Check checks the files of the accounts of the system, returning the problems
+// found.
+func Check() ([]*Problem, error) { return defaultDB.Check() }
+
+// Check checks the files of the accounts of the database, returning the
+// problems found.
+func (db *DB) Check() ([]*Problem, error) { return db.CheckWithOptions(nil) }
+
+// CheckWithOptions checks the files of the accounts of the system, returning
+// the problems found.
+func CheckWithOptions(opts *CheckOptions) ([]*Problem, error) {
+	return defaultDB.CheckWithOptions(opts)
+}
+
+// CheckWithOptions checks the files of the accounts of the database, returning
+// the problems found. The problems fixed are written at the end.
+func (db *DB) CheckWithOptions(opts *CheckOptions) (problems []*Problem, err error) {
+	err = db.update(func(tx *Tx) (err error) {
+		problems, err = tx.CheckWithOptions(opts)
+		return
+	})
+	return
+}
+
+// Check checks the files staged, returning the problems found.
+func (tx *Tx) Check() ([]*Problem, error) { return tx.CheckWithOptions(nil) }
+
+// CheckWithOptions checks the files staged, returning the problems found.
+// The repairs are staged.
+func (tx *Tx) CheckWithOptions(opts *CheckOptions) ([]*Problem, error) {
+	if opts == nil {
+		opts = &CheckOptions{}
+	}
+	c := &checker{tx: tx}
+
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	c.checkUsers()
+	c.checkShadows()
+	c.checkGroups()
+	c.checkGShadows()
+
+	if opts.Fix {
+		if err := c.fix(); err != nil {
+			return nil, err
+		}
+	}
+	return c.problems, nil
+}
+
+// A checker represents the state to check the files of a transaction.
+type checker struct {
+	tx       *Tx
+	problems []*Problem
+
+	// Rows parsed, the path of their files, and the number of rows by name.
+	users, shadows, groups, gshadows                             []checkRow
+	userFileName, shadowFileName, groupFileName, gshadowFileName string
+	userNames, shadowNames, groupNames, gshadowNames             map[string]int
+
+	// Names of the rows malformed, by path of file.
+	malformed map[string]map[string]bool
+
+	hasShadowFile, hasGShadowFile bool
+
+	// Problems which could be fixed.
+	fixShadow, fixGShadow, fixMembers []*Problem
+}
+
+// A checkRow represents a row parsed, and its number of line.
+type checkRow struct {
+	line  int
+	entry interface{}
+}
+
+// load parses the files, reporting the rows malformed.
+func (c *checker) load() (err error) {
+	c.userNames = make(map[string]int)
+	c.shadowNames = make(map[string]int)
+	c.groupNames = make(map[string]int)
+	c.gshadowNames = make(map[string]int)
+	c.malformed = make(map[string]map[string]bool)
+
+	c.users, c.userFileName, err = c.parse(fileUser, c.userNames,
+		func(row string) (interface{}, error) { return parseUser(row) })
+	if err != nil {
+		return err
+	}
+	c.groups, c.groupFileName, err = c.parse(fileGroup, c.groupNames,
+		func(row string) (interface{}, error) { return parseGroup(row) })
+	if err != nil {
+		return err
+	}
+
+	// Shadowed files are optional.
+	c.shadows, c.shadowFileName, err = c.parse(fileShadow, c.shadowNames,
+		func(row string) (interface{}, error) { return parseShadow(row) })
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	c.hasShadowFile = err == nil
+
+	c.gshadows, c.gshadowFileName, err = c.parse(fileGShadow, c.gshadowNames,
+		func(row string) (interface{}, error) { return parseGShadow(row) })
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	c.hasGShadowFile = err == nil
+
+	return nil
+}
+
+// parse parses the rows of the named file using the function fn, counting the
+// names found. The empty lines and the ones of NIS are skipped.
+func (c *checker) parse(name string, names map[string]int,
+	fn func(row string) (interface{}, error)) ([]checkRow, string, error) {
+
+	f, err := c.tx.file(name)
+	if err != nil {
+		return nil, "", err
+	}
+	filename := c.tx.db.path(name)
+	rows := make([]checkRow, 0, len(f.lines))
+
+	for i, line := range f.lines {
+		if isComment(line) {
+			continue
+		}
+		entryName := strings.SplitN(line, ":", 2)[0]
+
+		entry, err := fn(line)
+		if err != nil {
+			if c.malformed[filename] == nil {
+				c.malformed[filename] = make(map[string]bool)
+			}
+			c.malformed[filename][entryName] = true
+
+			c.report(&Problem{
+				Kind:  ProblemMalformed,
+				File:  filename,
+				Line:  i + 1,
+				Name:  entryName,
+				Value: line,
+			})
+			continue
+		}
+
+		names[entryName]++
+		rows = append(rows, checkRow{i + 1, entry})
+	}
+	return rows, filename, nil
+}
+
+func (c *checker) report(p *Problem) { c.problems = append(c.problems, p) }
+
+// checkUsers checks the users.
+func (c *checker) checkUsers() {
+	gids := make(map[int]bool, len(c.groups))
+	for _, r := range c.groups {
+		gids[r.entry.(*Group).GID] = true
+	}
+	uids := make(map[int]int, len(c.users))
+	for _, r := range c.users {
+		uids[r.entry.(*User).UID]++
+	}
+
+	for _, r := range c.users {
+		u := r.entry.(*User)
+		p := Problem{File: c.userFileName, Line: r.line, Name: u.Name}
+
+		if c.userNames[u.Name] > 1 {
+			c.reportKind(p, ProblemDuplicateName, "")
+		}
+		if uids[u.UID] > 1 {
+			c.reportKind(p, ProblemDuplicateID, strconv.Itoa(u.UID))
+		}
+		if c.hasShadowFile && u.password == "x" && c.shadowNames[u.Name] == 0 {
+			c.fixShadow = append(c.fixShadow, c.reportKind(p, ProblemNoShadow, ""))
+		}
+		if !gids[u.GID] {
+			c.reportKind(p, ProblemNoGroup, strconv.Itoa(u.GID))
+		}
+		if u.Dir != "" && u.Dir != "/nonexistent" {
+			if info, err := os.Stat(c.tx.db.path(u.Dir)); err != nil || !info.IsDir() {
+				c.reportKind(p, ProblemNoHome, u.Dir)
+			}
+		}
+		if u.Shell != "" {
+			if info, err := os.Stat(c.tx.db.path(u.Shell)); err != nil ||
+				!info.Mode().IsRegular() || info.Mode().Perm()&0111 == 0 {
+				c.reportKind(p, ProblemInvalidShell, u.Shell)
+			}
+		}
+	}
+}
+
+// checkShadows checks the shadowed users.
+func (c *checker) checkShadows() {
+	for _, r := range c.shadows {
+		s := r.entry.(*Shadow)
+		p := Problem{File: c.shadowFileName, Line: r.line, Name: s.Name}
+
+		if c.shadowNames[s.Name] > 1 {
+			c.reportKind(p, ProblemDuplicateName, "")
+		}
+		if c.userNames[s.Name] == 0 {
+			c.fixShadow = append(c.fixShadow, c.reportKind(p, ProblemNoEntry, ""))
+		}
+	}
+}
+
+// checkGroups checks the groups.
+func (c *checker) checkGroups() {
+	gids := make(map[int]int, len(c.groups))
+	for _, r := range c.groups {
+		gids[r.entry.(*Group).GID]++
+	}
+
+	for _, r := range c.groups {
+		g := r.entry.(*Group)
+		p := Problem{File: c.groupFileName, Line: r.line, Name: g.Name}
+
+		if c.groupNames[g.Name] > 1 {
+			c.reportKind(p, ProblemDuplicateName, "")
+		}
+		if gids[g.GID] > 1 {
+			c.reportKind(p, ProblemDuplicateID, strconv.Itoa(g.GID))
+		}
+		if c.hasGShadowFile && g.password == "x" && c.gshadowNames[g.Name] == 0 {
+			c.fixGShadow = append(c.fixGShadow, c.reportKind(p, ProblemNoShadow, ""))
+		}
+		c.checkMembers(p, g.UserList)
+	}
+}
+
+// checkGShadows checks the shadowed groups.
+func (c *checker) checkGShadows() {
+	for _, r := range c.gshadows {
+		gs := r.entry.(*GShadow)
+		p := Problem{File: c.gshadowFileName, Line: r.line, Name: gs.Name}
+
+		if c.gshadowNames[gs.Name] > 1 {
+			c.reportKind(p, ProblemDuplicateName, "")
+		}
+		if c.groupNames[gs.Name] == 0 {
+			c.fixGShadow = append(c.fixGShadow, c.reportKind(p, ProblemNoEntry, ""))
+		}
+		c.checkMembers(p, gs.AdminList)
+		c.checkMembers(p, gs.UserList)
+	}
+}
+
+// checkMembers reports the members of the list which are not users.
+func (c *checker) checkMembers(p Problem, members []string) {
+	for _, m := range members {
+		if m != "" && c.userNames[m] == 0 {
+			c.fixMembers = append(c.fixMembers, c.reportKind(p, ProblemNoMember, m))
+		}
+	}
+}
+
+// reportKind reports a copy of the problem p with the given kind and value.
+func (c *checker) reportKind(p Problem, kind ProblemKind, value string) *Problem {
+	p.Kind = kind
+	p.Value = value
+	c.report(&p)
+	return &p
+}
+
+// == Repairs
+//
+
+// fix stages the repairs of the problems which are safe to repair.
+// The repairs are done on the rows already parsed, since the rest ones could be
+// malformed. The entries whose name is duplicated, or used in a row malformed,
+// are skipped, since they could not be found without ambiguity.
+func (c *checker) fix() error {
+	tx := c.tx
+
+	groupOf := make(map[string]*Group, len(c.groups))
+	for _, r := range c.groups {
+		g := r.entry.(*Group)
+		groupOf[g.Name] = g
+	}
+	gshadowOf := make(map[string]*GShadow, len(c.gshadows))
+	for _, r := range c.gshadows {
+		gs := r.entry.(*GShadow)
+		gshadowOf[gs.Name] = gs
+	}
+
+	// The members are removed before of adding the shadowed groups, which get
+	// the members of the group.
+	for _, p := range c.fixMembers {
+		var err error
+
+		switch p.File {
+		case c.groupFileName:
+			if c.ambiguous(p.File, c.groupNames, p.Name) {
+				continue
+			}
+			g := groupOf[p.Name]
+			g.UserList = removeMember(g.UserList, p.Value)
+			err = tx.edit(p.Name, g)
+
+		case c.gshadowFileName:
+			if c.ambiguous(p.File, c.gshadowNames, p.Name) || c.groupNames[p.Name] == 0 {
+				continue
+			}
+			gs := gshadowOf[p.Name]
+			gs.AdminList = removeMember(gs.AdminList, p.Value)
+			gs.UserList = removeMember(gs.UserList, p.Value)
+			err = tx.edit(p.Name, gs)
+		}
+		if err != nil {
+			return err
+		}
+		p.Fixed = true
+	}
+
+	for _, p := range c.fixShadow {
+		switch p.Kind {
+		case ProblemNoShadow:
+			if c.ambiguous(c.userFileName, c.userNames, p.Name) ||
+				c.malformed[c.shadowFileName][p.Name] {
+				continue
+			}
+			s := tx.db.newShadow(p.Name, nil)
+			s.password = string(lockChar)
+			s.setChange()
+			if err := tx.appendRow(p.Name, s); err != nil {
+				return err
+			}
+		case ProblemNoEntry:
+			if c.ambiguous(p.File, c.shadowNames, p.Name) {
+				continue
+			}
+			if err := tx.del(p.Name, &Shadow{}); err != nil {
+				return err
+			}
+		}
+		p.Fixed = true
+	}
+
+	for _, p := range c.fixGShadow {
+		switch p.Kind {
+		case ProblemNoShadow:
+			if c.ambiguous(p.File, c.groupNames, p.Name) ||
+				c.malformed[c.gshadowFileName][p.Name] {
+				continue
+			}
+			gs := tx.db.NewGShadow(p.Name, groupOf[p.Name].UserList...)
+			gs.password = string(lockChar)
+			if err := tx.appendRow(p.Name, gs); err != nil {
+				return err
+			}
+		case ProblemNoEntry:
+			if c.ambiguous(p.File, c.gshadowNames, p.Name) {
+				continue
+			}
+			if err := tx.del(p.Name, &GShadow{}); err != nil {
+				return err
+			}
+		}
+		p.Fixed = true
+	}
+
+	return nil
+}
+
+// ambiguous reports whether the name is used in several rows of the file, some
+// of them malformed.
+func (c *checker) ambiguous(filename string, names map[string]int, name string) bool {
+	return names[name] > 1 || c.malformed[filename][name]
+}
+
+// removeMember returns the list without the given member.
+func removeMember(list []string, name string) []string {
+	newList := make([]string, 0, len(list))
+	for _, v := range list {
+		if v != name {
+			newList = append(newList, v)
+		}
+	}
+	return newList
+}
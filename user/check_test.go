@@ -0,0 +1,171 @@
+// Copyright 2021 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package user
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	db := newTestDB(t, map[string]string{
+		fileLogin: "ENCRYPT_METHOD SHA512\n",
+		fileUser: "root:x:0:0:root:/root:/bin/sh\n" +
+			"u1:x:1000:1000::/home/u1:/bin/sh\n" +
+			"u2:x:1000:1000::/home/u2:/bin/nosh\n" +
+			"u3:x:1001:2000::/home/u3:/bin/sh\n" +
+			"bad:x:abc:0::/:/bin/sh\n" +
+			"u1:x:1002:1000::/home/u1:/bin/sh\n",
+		fileShadow: "root:*:18000:0:99999:7:::\n" +
+			"u1:*:18000:0:99999:7:::\n" +
+			"u3:*:18000:0:99999:7:::\n" +
+			"ghost:*:18000:0:99999:7:::\n",
+		fileGroup: "root:x:0:\n" +
+			"g1:x:1000:u1,nobody\n" +
+			"g2:x:1000:\n",
+		fileGShadow: "root:!::\n" +
+			"g1:!:nobody:u1\n" +
+			"gold:!::\n",
+	})
+
+	for _, dir := range []string{"/root", "/home/u1", "/home/u3", "/bin"} {
+		if err := os.MkdirAll(db.path(dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(db.path("/bin/sh"), nil, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	problems, err := db.Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []struct {
+		kind  ProblemKind
+		file  string
+		line  int
+		name  string
+		value string
+	}{
+		{ProblemMalformed, fileUser, 5, "bad", "bad:x:abc:0::/:/bin/sh"},
+		{ProblemDuplicateName, fileUser, 2, "u1", ""},
+		{ProblemDuplicateID, fileUser, 2, "u1", "1000"},
+		{ProblemDuplicateID, fileUser, 3, "u2", "1000"},
+		{ProblemNoShadow, fileUser, 3, "u2", ""},
+		{ProblemNoHome, fileUser, 3, "u2", "/home/u2"},
+		{ProblemInvalidShell, fileUser, 3, "u2", "/bin/nosh"},
+		{ProblemNoGroup, fileUser, 4, "u3", "2000"},
+		{ProblemDuplicateName, fileUser, 6, "u1", ""},
+		{ProblemNoEntry, fileShadow, 4, "ghost", ""},
+		{ProblemNoMember, fileGroup, 2, "g1", "nobody"},
+		{ProblemDuplicateID, fileGroup, 2, "g1", "1000"},
+		{ProblemDuplicateID, fileGroup, 3, "g2", "1000"},
+		{ProblemNoShadow, fileGroup, 3, "g2", ""},
+		{ProblemNoMember, fileGShadow, 2, "g1", "nobody"},
+		{ProblemNoEntry, fileGShadow, 3, "gold", ""},
+	}
+
+	found := make(map[Problem]bool, len(problems))
+	for _, p := range problems {
+		found[*p] = true
+	}
+	for _, e := range expected {
+		p := Problem{Kind: e.kind, File: db.path(e.file), Line: e.line, Name: e.name, Value: e.value}
+		if !found[p] {
+			t.Errorf("expected problem: %s", &p)
+		}
+		delete(found, p)
+	}
+	for p := range found {
+		t.Errorf("unexpected problem: %s", &p)
+	}
+
+	// Repairs
+	if problems, err = db.CheckWithOptions(&CheckOptions{Fix: true}); err != nil {
+		t.Fatal(err)
+	}
+	nFixed := 0
+	for _, p := range problems {
+		if p.Fixed {
+			nFixed++
+		}
+	}
+	if nFixed != 6 {
+		t.Errorf("expected 6 problems fixed, got %d: %v", nFixed, problems)
+	}
+
+	if problems, err = db.Check(); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range problems {
+		switch p.Kind {
+		case ProblemNoShadow, ProblemNoEntry, ProblemNoMember:
+			t.Errorf("expected to be fixed: %s", p)
+		}
+	}
+
+	if _, err = db.LookupShadow("u2"); err != nil {
+		t.Error(err)
+	}
+	if gs, err := db.LookupGShadow("g2"); err != nil {
+		t.Error(err)
+	} else if gs.password != "!" {
+		t.Errorf("expected the passwd locked, got %q", gs.password)
+	}
+	if g, err := db.LookupGroup("g1"); err != nil {
+		t.Error(err)
+	} else if len(g.UserList) != 1 || g.UserList[0] != "u1" {
+		t.Errorf("expected to remove the member, got %v", g.UserList)
+	}
+}
+
+func TestCheckFixMalformed(t *testing.T) {
+	db := newTestDB(t, map[string]string{
+		fileLogin:   "ENCRYPT_METHOD SHA512\n",
+		fileUser:    "u1:x:1000:1000::/:/bin/sh\n",
+		fileShadow:  "u1:*:18000:0:99999:7:::\n",
+		fileGroup:   "broken:x\ng1:x:1000:u1,nobody\ng2:x:1001:\n",
+		fileGShadow: "g1:!::u1,nobody\nbroken\n",
+	})
+
+	problems, err := db.CheckWithOptions(&CheckOptions{Fix: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	malformed, fixed := 0, 0
+	for _, p := range problems {
+		if p.Kind == ProblemMalformed {
+			malformed++
+		}
+		if p.Fixed {
+			fixed++
+		}
+	}
+	// The members "nobody" of g1, and the shadowed entry of g2.
+	if malformed != 2 || fixed != 3 {
+		t.Errorf("unexpected problems: %v", problems)
+	}
+
+	data, err := ioutil.ReadFile(db.path(fileGroup))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "broken:x\ng1:x:1000:u1\ng2:x:1001:\n" {
+		t.Errorf("unexpected group file:\n%s", data)
+	}
+	data, err = ioutil.ReadFile(db.path(fileGShadow))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "g1:!::u1\nbroken\ng2:!::\n" {
+		t.Errorf("unexpected gshadow file:\n%s", data)
+	}
+}
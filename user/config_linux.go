@@ -8,6 +8,8 @@ package user
 
 import (
 	"fmt"
+	"os"
+	"regexp"
 	"strings"
 	"sync"
 
@@ -16,7 +18,7 @@ import (
 	"github.com/tredoe/osutil/user/crypt"
 )
 
-// TODO: handle des, bcrypt and rounds in SHA2.
+// TODO: handle des and rounds in SHA2.
 
 // TODO: Idea: store struct "configData" to run configData.Init() only when
 // the configuration files have been modified.
@@ -47,6 +49,22 @@ type confLogin struct {
 	// or
 	CRYPT_PREFIX string // $2a$
 	CRYPT_ROUNDS int    // 8
+
+	YESCRYPT_COST_FACTOR int
+
+	SUB_UID_MIN   int
+	SUB_UID_MAX   int
+	SUB_UID_COUNT int
+	SUB_GID_MIN   int
+	SUB_GID_MAX   int
+	SUB_GID_COUNT int
+
+	UMASK     string // octal; default to '022'
+	HOME_MODE string // octal; got from UMASK whether it is not set
+	MAIL_DIR  string // Default to '/var/mail'
+
+	USERGROUPS_ENAB string // yes/no
+	CREATE_HOME     string // yes/no
 }
 
 const fileUseradd = "/etc/default/useradd"
@@ -54,6 +72,11 @@ const fileUseradd = "/etc/default/useradd"
 type confUseradd struct {
 	HOME  string // Default to '/home'
 	SHELL string // Default to '/bin/sh'
+	SKEL  string // Default to '/etc/skel'
+
+	GROUP    string
+	INACTIVE string // Default to '-1'
+	EXPIRE   string
 }
 
 // == Optional files.
@@ -71,6 +94,8 @@ type confAdduser struct {
 	LAST_UID  int
 	FIRST_GID int
 	LAST_GID  int
+
+	NAME_REGEX string
 }
 
 // Used in Arch, Manjaro, OpenSUSE.
@@ -100,24 +125,22 @@ var debug bool // For testing
 
 // A configData represents the configuration used to add users and groups.
 type configData struct {
-	login   confLogin
-	useradd confUseradd
-
+	policy  Policy
 	crypter crypt.Crypter
+	method  crypt.Crypt // Crypt function of crypter, if it is known.
+
+	err error // Error at loading the configuration.
 	sync.Once
 }
 
-var config configData
-
-// init sets the configuration data.
-func (c *configData) init() error {
+// init sets the configuration data from the files placed into the root
+// directory of the database.
+// The files of login and useradd which are not found are handled like empty, as
+// in the minimal images, so their default values are used.
+func (c *configData) init(db *DB) error {
 	_confLogin := &confLogin{}
 
-	cfg, err := shconf.ParseFile(fileLogin)
-	if err != nil {
-		return err
-	}
-	if err = cfg.Unmarshal(_confLogin); err != nil {
+	if err := unmarshalConf(db.path(fileLogin), _confLogin); err != nil {
 		return err
 	}
 	if debug {
@@ -131,13 +154,15 @@ func (c *configData) init() error {
 	if _confLogin.PASS_WARN_AGE == 0 {
 		_confLogin.PASS_WARN_AGE = 7
 	}
-
-	cfg, err = shconf.ParseFile(fileUseradd)
-	if err != nil {
-		return err
+	if _confLogin.UMASK == "" {
+		_confLogin.UMASK = "022"
+	}
+	if _confLogin.MAIL_DIR == "" {
+		_confLogin.MAIL_DIR = "/var/mail"
 	}
+
 	_confUseradd := &confUseradd{}
-	if err = cfg.Unmarshal(_confUseradd); err != nil {
+	if err := unmarshalConf(db.path(fileUseradd), _confUseradd); err != nil {
 		return err
 	}
 	if debug {
@@ -151,13 +176,17 @@ func (c *configData) init() error {
 	if _confUseradd.SHELL == "" {
 		_confUseradd.SHELL = "/bin/sh"
 	}
-	config.useradd = *_confUseradd
+	if _confUseradd.SKEL == "" {
+		_confUseradd.SKEL = "/etc/skel"
+	}
 
 	// Optional files
 
-	found, err := exist(fileAdduser) // Based in Debian.
+	nameRegex := ""
+
+	found, err := exist(db.path(fileAdduser)) // Based in Debian.
 	if found {
-		cfg, err := shconf.ParseFile(fileAdduser)
+		cfg, err := shconf.ParseFile(db.path(fileAdduser))
 		if err != nil {
 			return err
 		}
@@ -170,6 +199,13 @@ func (c *configData) init() error {
 			internal.PrintStruct(_confAdduser)
 		}
 
+		if _confAdduser.NAME_REGEX != "" {
+			if _, err = regexp.Compile(_confAdduser.NAME_REGEX); err != nil {
+				return fmt.Errorf("user: NAME_REGEX not valid in %s: %s", fileAdduser, err)
+			}
+			nameRegex = _confAdduser.NAME_REGEX
+		}
+
 		if _confLogin.SYS_UID_MIN == 0 || _confLogin.SYS_UID_MAX == 0 ||
 			_confLogin.SYS_GID_MIN == 0 || _confLogin.SYS_GID_MAX == 0 ||
 			_confLogin.UID_MIN == 0 || _confLogin.UID_MAX == 0 ||
@@ -188,8 +224,8 @@ func (c *configData) init() error {
 	} else if err != nil {
 		return err
 
-	} else if found, err = exist(fileLibuser); found { // Based in Red Hat.
-		cfg, err := shconf.ParseFile(fileLibuser)
+	} else if found, err = exist(db.path(fileLibuser)); found { // Based in Red Hat.
+		cfg, err := shconf.ParseFile(db.path(fileLibuser))
 		if err != nil {
 			return err
 		}
@@ -233,18 +269,30 @@ func (c *configData) init() error {
 
 	switch strings.ToUpper(_confLogin.ENCRYPT_METHOD) {
 	case "MD5":
-		c.crypter = crypt.New(crypt.MD5)
+		c.method = crypt.MD5
 	case "SHA256":
-		c.crypter = crypt.New(crypt.SHA256)
+		c.method = crypt.SHA256
 	case "SHA512":
-		c.crypter = crypt.New(crypt.SHA512)
+		c.method = crypt.SHA512
+	case "BCRYPT":
+		c.method = crypt.BCRYPT
+	case "YESCRYPT":
+		c.method = crypt.YESCRYPT
 	case "":
-		if c.crypter, err = lookupCrypter(); err != nil {
-			return err
+		// The function is got from the hashes already stored, else SHA-512
+		// is used.
+		if c.crypter, err = db.lookupCrypter(); err != nil {
+			if err != ErrShadowPasswd && !os.IsNotExist(err) {
+				return err
+			}
+			c.method = crypt.SHA512
 		}
 	default:
 		return fmt.Errorf("user: requested cryp function is unavailable: %s",
-			c.login.ENCRYPT_METHOD)
+			_confLogin.ENCRYPT_METHOD)
+	}
+	if c.method != 0 {
+		c.crypter = crypt.New(c.method)
 	}
 
 	if _confLogin.SYS_UID_MIN == 0 || _confLogin.SYS_UID_MAX == 0 ||
@@ -263,17 +311,33 @@ func (c *configData) init() error {
 		_confLogin.GID_MAX = 29999
 	}
 
-	config.login = *_confLogin
+	c.policy = newPolicy(_confLogin, _confUseradd)
+	if nameRegex != "" {
+		c.policy.NameRegex = nameRegex
+	}
 	return nil
 }
 
-// loadConfig loads user configuration.
+// initConfig loads the configuration of the database at the first call,
+// returning the error got at loading it.
 // It has to be loaded before of edit some file.
-func loadConfig() {
-	config.Do(func() {
+func (db *DB) initConfig() error {
+	db.config.Do(func() {
 		//checkRoot()
-		if err := config.init(); err != nil {
-			panic(err)
-		}
+		db.config.err = db.config.init(db)
 	})
+	return db.config.err
+}
+
+// unmarshalConf parses the configuration file into v; a file not found is
+// skipped.
+func unmarshalConf(name string, v interface{}) error {
+	cfg, err := shconf.ParseFile(name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return cfg.Unmarshal(v)
 }
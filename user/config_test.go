@@ -6,11 +6,58 @@
 
 package user
 
-import "testing"
+import (
+	"os"
+	"strings"
+	"testing"
+)
 
 func TestLoadConfig(t *testing.T) {
 	if testing.Verbose() {
 		debug = true
 	}
-	loadConfig()
+	if err := testDB.initConfig(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConfigWithoutFiles(t *testing.T) {
+	files := map[string]string{
+		fileUser:    "root:x:0:0:root:/root:/bin/sh\n",
+		fileGroup:   "root:x:0:\n",
+		fileShadow:  "root:*:18000:0:99999:7:::\n",
+		fileGShadow: "root:*::\n",
+	}
+	db := newTestDB(t, files)
+	if err := os.Remove(db.path(fileUseradd)); err != nil {
+		t.Fatal(err)
+	}
+
+	// The default values are used.
+	if _, err := db.AddUser("alice", 0); err != nil {
+		t.Fatal(err)
+	}
+	u, err := db.LookupUser("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.UID != 1000 || u.Dir != "/home/alice" || u.Shell != "/bin/sh" {
+		t.Errorf("unexpected user: %v", u)
+	}
+	if err = db.ChPasswd("alice", []byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+	if s, err := db.LookupShadow("alice"); err != nil || !strings.HasPrefix(s.password, "$6$") {
+		t.Errorf("expected a passwd hashed with SHA-512, got: %v, %v", s, err)
+	}
+
+	// The configuration not valid is returned like an error.
+	files[fileLogin] = "ENCRYPT_METHOD FOO\n"
+	db = newTestDB(t, files)
+	if _, err = db.AddUser("alice", 0); err == nil {
+		t.Error("expected the error of the configuration")
+	}
+	if _, err = db.NextUID(); err == nil {
+		t.Error("expected the error of the configuration")
+	}
 }
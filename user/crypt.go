@@ -21,22 +21,27 @@ import (
 	"errors"
 	"io"
 	"log"
+	"math/rand"
 	"os"
 
 	"github.com/tredoe/osutil/user/crypt"
+	"github.com/tredoe/osutil/user/crypt/bcrypt_crypt"
 	_ "github.com/tredoe/osutil/user/crypt/md5_crypt"
-	_ "github.com/tredoe/osutil/user/crypt/sha256_crypt"
-	_ "github.com/tredoe/osutil/user/crypt/sha512_crypt"
-	//_ "github.com/tredoe/osutil/user/crypt/bcrypt"
+	"github.com/tredoe/osutil/user/crypt/sha256_crypt"
+	"github.com/tredoe/osutil/user/crypt/sha512_crypt"
+	"github.com/tredoe/osutil/user/crypt/yescrypt_crypt"
 )
 
 const lockChar = '!' // Character added at the beginning of the passwd to lock it.
 
-var ErrShadowPasswd = errors.New("no found user with shadowed passwd")
+var (
+	ErrShadowPasswd = errors.New("no found user with shadowed passwd")
+	ErrPasswdLocked = errors.New("passwd is locked")
+)
 
 // lookupCrypter returns the first crypt function found in shadowed passwd file.
-func lookupCrypter() (crypt.Crypter, error) {
-	f, err := os.Open(fileShadow)
+func (db *DB) lookupCrypter() (crypt.Crypter, error) {
+	f, err := os.Open(db.path(fileShadow))
 	if err != nil {
 		return nil, err
 	}
@@ -59,8 +64,8 @@ func lookupCrypter() (crypt.Crypter, error) {
 			log.Print(err)
 			continue
 		}
-		if shadow.password[0] == '$' {
-			return crypt.NewFromHash(shadow.password), nil
+		if shadow.password != "" && shadow.password[0] == '$' {
+			return crypt.NewCrypterFromHash(shadow.password)
 		}
 	}
 	//return nil, ErrShadowPasswd
@@ -68,78 +73,357 @@ func lookupCrypter() (crypt.Crypter, error) {
 
 // SetCrypter sets the crypt function to can hash the passwords.
 // The type "crypt.Crypt" comes from package "github.com/tredoe/osutil/user/crypt".
-func SetCrypter(c crypt.Crypt) {
-	loadConfig()
-	config.crypter = crypt.New(c)
+func SetCrypter(c crypt.Crypt) { defaultDB.SetCrypter(c) }
+
+// SetCrypter sets the crypt function to can hash the passwords of the database.
+// Whether the configuration could not be loaded, its error is returned at using
+// the database.
+func (db *DB) SetCrypter(c crypt.Crypt) {
+	db.initConfig()
+	db.config.crypter = crypt.New(c)
+	db.config.method = c
+}
+
+// PasswdOptions represents the options to hash a passwd.
+type PasswdOptions struct {
+	// Rounds of hashing for SHA-crypt, or the cost for bcrypt and yescrypt.
+	//
+	// If it is zero, it is got from the configuration: for SHA-crypt, a
+	// random value into the range set in SHA_CRYPT_MIN_ROUNDS and
+	// SHA_CRYPT_MAX_ROUNDS, like passwd does; and for yescrypt, the value of
+	// YESCRYPT_COST_FACTOR.
+	Rounds int
 }
 
 // Passwd sets a hashed passwd for the actual user.
 // The passwd must be supplied in clear-text.
-func (s *Shadow) Passwd(key []byte) {
-	loadConfig()
-	s.password, _ = config.crypter.Generate(key, nil)
+// Whether it could not be hashed, the passwd is not changed.
+func (s *Shadow) Passwd(key []byte) error { return s.PasswdWithOptions(key, nil) }
+
+// PasswdWithOptions sets a hashed passwd for the actual user, using the given
+// options. The passwd must be supplied in clear-text.
+// Whether it could not be hashed, the passwd is not changed.
+func (s *Shadow) PasswdWithOptions(key []byte, opts *PasswdOptions) error {
+	hash, err := dbOf(s.db).hashPasswd(key, opts)
+	if err != nil {
+		return err
+	}
+	s.password = hash
 	s.setChange()
+	return nil
 }
 
 // Passwd sets a hashed passwd for the actual group.
 // The passwd must be supplied in clear-text.
-func (gs *GShadow) Passwd(key []byte) {
-	loadConfig()
-	gs.password, _ = config.crypter.Generate(key, nil)
+// Whether it could not be hashed, the passwd is not changed.
+func (gs *GShadow) Passwd(key []byte) error { return gs.PasswdWithOptions(key, nil) }
+
+// PasswdWithOptions sets a hashed passwd for the actual group, using the given
+// options. The passwd must be supplied in clear-text.
+// Whether it could not be hashed, the passwd is not changed.
+func (gs *GShadow) PasswdWithOptions(key []byte, opts *PasswdOptions) error {
+	hash, err := dbOf(gs.db).hashPasswd(key, opts)
+	if err != nil {
+		return err
+	}
+	gs.password = hash
+	return nil
+}
+
+// hashPasswd returns the passwd hashed with the crypt function of the database.
+func (db *DB) hashPasswd(key []byte, opts *PasswdOptions) (string, error) {
+	if err := db.initConfig(); err != nil {
+		return "", err
+	}
+	p := &db.config.policy
+
+	rounds := 0
+	if opts != nil {
+		rounds = opts.Rounds
+	}
+
+	switch db.config.method {
+	case crypt.SHA256:
+		if rounds == 0 {
+			rounds = p.shaCryptRounds()
+		}
+		salt := sha256_crypt.GetSalt()
+		return db.config.crypter.Generate(key, salt.GenerateWRounds(sha256_crypt.SaltLenMax, rounds))
+
+	case crypt.SHA512:
+		if rounds == 0 {
+			rounds = p.shaCryptRounds()
+		}
+		salt := sha512_crypt.GetSalt()
+		return db.config.crypter.Generate(key, salt.GenerateWRounds(sha512_crypt.SaltLenMax, rounds))
+
+	case crypt.BCRYPT:
+		if rounds != 0 {
+			salt := bcrypt_crypt.GetSalt()
+			salt.RoundsDefault = rounds
+
+			crypter := bcrypt_crypt.New()
+			crypter.SetSalt(salt)
+			return crypter.Generate(key, nil)
+		}
+
+	case crypt.YESCRYPT:
+		if rounds == 0 {
+			rounds = p.YescryptCostFactor
+		}
+		if rounds != 0 {
+			salt := yescrypt_crypt.GetSalt()
+			salt.RoundsDefault = rounds
+
+			crypter := yescrypt_crypt.New()
+			crypter.SetSalt(salt)
+			return crypter.Generate(key, nil)
+		}
+	}
+
+	return db.config.crypter.Generate(key, nil)
+}
+
+// shaCryptRounds returns a random number of rounds into the range set in
+// SHA_CRYPT_MIN_ROUNDS and SHA_CRYPT_MAX_ROUNDS, or -1 to use the default
+// rounds whether they are not set.
+func (p *Policy) shaCryptRounds() int {
+	min, max := p.SHACryptMinRounds, p.SHACryptMaxRounds
+
+	if min <= 0 && max <= 0 {
+		return -1
+	}
+	if min <= 0 {
+		min = max
+	}
+	if max < min {
+		max = min
+	}
+	return min + rand.Intn(max-min+1)
 }
 
 // == Change passwd
 
 // ChPasswd updates passwd.
 // The passwd must be supplied in clear-text.
-func ChPasswd(user string, key []byte) error {
-	shadow, err := LookupShadow(user)
+func ChPasswd(user string, key []byte) error { return defaultDB.ChPasswd(user, key) }
+
+// ChPasswd updates passwd.
+// The passwd must be supplied in clear-text.
+func (db *DB) ChPasswd(user string, key []byte) error {
+	return db.update(func(tx *Tx) error { return tx.ChPasswd(user, key) })
+}
+
+// ChPasswd stages the change of passwd.
+// The passwd must be supplied in clear-text.
+func (tx *Tx) ChPasswd(user string, key []byte) error {
+	return tx.ChPasswdWithOptions(user, key, nil)
+}
+
+// ChPasswdWithOptions updates passwd, hashing it with the given options.
+// The passwd must be supplied in clear-text.
+func ChPasswdWithOptions(user string, key []byte, opts *PasswdOptions) error {
+	return defaultDB.ChPasswdWithOptions(user, key, opts)
+}
+
+// ChPasswdWithOptions updates passwd, hashing it with the given options.
+// The passwd must be supplied in clear-text.
+func (db *DB) ChPasswdWithOptions(user string, key []byte, opts *PasswdOptions) error {
+	return db.update(func(tx *Tx) error { return tx.ChPasswdWithOptions(user, key, opts) })
+}
+
+// ChPasswdWithOptions stages the change of passwd, hashing it with the given
+// options. The passwd must be supplied in clear-text.
+func (tx *Tx) ChPasswdWithOptions(user string, key []byte, opts *PasswdOptions) error {
+	shadow, err := tx.LookupShadow(user)
 	if err != nil {
 		return err
 	}
-	shadow.Passwd(key)
+	if err = shadow.PasswdWithOptions(key, opts); err != nil {
+		return err
+	}
 
-	return edit(user, shadow)
+	return tx.edit(user, shadow)
 }
 
 // ChGPasswd updates group passwd.
 // The passwd must be supplied in clear-text.
-func ChGPasswd(group string, key []byte) error {
-	gshadow, err := LookupGShadow(group)
+func ChGPasswd(group string, key []byte) error { return defaultDB.ChGPasswd(group, key) }
+
+// ChGPasswd updates group passwd.
+// The passwd must be supplied in clear-text.
+func (db *DB) ChGPasswd(group string, key []byte) error {
+	return db.update(func(tx *Tx) error { return tx.ChGPasswd(group, key) })
+}
+
+// ChGPasswd stages the change of group passwd.
+// The passwd must be supplied in clear-text.
+func (tx *Tx) ChGPasswd(group string, key []byte) error {
+	gshadow, err := tx.LookupGShadow(group)
 	if err != nil {
 		return err
 	}
-	gshadow.Passwd(key)
+	if err = gshadow.Passwd(key); err != nil {
+		return err
+	}
+
+	return tx.edit(group, gshadow)
+}
+
+// == Verification
+
+// VerifyPasswd checks the passwd of the given user, returning nil on success.
+// The passwd must be supplied in clear-text.
+//
+// When the hashed passwd was got with a crypt function or a cost lower than the
+// ones set in ENCRYPT_METHOD, SHA_CRYPT_MIN_ROUNDS and YESCRYPT_COST_FACTOR,
+// it is hashed again using the actual configuration.
+func VerifyPasswd(name string, key []byte) error { return defaultDB.VerifyPasswd(name, key) }
+
+// VerifyPasswd checks the passwd of the given user, returning nil on success.
+// The passwd must be supplied in clear-text.
+//
+// The database is only locked whether the passwd has to be hashed again.
+func (db *DB) VerifyPasswd(name string, key []byte) error {
+	shadow, err := db.LookupShadow(name)
+	if err != nil {
+		return err
+	}
+	if err = verifyPasswd(shadow.password, key); err != nil {
+		return err
+	}
+
+	if !db.needsRehash(shadow.password) {
+		return nil
+	}
+	return db.update(func(tx *Tx) error {
+		// The passwd could be changed before of getting the lock.
+		newShadow, err := tx.LookupShadow(name)
+		if err != nil {
+			return err
+		}
+		if newShadow.password != shadow.password {
+			return nil
+		}
+		return tx.rehashPasswd(newShadow, key)
+	})
+}
+
+// VerifyPasswd checks the passwd of the given user, returning nil on success,
+// and stages its new hash whether it is required.
+// The passwd must be supplied in clear-text.
+func (tx *Tx) VerifyPasswd(name string, key []byte) error {
+	shadow, err := tx.LookupShadow(name)
+	if err != nil {
+		return err
+	}
+	if err = verifyPasswd(shadow.password, key); err != nil {
+		return err
+	}
+
+	if !tx.db.needsRehash(shadow.password) {
+		return nil
+	}
+	return tx.rehashPasswd(shadow, key)
+}
+
+// rehashPasswd stages the passwd hashed with the crypt function of the
+// database. The date of the last change is not modified since it is the same
+// passwd.
+func (tx *Tx) rehashPasswd(s *Shadow, key []byte) (err error) {
+	if s.password, err = tx.db.hashPasswd(key, nil); err != nil {
+		return err
+	}
+	return tx.edit(s.Name, s)
+}
+
+// verifyPasswd checks the passwd in clear-text key against the hashed one.
+func verifyPasswd(hashedKey string, key []byte) error {
+	if hashedKey == "" { // No passwd is required.
+		if len(key) == 0 {
+			return nil
+		}
+		return crypt.ErrKeyMismatch
+	}
+	if hashedKey[0] == lockChar {
+		return ErrPasswdLocked
+	}
+
+	crypter, err := crypt.NewCrypterFromHash(hashedKey)
+	if err != nil {
+		return err
+	}
+	return crypter.Verify(hashedKey, key)
+}
+
+// needsRehash reports whether the hashed key was got with a crypt function or
+// a cost lower than the ones set in the configuration of the database.
+func (db *DB) needsRehash(hashedKey string) bool {
+	if db.initConfig() != nil {
+		return false
+	}
+	p := &db.config.policy
+
+	info, err := crypt.Identify(hashedKey)
+	if err != nil {
+		return false
+	}
 
-	return edit(group, gshadow)
+	if db.config.method != 0 && info.Crypt != db.config.method {
+		return true
+	}
+
+	switch info.Crypt {
+	case crypt.SHA256, crypt.SHA512:
+		return info.Rounds < p.SHACryptMinRounds
+	case crypt.YESCRYPT:
+		return info.Rounds < p.YescryptCostFactor
+	}
+	return false
 }
 
 // == Locking
 
 // LockUser locks the passwd of the given user.
-func LockUser(name string) error {
-	shadow, err := LookupShadow(name)
+func LockUser(name string) error { return defaultDB.LockUser(name) }
+
+// LockUser locks the passwd of the given user.
+func (db *DB) LockUser(name string) error {
+	return db.update(func(tx *Tx) error { return tx.LockUser(name) })
+}
+
+// LockUser stages the locking of the passwd of the given user.
+func (tx *Tx) LockUser(name string) error {
+	shadow, err := tx.LookupShadow(name)
 	if err != nil {
 		return err
 	}
 
-	if shadow.password[0] != lockChar {
+	if shadow.password == "" || shadow.password[0] != lockChar {
 		shadow.password = string(lockChar) + shadow.password
-		return edit(name, shadow)
+		return tx.edit(name, shadow)
 	}
 	return nil
 }
 
 // UnlockUser unlocks the passwd of the given user.
-func UnlockUser(name string) error {
-	shadow, err := LookupShadow(name)
+func UnlockUser(name string) error { return defaultDB.UnlockUser(name) }
+
+// UnlockUser unlocks the passwd of the given user.
+func (db *DB) UnlockUser(name string) error {
+	return db.update(func(tx *Tx) error { return tx.UnlockUser(name) })
+}
+
+// UnlockUser stages the unlocking of the passwd of the given user.
+func (tx *Tx) UnlockUser(name string) error {
+	shadow, err := tx.LookupShadow(name)
 	if err != nil {
 		return err
 	}
 
-	if shadow.password[0] == lockChar {
+	if shadow.password != "" && shadow.password[0] == lockChar {
 		shadow.password = shadow.password[1:]
-		return edit(name, shadow)
+		return tx.edit(name, shadow)
 	}
 	return nil
 }
@@ -0,0 +1,229 @@
+// Copyright 2021 Jonas mg
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file.
+
+// Package bcrypt_crypt implements Niels Provos and David Mazières's bcrypt
+// password hashing algorithm, based in the cipher Blowfish.
+//
+// The specification for this algorithm can be found here:
+// https://www.usenix.org/legacy/events/usenix99/provos/provos.pdf
+package bcrypt_crypt
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"strconv"
+	"strings"
+
+	"github.com/tredoe/osutil/user/crypt"
+	"github.com/tredoe/osutil/user/crypt/common"
+)
+
+func init() {
+	crypt.RegisterCrypt(crypt.BCRYPT, New, MagicPrefix, "$2a$", "$2y$")
+}
+
+const (
+	MagicPrefix   = "$2b$"
+	SaltLenMin    = 22
+	SaltLenMax    = 22
+	RoundsMin     = 4 // Logarithm in base 2 of the iterations.
+	RoundsMax     = 31
+	RoundsDefault = 10
+
+	keyLenMax = 72 // Bytes of the key used by Blowfish.
+	saltBytes = 16
+	hashBytes = 23 // Bytes of the cipher text that are encoded.
+)
+
+// Prefixes of the variants of bcrypt. All of them are hashed like "$2b$",
+// which fixes the handling of keys longer than 255 bytes.
+var magicPrefixes = [][]byte{[]byte("$2a$"), []byte("$2b$"), []byte("$2y$")}
+
+// alphabet is the one of the variant of Base64 used by bcrypt.
+const alphabet = "./ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
+
+var bcryptEncoding = base64.NewEncoding(alphabet).WithPadding(base64.NoPadding)
+
+// magicCipherData is the text encrypted at the end: "OrpheanBeholderScryDoubt".
+var magicCipherData = []byte("OrpheanBeholderScryDoubt")
+
+type crypter struct{ Salt common.Salt }
+
+// New returns a new crypt.Crypter computing the bcrypt password hashing.
+func New() crypt.Crypter {
+	return &crypter{GetSalt()}
+}
+
+func (c *crypter) Generate(key, salt []byte) (string, error) {
+	if len(salt) == 0 {
+		salt = c.generateSalt(c.Salt.RoundsDefault)
+	}
+
+	prefix, rounds, rawSalt, err := parseSalt(salt)
+	if err != nil {
+		return "", err
+	}
+
+	sum := hash(key, rawSalt, rounds)
+
+	out := make([]byte, 0, 60)
+	out = append(out, prefix...)
+	if rounds < 10 {
+		out = append(out, '0')
+	}
+	out = append(out, strconv.Itoa(rounds)...)
+	out = append(out, '$')
+	out = append(out, bcryptEncoding.EncodeToString(rawSalt)...)
+	out = append(out, bcryptEncoding.EncodeToString(sum[:hashBytes])...)
+
+	return string(out), nil
+}
+
+func (c *crypter) Verify(hashedKey string, key []byte) error {
+	newHash, err := c.Generate(key, []byte(hashedKey))
+	if err != nil {
+		return err
+	}
+	if newHash != hashedKey {
+		return crypt.ErrKeyMismatch
+	}
+	return nil
+}
+
+func (c *crypter) Cost(hashedKey string) (int, error) {
+	_, rounds, _, err := parseSalt([]byte(hashedKey))
+	return rounds, err
+}
+
+func (c *crypter) SetSalt(salt common.Salt) { c.Salt = salt }
+
+func GetSalt() common.Salt {
+	return common.Salt{
+		MagicPrefix:   []byte(MagicPrefix),
+		SaltLenMin:    SaltLenMin,
+		SaltLenMax:    SaltLenMax,
+		RoundsDefault: RoundsDefault,
+		RoundsMin:     RoundsMin,
+		RoundsMax:     RoundsMax,
+	}
+}
+
+// generateSalt returns a random salt with the given cost, since bcrypt uses
+// its own format and encoding.
+func (c *crypter) generateSalt(rounds int) []byte {
+	if rounds < RoundsMin {
+		rounds = RoundsMin
+	} else if rounds > RoundsMax {
+		rounds = RoundsMax
+	}
+
+	raw := make([]byte, saltBytes)
+	rand.Read(raw)
+
+	out := make([]byte, 0, 29)
+	out = append(out, c.Salt.MagicPrefix...)
+	if rounds < 10 {
+		out = append(out, '0')
+	}
+	out = append(out, strconv.Itoa(rounds)...)
+	out = append(out, '$')
+	out = append(out, bcryptEncoding.EncodeToString(raw)...)
+	return out
+}
+
+// parseSalt returns the prefix, the cost and the decoded salt of a salt or a
+// hashed key, like "$2b$10$N9qo8uLOickgx2ZMRZoMye".
+func parseSalt(salt []byte) (prefix []byte, rounds int, raw []byte, err error) {
+	for _, p := range magicPrefixes {
+		if bytes.HasPrefix(salt, p) {
+			prefix = p
+			break
+		}
+	}
+	if prefix == nil {
+		return nil, 0, nil, common.ErrSaltPrefix
+	}
+	salt = salt[len(prefix):]
+
+	if len(salt) < 3+SaltLenMax || salt[2] != '$' {
+		return nil, 0, nil, common.ErrSaltFormat
+	}
+	if rounds, err = strconv.Atoi(string(salt[:2])); err != nil ||
+		rounds < RoundsMin || rounds > RoundsMax {
+		return nil, 0, nil, common.ErrSaltRounds
+	}
+
+	raw, ok := decodeSalt(salt[3 : 3+SaltLenMax])
+	if !ok {
+		return nil, 0, nil, common.ErrSaltFormat
+	}
+	return prefix, rounds, raw, nil
+}
+
+// decodeSalt decodes the salt, whose last character has 4 bits not used.
+func decodeSalt(src []byte) ([]byte, bool) {
+	vals := make([]byte, len(src))
+	for i, c := range src {
+		n := strings.IndexByte(alphabet, c)
+		if n == -1 {
+			return nil, false
+		}
+		vals[i] = byte(n)
+	}
+
+	dst := make([]byte, 0, saltBytes)
+	for i := 0; len(dst) < saltBytes; i += 4 {
+		dst = append(dst, vals[i]<<2|vals[i+1]>>4)
+		if len(dst) == saltBytes {
+			break
+		}
+		dst = append(dst, vals[i+1]<<4|vals[i+2]>>2, vals[i+2]<<6|vals[i+3])
+	}
+	return dst, true
+}
+
+// hash computes the cipher text of bcrypt.
+func hash(key, salt []byte, rounds int) []byte {
+	// The key is terminated in NUL, like a string of C.
+	ckey := make([]byte, len(key)+1)
+	copy(ckey, key)
+	if len(ckey) > keyLenMax {
+		ckey = ckey[:keyLenMax]
+	}
+
+	c := newCipher()
+	c.expandKey(ckey, salt)
+	for i := uint64(0); i < 1<<uint(rounds); i++ {
+		c.expandKey(ckey, nil)
+		c.expandKey(salt, nil)
+	}
+
+	cdata := make([]uint32, len(magicCipherData)/4)
+	for i := range cdata {
+		cdata[i] = uint32(magicCipherData[4*i])<<24 | uint32(magicCipherData[4*i+1])<<16 |
+			uint32(magicCipherData[4*i+2])<<8 | uint32(magicCipherData[4*i+3])
+	}
+	for i := 0; i < 64; i++ {
+		for j := 0; j < len(cdata); j += 2 {
+			cdata[j], cdata[j+1] = c.encrypt(cdata[j], cdata[j+1])
+		}
+	}
+
+	out := make([]byte, 4*len(cdata))
+	for i, w := range cdata {
+		out[4*i] = byte(w >> 24)
+		out[4*i+1] = byte(w >> 16)
+		out[4*i+2] = byte(w >> 8)
+		out[4*i+3] = byte(w)
+	}
+
+	// Clean sensitive data.
+	for i := range ckey {
+		ckey[i] = 0
+	}
+	return out
+}
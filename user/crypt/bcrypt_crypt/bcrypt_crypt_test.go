@@ -0,0 +1,97 @@
+// Copyright 2021 Jonas mg
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file.
+
+package bcrypt_crypt
+
+import (
+	"strings"
+	"testing"
+)
+
+var bcryptCrypt = New()
+
+func TestGenerate(t *testing.T) {
+	data := []struct {
+		salt []byte
+		key  []byte
+		out  string
+		cost int
+	}{
+		{
+			[]byte("$2b$04$abcdefghijklmnopqrstuu"),
+			[]byte(""),
+			"$2b$04$abcdefghijklmnopqrstuubyCG3zY1GIXMyxfivm.ClDiInHzxjiq",
+			4,
+		},
+		{
+			[]byte("$2b$05$CCCCCCCCCCCCCCCCCCCCC."),
+			[]byte("password"),
+			"$2b$05$CCCCCCCCCCCCCCCCCCCCC.aDV7CQarKHMuNfh2oJkFzsHZya4whFe",
+			5,
+		},
+		{
+			// The bits not used of the last character are cleared.
+			[]byte("$2a$06$0123456789abcdefghijkl"),
+			[]byte("Lorem ipsum dolor sit amet"),
+			"$2a$06$0123456789abcdefghijkeC1NMRZfkRc73atRI/i.Im4phcb4ZmDa",
+			6,
+		},
+		{
+			// Only the first 72 bytes of the key are used.
+			[]byte("$2y$04$N9qo8uLOickgx2ZMRZoMye"),
+			[]byte(strings.Repeat("x", 80)),
+			"$2y$04$N9qo8uLOickgx2ZMRZoMyedlslr.Jty7xCSm4JGSxc8PDTmSzo582",
+			4,
+		},
+	}
+
+	for i, d := range data {
+		hash, err := bcryptCrypt.Generate(d.key, d.salt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hash != d.out {
+			t.Errorf("Test %d failed\nExpected: %s, got: %s", i, d.out, hash)
+		}
+
+		cost, err := bcryptCrypt.Cost(hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cost != d.cost {
+			t.Errorf("Test %d failed\nExpected: %d, got: %d", i, d.cost, cost)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	data := [][]byte{
+		[]byte("password"),
+		[]byte("12345"),
+		[]byte("That's amazing! I've got the same combination on my luggage!"),
+		[]byte("And change the combination on my luggage!"),
+		[]byte("         random  spa  c    ing."),
+		[]byte("94ajflkvjzpe8u3&*j1k513KLJ&*()"),
+	}
+	c := New()
+	salt := GetSalt()
+	salt.RoundsDefault = RoundsMin
+	c.SetSalt(salt)
+
+	for i, d := range data {
+		hash, err := c.Generate(d, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = c.Verify(hash, d); err != nil {
+			t.Errorf("Test %d failed: %s", i, d)
+		}
+	}
+
+	if _, err := c.Generate(data[0], []byte("$2b$99$abcdefghijklmnopqrstuu")); err == nil {
+		t.Error("expected to fail with rounds out of range")
+	}
+}
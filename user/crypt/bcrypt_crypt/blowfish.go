@@ -0,0 +1,82 @@
+// Copyright 2021 Jonas mg
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file.
+
+package bcrypt_crypt
+
+// A cipher represents the state of Blowfish, with the "expensive key schedule"
+// used by bcrypt.
+type cipher struct {
+	p              [18]uint32
+	s0, s1, s2, s3 [256]uint32
+}
+
+// newCipher returns a cipher with the initial subkeys.
+func newCipher() *cipher {
+	return &cipher{p: p, s0: s0, s1: s1, s2: s2, s3: s3}
+}
+
+// streamToWord returns the next 32 bits of data, cycling over it from the
+// position pos.
+func streamToWord(data []byte, pos *int) uint32 {
+	var w uint32
+	j := *pos
+
+	for i := 0; i < 4; i++ {
+		w = w<<8 | uint32(data[j])
+		j++
+		if j >= len(data) {
+			j = 0
+		}
+	}
+	*pos = j
+	return w
+}
+
+// expandKey mixes the key and the salt into the subkeys. Whether salt is nil, it
+// is the key schedule of Blowfish.
+func (c *cipher) expandKey(key, salt []byte) {
+	j := 0
+	for i := range c.p {
+		c.p[i] ^= streamToWord(key, &j)
+	}
+
+	var l, r uint32
+	j = 0
+	next := func() {
+		if salt != nil {
+			l ^= streamToWord(salt, &j)
+			r ^= streamToWord(salt, &j)
+		}
+		l, r = c.encrypt(l, r)
+	}
+
+	for i := 0; i < len(c.p); i += 2 {
+		next()
+		c.p[i], c.p[i+1] = l, r
+	}
+	for _, s := range []*[256]uint32{&c.s0, &c.s1, &c.s2, &c.s3} {
+		for i := 0; i < len(s); i += 2 {
+			next()
+			s[i], s[i+1] = l, r
+		}
+	}
+}
+
+// f is the round function of Blowfish.
+func (c *cipher) f(x uint32) uint32 {
+	return ((c.s0[byte(x>>24)] + c.s1[byte(x>>16)]) ^ c.s2[byte(x>>8)]) + c.s3[byte(x)]
+}
+
+// encrypt encrypts a block of 64 bits.
+func (c *cipher) encrypt(l, r uint32) (uint32, uint32) {
+	l ^= c.p[0]
+	for i := 1; i < 16; i += 2 {
+		r ^= c.f(l) ^ c.p[i]
+		l ^= c.f(r) ^ c.p[i+1]
+	}
+	r ^= c.p[17]
+	return r, l
+}
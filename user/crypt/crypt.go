@@ -10,6 +10,9 @@ package crypt
 
 import (
 	"errors"
+	"fmt"
+	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/tredoe/osutil/user/crypt/common"
@@ -52,57 +55,171 @@ type Crypter interface {
 type Crypt uint
 
 const (
-	APR1   Crypt = iota + 1 // import "github.com/tredoe/osutil/user/crypt/apr1_crypt"
-	MD5                     // import "github.com/tredoe/osutil/user/crypt/md5_crypt"
-	SHA256                  // import "github.com/tredoe/osutil/user/crypt/sha256_crypt"
-	SHA512                  // import "github.com/tredoe/osutil/user/crypt/sha512_crypt"
+	APR1     Crypt = iota + 1 // import "github.com/tredoe/osutil/user/crypt/apr1_crypt"
+	MD5                       // import "github.com/tredoe/osutil/user/crypt/md5_crypt"
+	SHA256                    // import "github.com/tredoe/osutil/user/crypt/sha256_crypt"
+	SHA512                    // import "github.com/tredoe/osutil/user/crypt/sha512_crypt"
+	BCRYPT                    // import "github.com/tredoe/osutil/user/crypt/bcrypt_crypt"
+	YESCRYPT                  // import "github.com/tredoe/osutil/user/crypt/yescrypt_crypt"
 	maxCrypt
+
+	// UserDefined is the first value free to identify the crypt functions
+	// implemented out of this library.
+	UserDefined Crypt = 100
 )
 
-var cryptPrefixes = make([]string, maxCrypt)
+var cryptNames = [maxCrypt]string{
+	APR1:     "APR1",
+	MD5:      "MD5",
+	SHA256:   "SHA256",
+	SHA512:   "SHA512",
+	BCRYPT:   "BCRYPT",
+	YESCRYPT: "YESCRYPT",
+}
+
+func (c Crypt) String() string {
+	if c < maxCrypt && cryptNames[c] != "" {
+		return cryptNames[c]
+	}
+	return "Crypt(" + strconv.FormatUint(uint64(c), 10) + ")"
+}
+
+var (
+	ErrUnavailable   = errors.New("crypt: requested crypt function is unavailable")
+	ErrUnknownPrefix = errors.New("crypt: unknown crypt function from prefix")
+)
+
+// cryptPrefix relates the prefix of a hashed key with its crypt function.
+type cryptPrefix struct {
+	prefix string
+	c      Crypt
+}
 
-var crypts = make([]func() Crypter, maxCrypt)
+var (
+	crypts = make(map[Crypt]func() Crypter)
+
+	// cryptPrefixes is sorted from the longest prefix, to match the most
+	// specific one.
+	cryptPrefixes []cryptPrefix
+)
 
 // RegisterCrypt registers a function that returns a new instance of the given
-// crypt function. This is intended to be called from the init function in
-// packages that implement crypt functions.
-func RegisterCrypt(c Crypt, f func() Crypter, prefix string) {
-	if c >= maxCrypt {
-		panic("crypt: RegisterHash of unknown crypt function")
+// crypt function, and the prefixes of the hashed keys got from it. This is
+// intended to be called from the init function in packages that implement
+// crypt functions.
+//
+// The crypt functions implemented out of this library have to use a value from
+// UserDefined.
+func RegisterCrypt(c Crypt, f func() Crypter, prefix ...string) {
+	if c == 0 || c >= maxCrypt && c < UserDefined {
+		panic("crypt: RegisterCrypt of unknown crypt function")
 	}
 	crypts[c] = f
-	cryptPrefixes[c] = prefix
+
+	for _, p := range prefix {
+		if p == "" {
+			panic("crypt: RegisterCrypt with an empty prefix")
+		}
+		cryptPrefixes = append(cryptPrefixes, cryptPrefix{p, c})
+	}
+	sort.SliceStable(cryptPrefixes, func(i, j int) bool {
+		return len(cryptPrefixes[i].prefix) > len(cryptPrefixes[j].prefix)
+	})
 }
 
 // New returns a new crypter.
+// It panics whether the crypt function is not registered; use NewCrypter to
+// get an error instead.
 func New(c Crypt) Crypter {
-	f := crypts[c]
-	if f != nil {
-		return f()
+	crypter, err := NewCrypter(c)
+	if err != nil {
+		panic(err)
+	}
+	return crypter
+}
+
+// NewCrypter returns a new crypter, or ErrUnavailable whether the crypt function
+// is not registered.
+func NewCrypter(c Crypt) (Crypter, error) {
+	if f := crypts[c]; f != nil {
+		return f(), nil
 	}
-	panic("crypt: requested crypt function is unavailable")
+	return nil, ErrUnavailable
 }
 
 // NewFromHash returns a new Crypter using the prefix in the given hashed key.
+// It panics whether the prefix is unknown; use NewCrypterFromHash to get an
+// error instead.
 func NewFromHash(hashedKey string) Crypter {
-	var f func() Crypter
-
-	if strings.HasPrefix(hashedKey, cryptPrefixes[SHA512]) {
-		f = crypts[SHA512]
-	} else if strings.HasPrefix(hashedKey, cryptPrefixes[SHA256]) {
-		f = crypts[SHA256]
-	} else if strings.HasPrefix(hashedKey, cryptPrefixes[MD5]) {
-		f = crypts[MD5]
-	} else if strings.HasPrefix(hashedKey, cryptPrefixes[APR1]) {
-		f = crypts[APR1]
-	} else {
-		toks := strings.SplitN(hashedKey, "$", 3)
-		prefix := "$" + toks[1] + "$"
-		panic("crypt: unknown cryp function from prefix: " + prefix)
+	crypter, err := NewCrypterFromHash(hashedKey)
+	if err != nil {
+		panic(err)
 	}
+	return crypter
+}
 
-	if f != nil {
-		return f()
+// NewCrypterFromHash returns a new Crypter using the prefix in the given hashed
+// key. The error wraps ErrUnknownPrefix whether the prefix is not registered.
+func NewCrypterFromHash(hashedKey string) (Crypter, error) {
+	c, _, err := lookupPrefix(hashedKey)
+	if err != nil {
+		return nil, err
 	}
-	panic("crypt: requested cryp function is unavailable")
+	return NewCrypter(c)
 }
+
+// lookupPrefix returns the crypt function and the prefix of a hashed key.
+func lookupPrefix(hashedKey string) (Crypt, string, error) {
+	for _, p := range cryptPrefixes {
+		if strings.HasPrefix(hashedKey, p.prefix) {
+			return p.c, p.prefix, nil
+		}
+	}
+
+	if toks := strings.SplitN(hashedKey, "$", 3); len(toks) == 3 && toks[0] == "" {
+		return 0, "", fmt.Errorf("%w: $%s$", ErrUnknownPrefix, toks[1])
+	}
+	return 0, "", ErrUnknownPrefix
+}
+
+// A HashInfo represents the parameters used to get a hashed key.
+type HashInfo struct {
+	Crypt  Crypt
+	Prefix string
+	Rounds int // Hashing cost, as returned by Crypter.Cost.
+	Salt   string
+}
+
+// Identify returns the crypt function and the parameters used to get a hashed
+// key, whose format is "$id$[param=value$]salt$hash", or the one of bcrypt.
+func Identify(hashedKey string) (*HashInfo, error) {
+	c, prefix, err := lookupPrefix(hashedKey)
+	if err != nil {
+		return nil, err
+	}
+	crypter, err := NewCrypter(c)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &HashInfo{Crypt: c, Prefix: prefix}
+	if info.Rounds, err = crypter.Cost(hashedKey); err != nil {
+		return nil, err
+	}
+
+	toks := strings.Split(hashedKey[len(prefix):], "$")
+	switch {
+	case c == BCRYPT: // "$2b$cost$" followed by 22 characters of salt and the hash.
+		if len(toks) != 2 || len(toks[1]) < bcryptSaltLen {
+			return nil, common.ErrSaltFormat
+		}
+		info.Salt = toks[1][:bcryptSaltLen]
+	case len(toks) >= 2:
+		info.Salt = toks[len(toks)-2]
+	default:
+		return nil, common.ErrSaltFormat
+	}
+	return info, nil
+}
+
+const bcryptSaltLen = 22
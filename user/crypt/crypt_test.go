@@ -0,0 +1,99 @@
+// Copyright 2021 Jonas mg
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file.
+
+package crypt_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/tredoe/osutil/user/crypt"
+	_ "github.com/tredoe/osutil/user/crypt/apr1_crypt"
+	_ "github.com/tredoe/osutil/user/crypt/bcrypt_crypt"
+	"github.com/tredoe/osutil/user/crypt/common"
+	_ "github.com/tredoe/osutil/user/crypt/md5_crypt"
+	_ "github.com/tredoe/osutil/user/crypt/sha256_crypt"
+	_ "github.com/tredoe/osutil/user/crypt/sha512_crypt"
+	_ "github.com/tredoe/osutil/user/crypt/yescrypt_crypt"
+)
+
+func TestNewCrypterFromHash(t *testing.T) {
+	for _, hash := range []string{"", "!", "*", "abJnggxhB/yWI", "$", "$9$abc$def"} {
+		if _, err := crypt.NewCrypterFromHash(hash); !errors.Is(err, crypt.ErrUnknownPrefix) {
+			t.Errorf("%q: expected error of unknown prefix, got %v", hash, err)
+		}
+	}
+
+	if _, err := crypt.NewCrypter(crypt.UserDefined + 1); err != crypt.ErrUnavailable {
+		t.Errorf("expected error of crypt function unavailable, got %v", err)
+	}
+}
+
+func TestIdentify(t *testing.T) {
+	data := []struct {
+		hash   string
+		crypt  crypt.Crypt
+		rounds int
+		salt   string
+	}{
+		{"$1$deadbeef$Q7g0UO4hRC0mgQUQ/qkjZ0", crypt.MD5, 1000, "deadbeef"},
+		{"$apr1$$F8JZB2/sM4yZeaUYLf8C8/", crypt.APR1, 1000, ""},
+		{
+			"$6$rounds=10$roundstoolow$kUMsbe306n21p9R.FRkW3IGn.S9NPN0x50YhH1xhLsPuWGsUSklZt58jaTfF4ZEQpyUNGc0dqbpBYYBaHHrsX.",
+			crypt.SHA512, 10, "roundstoolow",
+		},
+		{"$2y$04$N9qo8uLOickgx2ZMRZoMyedlslr.Jty7xCSm4JGSxc8PDTmSzo582", crypt.BCRYPT, 4, "N9qo8uLOickgx2ZMRZoMye"},
+		{
+			"$y$j9T$8L1dBiHKz8D6Z0Kzd.5RY1$.ccGE6gvRSgiFyqCZlJKppMEc11vd95veh1EHrtWf19",
+			crypt.YESCRYPT, 5, "8L1dBiHKz8D6Z0Kzd.5RY1",
+		},
+	}
+
+	for _, d := range data {
+		info, err := crypt.Identify(d.hash)
+		if err != nil {
+			t.Errorf("%s: %s", d.hash, err)
+			continue
+		}
+		if info.Crypt != d.crypt || info.Rounds != d.rounds || info.Salt != d.salt {
+			t.Errorf("%s: expected {%s %d %s}, got %+v", d.hash, d.crypt, d.rounds, d.salt, info)
+		}
+	}
+
+	if _, err := crypt.Identify("$2b$04$short"); err == nil {
+		t.Error("expected to fail with a truncated hash")
+	}
+}
+
+// A fakeCrypter is a crypt function implemented out of the library.
+type fakeCrypter struct{}
+
+func (fakeCrypter) Generate(key, salt []byte) (string, error) { return "$fake$" + string(key), nil }
+func (fakeCrypter) Verify(hashedKey string, key []byte) error { return nil }
+func (fakeCrypter) Cost(hashedKey string) (int, error)        { return 1, nil }
+func (fakeCrypter) SetSalt(salt common.Salt)                  {}
+
+func TestRegisterCrypt(t *testing.T) {
+	const fake = crypt.UserDefined
+
+	crypt.RegisterCrypt(fake, func() crypt.Crypter { return fakeCrypter{} }, "$fake$")
+
+	c, err := crypt.NewCrypterFromHash("$fake$salt$hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.(fakeCrypter); !ok {
+		t.Errorf("expected the crypter registered, got %T", c)
+	}
+
+	info, err := crypt.Identify("$fake$salt$hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Crypt != fake || info.Salt != "salt" {
+		t.Errorf("unexpected info: %+v", info)
+	}
+}
@@ -0,0 +1,544 @@
+// Copyright 2021 Jonas mg
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file.
+
+package yescrypt_crypt
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"math/bits"
+)
+
+// Flags of yescrypt.
+const (
+	flagRW       = 0x002
+	flagRounds6  = 0x004
+	flagGather4  = 0x010
+	flagSimple2  = 0x020
+	flagSbox12K  = 0x080
+	flagPrehash  = 0x100000
+	flagsRWMask  = 0x3fc
+	flagsDefault = flagRW | flagRounds6 | flagGather4 | flagSimple2 | flagSbox12K
+)
+
+// Parameters of pwxform, for the default flags.
+const (
+	pwxSimple = 2
+	pwxGather = 4
+	pwxRounds = 6
+	sWidth    = 8
+
+	pwxBytes = pwxGather * pwxSimple * 8
+	pwxWords = pwxBytes / 4
+	sBytes   = 3 * (1 << sWidth) * pwxSimple * 8
+	sWords   = sBytes / 4
+	sMask    = ((1 << sWidth) - 1) * pwxSimple * 8
+)
+
+var errParams = errors.New("yescrypt: parameters not supported")
+
+// params represents the parameters of the hashing.
+type params struct {
+	flags uint32
+	N     uint64
+	r     uint32
+	p     uint32
+	t     uint32
+	g     uint32
+	NROM  uint64
+}
+
+// kdf derives a key of length keyLen from the password and the salt.
+func kdf(passwd, salt []byte, par *params, keyLen int) ([]byte, error) {
+	if par.g != 0 || par.NROM != 0 {
+		return nil, errParams
+	}
+
+	// The password is pre-hashed for the big costs, so an attacker cannot
+	// precompute the first part of the hashing with fewer memory.
+	if par.flags&flagRW != 0 && par.p >= 1 && par.N/uint64(par.p) >= 0x100 &&
+		par.N/uint64(par.p)*uint64(par.r) >= 0x20000 {
+		pre := *par
+		pre.flags |= flagPrehash
+		pre.N >>= 6
+		pre.t = 0
+
+		dk, err := kdfBody(passwd, salt, &pre, 32)
+		if err != nil {
+			return nil, err
+		}
+		passwd = dk
+	}
+
+	return kdfBody(passwd, salt, par, keyLen)
+}
+
+func kdfBody(passwd, salt []byte, par *params, keyLen int) ([]byte, error) {
+	flags, N, r, p, t := par.flags, par.N, int(par.r), par.p, par.t
+
+	if flags != 0 && flags&flagsRWMask != flagsDefault&flagsRWMask ||
+		flags&^(flagsRWMask|flagRW|flagPrehash) != 0 {
+		return nil, errParams
+	}
+	if N < 4 || N&(N-1) != 0 || r < 1 || p < 1 || uint64(r)*uint64(p) >= 1<<30 ||
+		N > 1<<32 || N/uint64(p) <= 1 || uint64(r) > (1<<32)/(128*N) {
+		return nil, errParams
+	}
+	if flags&flagRW != 0 && (N/uint64(p) <= 1 || uint64(r) < (pwxBytes+127)/128) {
+		return nil, errParams
+	}
+
+	if flags != 0 {
+		key := []byte("yescrypt-prehash")
+		if flags&flagPrehash == 0 {
+			key = key[:8]
+		}
+		passwd = hmacSHA256(key, passwd)
+	}
+
+	// 1: (B_0 ... B_{p-1}) <-- PBKDF2(P, S, 1, p * MFLen)
+	s := 32 * r
+	bBytes := pbkdf2SHA256(passwd, salt, 128*r*int(p))
+	B := make([]uint32, s*int(p))
+	for i := range B {
+		B[i] = binary.LittleEndian.Uint32(bBytes[4*i:])
+	}
+
+	if flags != 0 {
+		passwd = append([]byte{}, bBytes[:32]...)
+	}
+
+	V := make([]uint32, uint64(s)*N)
+	XY := make([]uint32, 2*s)
+
+	if p == 1 || flags&flagRW != 0 {
+		smix(B, r, N, p, t, flags, V, XY, passwd)
+	} else {
+		for i := 0; i < int(p); i++ {
+			smix(B[i*s:], r, N, 1, t, flags, V, XY, passwd)
+		}
+	}
+
+	for i, v := range B {
+		binary.LittleEndian.PutUint32(bBytes[4*i:], v)
+	}
+
+	// 5: DK <-- PBKDF2(P, B, 1, dkLen)
+	dkLen := keyLen
+	if flags != 0 && dkLen < 32 {
+		dkLen = 32
+	}
+	dk := pbkdf2SHA256(passwd, bBytes, dkLen)
+
+	// Except when computing classic scrypt, the last steps match the ones of
+	// SCRAM (RFC 5802).
+	if flags != 0 && flags&flagPrehash == 0 {
+		clientKey := hmacSHA256(dk[:32], []byte("Client Key"))
+		storedKey := sha256.Sum256(clientKey)
+		copy(dk, storedKey[:])
+	}
+	return dk[:keyLen], nil
+}
+
+// == Mixing
+//
+
+// A pwxformCtx represents the state of pwxform.
+type pwxformCtx struct {
+	S0, S1, S2 []uint32 // S-boxes, as pairs of words.
+	w          int
+}
+
+// smix mixes the blocks of B.
+func smix(B []uint32, r int, N uint64, p, t, flags uint32, V, XY []uint32, passwd []byte) {
+	s := 32 * r
+
+	// 1: n <-- N / p
+	nChunk := N / uint64(p)
+
+	// 2: Nloop_all <-- fNloop(n, t, flags)
+	nLoopAll := nChunk
+	if flags&flagRW != 0 {
+		if t <= 1 {
+			if t != 0 {
+				nLoopAll *= 2 // 2/3
+			}
+			nLoopAll = (nLoopAll + 2) / 3 // 1/3, round up
+		} else {
+			nLoopAll *= uint64(t) - 1
+		}
+	} else if t != 0 {
+		if t == 1 {
+			nLoopAll += (nLoopAll + 1) / 2 // 1.5, round up
+		}
+		nLoopAll *= uint64(t)
+	}
+
+	var nLoopRW uint64
+	if flags&flagRW != 0 {
+		nLoopRW = nLoopAll / uint64(p)
+	}
+
+	nChunk &^= 1                   // round down to even
+	nLoopAll = (nLoopAll + 1) &^ 1 // round up to even
+	nLoopRW = (nLoopRW + 1) &^ 1   // round up to even
+
+	var ctx []pwxformCtx
+	if flags&flagRW != 0 {
+		ctx = make([]pwxformCtx, p)
+	}
+
+	vChunk := uint64(0)
+	for i := 0; i < int(p); i, vChunk = i+1, vChunk+nChunk {
+		np := nChunk
+		if i == int(p)-1 {
+			np = N - vChunk
+		}
+		Bp := B[i*s : (i+1)*s]
+		Vp := V[vChunk*uint64(s):]
+
+		var ctxI *pwxformCtx
+		if flags&flagRW != 0 {
+			ctxI = &ctx[i]
+			S := make([]uint32, sWords)
+
+			smix1(Bp, 1, sBytes/128, 0, S, XY, nil)
+			ctxI.S2 = S[:sWords/3]
+			ctxI.S1 = S[sWords/3 : 2*sWords/3]
+			ctxI.S0 = S[2*sWords/3:]
+			ctxI.w = 0
+
+			if i == 0 {
+				last := make([]byte, 64)
+				for k := 0; k < 16; k++ {
+					binary.LittleEndian.PutUint32(last[4*k:], Bp[s-16+k])
+				}
+				copy(passwd, hmacSHA256(last, passwd[:32]))
+			}
+		}
+
+		smix1(Bp, r, np, flags, Vp, XY, ctxI)
+		smix2(Bp, r, p2floor(np), nLoopRW, flags, Vp, XY, ctxI)
+	}
+
+	for i := 0; i < int(p); i++ {
+		var ctxI *pwxformCtx
+		if flags&flagRW != 0 {
+			ctxI = &ctx[i]
+		}
+		smix2(B[i*s:(i+1)*s], r, N, nLoopAll-nLoopRW, flags&^flagRW, V, XY, ctxI)
+	}
+}
+
+// smix1 fills V from the block B, being N the number of blocks of V.
+func smix1(B []uint32, r int, N uint64, flags uint32, V, XY []uint32, ctx *pwxformCtx) {
+	s := 32 * r
+	X := XY[:s]
+	Y := XY[s : 2*s]
+
+	// 1: X <-- B
+	for k := 0; k < 2*r; k++ {
+		for i := 0; i < 16; i++ {
+			X[k*16+i] = B[k*16+(i*5%16)]
+		}
+	}
+
+	// 2: for i = 0 to N - 1 do
+	for i := uint64(0); i < N; i++ {
+		// 3: V_i <-- X
+		copy(V[i*uint64(s):], X)
+
+		if flags&flagRW != 0 && i > 1 {
+			// j <-- Wrap(Integerify(X), i)
+			j := wrap(integerify(X, r), i)
+			// X <-- X xor V_j
+			blkxor(X, V[j*uint64(s):])
+		}
+
+		// 4: X <-- H(X)
+		if ctx != nil {
+			blockmixPwxform(X, ctx, r)
+		} else {
+			blockmixSalsa8(X, Y, r)
+		}
+	}
+
+	// B' <-- X
+	for k := 0; k < 2*r; k++ {
+		for i := 0; i < 16; i++ {
+			B[k*16+(i*5%16)] = X[k*16+i]
+		}
+	}
+}
+
+// smix2 mixes the block B with the blocks of V, during nLoop iterations.
+func smix2(B []uint32, r int, N, nLoop uint64, flags uint32, V, XY []uint32, ctx *pwxformCtx) {
+	s := 32 * r
+	X := XY[:s]
+	Y := XY[s : 2*s]
+
+	// X <-- B
+	for k := 0; k < 2*r; k++ {
+		for i := 0; i < 16; i++ {
+			X[k*16+i] = B[k*16+(i*5%16)]
+		}
+	}
+
+	// 6: for i = 0 to N - 1 do
+	for i := uint64(0); i < nLoop; i++ {
+		// 7: j <-- Integerify(X) mod N
+		j := integerify(X, r) & (N - 1)
+
+		// 8.1: X <-- X xor V_j
+		blkxor(X, V[j*uint64(s):])
+		// V_j <-- X
+		if flags&flagRW != 0 {
+			copy(V[j*uint64(s):(j+1)*uint64(s)], X)
+		}
+
+		// 8.2: X <-- H(X)
+		if ctx != nil {
+			blockmixPwxform(X, ctx, r)
+		} else {
+			blockmixSalsa8(X, Y, r)
+		}
+	}
+
+	// 10: B' <-- X
+	for k := 0; k < 2*r; k++ {
+		for i := 0; i < 16; i++ {
+			B[k*16+(i*5%16)] = X[k*16+i]
+		}
+	}
+}
+
+// blockmixSalsa8 is the BlockMix of scrypt, using Salsa20/8.
+func blockmixSalsa8(B, Y []uint32, r int) {
+	var X [16]uint32
+
+	// 1: X <-- B_{2r - 1}
+	copy(X[:], B[(2*r-1)*16:])
+
+	// 2: for i = 0 to 2r - 1 do
+	for i := 0; i < 2*r; i++ {
+		// 3: X <-- H(X xor B_i)
+		blkxor(X[:], B[i*16:])
+		salsa20(X[:], 8)
+
+		// 4: Y_i <-- X
+		copy(Y[i*16:], X[:])
+	}
+
+	// 6: B' <-- (Y_0, Y_2 ... Y_{2r-2}, Y_1, Y_3 ... Y_{2r-1})
+	for i := 0; i < r; i++ {
+		copy(B[i*16:(i+1)*16], Y[(i*2)*16:])
+	}
+	for i := 0; i < r; i++ {
+		copy(B[(i+r)*16:(i+r+1)*16], Y[(i*2+1)*16:])
+	}
+}
+
+// blockmixPwxform is the BlockMix of yescrypt, using pwxform.
+func blockmixPwxform(B []uint32, ctx *pwxformCtx, r int) {
+	var X [pwxWords]uint32
+
+	// 1: r_1 <-- 128r / PWXbytes
+	r1 := 128 * r / pwxBytes
+
+	// 2: X <-- B'_{r_1 - 1}
+	copy(X[:], B[(r1-1)*pwxWords:])
+
+	// 3: for i = 0 to r_1 - 1 do
+	for i := 0; i < r1; i++ {
+		// 4: if r_1 > 1
+		if r1 > 1 {
+			// 5: X <-- X xor B'_i
+			blkxor(X[:], B[i*pwxWords:])
+		}
+
+		// 7: X <-- pwxform(X)
+		pwxform(X[:], ctx)
+
+		// 8: B'_i <-- X
+		copy(B[i*pwxWords:], X[:])
+	}
+
+	// 10: i <-- floor((r_1 - 1) * PWXbytes / 64)
+	i := (r1 - 1) * pwxBytes / 64
+
+	// 11: B_i <-- H(B_i)
+	salsa20(B[i*16:(i+1)*16], 2)
+
+	// 12: for i = i + 1 to 2r - 1 do
+	for i++; i < 2*r; i++ {
+		// 13: B_i <-- H(B_i xor B_{i-1})
+		blkxor(B[i*16:(i+1)*16], B[(i-1)*16:])
+		salsa20(B[i*16:(i+1)*16], 2)
+	}
+}
+
+// pwxform transforms the block B, a matrix of PWXgather rows of PWXsimple
+// lanes of 64 bits, using the S-boxes.
+func pwxform(B []uint32, ctx *pwxformCtx) {
+	S0, S1, S2, w := ctx.S0, ctx.S1, ctx.S2, ctx.w
+
+	// 1: for i = 0 to PWXrounds - 1 do
+	for i := 0; i < pwxRounds; i++ {
+		// 2: for j = 0 to PWXgather - 1 do
+		for j := 0; j < pwxGather; j++ {
+			X := B[j*pwxSimple*2 : (j+1)*pwxSimple*2]
+
+			// 3: p0 <-- (lo(B_{j,0}) & Smask) / (PWXsimple * 8)
+			p0 := S0[(X[0]&sMask)/4:]
+			// 4: p1 <-- (hi(B_{j,0}) & Smask) / (PWXsimple * 8)
+			p1 := S1[(X[1]&sMask)/4:]
+
+			// 5: for k = 0 to PWXsimple - 1 do
+			for k := 0; k < pwxSimple; k++ {
+				// 6: B_{j,k} <-- (hi(B_{j,k}) * lo(B_{j,k}) + S0_{p0,k}) xor S1_{p1,k}
+				s0 := uint64(p0[2*k+1])<<32 + uint64(p0[2*k])
+				s1 := uint64(p1[2*k+1])<<32 + uint64(p1[2*k])
+
+				x := uint64(X[2*k+1]) * uint64(X[2*k])
+				x += s0
+				x ^= s1
+
+				X[2*k] = uint32(x)
+				X[2*k+1] = uint32(x >> 32)
+			}
+
+			// 8: if (i != 0) and (i != PWXrounds - 1)
+			if i != 0 && i != pwxRounds-1 {
+				// 9: S2_w <-- B_j
+				for k := 0; k < pwxSimple; k++ {
+					S2[2*w] = X[2*k]
+					S2[2*w+1] = X[2*k+1]
+					w++
+				}
+			}
+		}
+	}
+
+	// 14: (S0, S1, S2) <-- (S2, S0, S1)
+	ctx.S0, ctx.S1, ctx.S2 = S2, S0, S1
+	// 15: w <-- w mod 2^Swidth
+	ctx.w = w & ((1<<sWidth)*pwxSimple - 1)
+}
+
+// salsa20 applies the core of Salsa20 with the given rounds to a block stored
+// with the shuffling of SIMD.
+func salsa20(B []uint32, rounds int) {
+	var x [16]uint32
+
+	// SIMD unshuffle
+	for i := 0; i < 16; i++ {
+		x[i*5%16] = B[i]
+	}
+
+	for i := 0; i < rounds; i += 2 {
+		// Operate on columns.
+		x[4] ^= bits.RotateLeft32(x[0]+x[12], 7)
+		x[8] ^= bits.RotateLeft32(x[4]+x[0], 9)
+		x[12] ^= bits.RotateLeft32(x[8]+x[4], 13)
+		x[0] ^= bits.RotateLeft32(x[12]+x[8], 18)
+
+		x[9] ^= bits.RotateLeft32(x[5]+x[1], 7)
+		x[13] ^= bits.RotateLeft32(x[9]+x[5], 9)
+		x[1] ^= bits.RotateLeft32(x[13]+x[9], 13)
+		x[5] ^= bits.RotateLeft32(x[1]+x[13], 18)
+
+		x[14] ^= bits.RotateLeft32(x[10]+x[6], 7)
+		x[2] ^= bits.RotateLeft32(x[14]+x[10], 9)
+		x[6] ^= bits.RotateLeft32(x[2]+x[14], 13)
+		x[10] ^= bits.RotateLeft32(x[6]+x[2], 18)
+
+		x[3] ^= bits.RotateLeft32(x[15]+x[11], 7)
+		x[7] ^= bits.RotateLeft32(x[3]+x[15], 9)
+		x[11] ^= bits.RotateLeft32(x[7]+x[3], 13)
+		x[15] ^= bits.RotateLeft32(x[11]+x[7], 18)
+
+		// Operate on rows.
+		x[1] ^= bits.RotateLeft32(x[0]+x[3], 7)
+		x[2] ^= bits.RotateLeft32(x[1]+x[0], 9)
+		x[3] ^= bits.RotateLeft32(x[2]+x[1], 13)
+		x[0] ^= bits.RotateLeft32(x[3]+x[2], 18)
+
+		x[6] ^= bits.RotateLeft32(x[5]+x[4], 7)
+		x[7] ^= bits.RotateLeft32(x[6]+x[5], 9)
+		x[4] ^= bits.RotateLeft32(x[7]+x[6], 13)
+		x[5] ^= bits.RotateLeft32(x[4]+x[7], 18)
+
+		x[11] ^= bits.RotateLeft32(x[10]+x[9], 7)
+		x[8] ^= bits.RotateLeft32(x[11]+x[10], 9)
+		x[9] ^= bits.RotateLeft32(x[8]+x[11], 13)
+		x[10] ^= bits.RotateLeft32(x[9]+x[8], 18)
+
+		x[12] ^= bits.RotateLeft32(x[15]+x[14], 7)
+		x[13] ^= bits.RotateLeft32(x[12]+x[15], 9)
+		x[14] ^= bits.RotateLeft32(x[13]+x[12], 13)
+		x[15] ^= bits.RotateLeft32(x[14]+x[13], 18)
+	}
+
+	// SIMD shuffle
+	for i := 0; i < 16; i++ {
+		B[i] += x[i*5%16]
+	}
+}
+
+// integerify returns the integer got from the last 64 bytes of B.
+// The word 13 is the second word of B_{2r-1} due to the shuffling of SIMD.
+func integerify(B []uint32, r int) uint64 {
+	X := B[(2*r-1)*16:]
+	return uint64(X[13])<<32 + uint64(X[0])
+}
+
+// p2floor returns the largest power of 2 not greater than x.
+func p2floor(x uint64) uint64 {
+	for y := x & (x - 1); y != 0; y = x & (x - 1) {
+		x = y
+	}
+	return x
+}
+
+// wrap returns x modulo the largest power of 2 not greater than i, moved to the
+// end of the range [0, i).
+func wrap(x, i uint64) uint64 {
+	n := p2floor(i)
+	return (x & (n - 1)) + (i - n)
+}
+
+func blkxor(dst, src []uint32) {
+	for i := range dst {
+		dst[i] ^= src[i]
+	}
+}
+
+// == Hashing
+//
+
+func hmacSHA256(key, msg []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write(msg)
+	return h.Sum(nil)
+}
+
+// pbkdf2SHA256 derives a key using PBKDF2 with HMAC-SHA256 and 1 iteration.
+func pbkdf2SHA256(passwd, salt []byte, keyLen int) []byte {
+	h := hmac.New(sha256.New, passwd)
+	dk := make([]byte, 0, keyLen+sha256.Size)
+	var buf [4]byte
+
+	for block := uint32(1); len(dk) < keyLen; block++ {
+		h.Reset()
+		h.Write(salt)
+		binary.BigEndian.PutUint32(buf[:], block)
+		h.Write(buf[:])
+		dk = h.Sum(dk)
+	}
+	return dk[:keyLen]
+}
@@ -0,0 +1,330 @@
+// Copyright 2021 Jonas mg
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file.
+
+// Package yescrypt_crypt implements Alexander Peslyak's yescrypt password
+// hashing algorithm, based in scrypt. It is the default one in the modern
+// distributions of Linux.
+//
+// The specification for this algorithm can be found here:
+// https://www.openwall.com/yescrypt/
+package yescrypt_crypt
+
+import (
+	"bytes"
+	"crypto/rand"
+
+	"github.com/tredoe/osutil/user/crypt"
+	"github.com/tredoe/osutil/user/crypt/common"
+)
+
+func init() {
+	crypt.RegisterCrypt(crypt.YESCRYPT, New, MagicPrefix)
+}
+
+// The rounds are the cost factor used by libxcrypt, which sets the memory and
+// the time used in the hashing (see "YESCRYPT_COST_FACTOR" in "login.defs(5)").
+const (
+	MagicPrefix   = "$y$"
+	SaltLenMin    = 22
+	SaltLenMax    = 22
+	RoundsMin     = 1
+	RoundsMax     = 11
+	RoundsDefault = 5
+
+	saltBytes = 16
+	hashBytes = 32
+)
+
+// alphabet is the one of the variant of Base64 used in crypt.
+const alphabet = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+type crypter struct{ Salt common.Salt }
+
+// New returns a new crypt.Crypter computing the yescrypt password hashing.
+func New() crypt.Crypter {
+	return &crypter{GetSalt()}
+}
+
+func (c *crypter) Generate(key, salt []byte) (string, error) {
+	if len(salt) == 0 {
+		salt = c.generateSalt(c.Salt.RoundsDefault)
+	}
+
+	setting, par, rawSalt, err := parseSalt(salt)
+	if err != nil {
+		return "", err
+	}
+
+	sum, err := kdf(key, rawSalt, par, hashBytes)
+	if err != nil {
+		return "", common.ErrSaltFormat
+	}
+
+	out := make([]byte, 0, len(setting)+1+43)
+	out = append(out, setting...)
+	out = append(out, '$')
+	out = append(out, common.Base64_24Bit(sum)...)
+
+	return string(out), nil
+}
+
+func (c *crypter) Verify(hashedKey string, key []byte) error {
+	newHash, err := c.Generate(key, []byte(hashedKey))
+	if err != nil {
+		return err
+	}
+	if newHash != hashedKey {
+		return crypt.ErrKeyMismatch
+	}
+	return nil
+}
+
+// Cost returns the cost factor of the hashed key, got from the memory used.
+func (c *crypter) Cost(hashedKey string) (int, error) {
+	_, par, _, err := parseSalt([]byte(hashedKey))
+	if err != nil {
+		return 0, err
+	}
+
+	nLog2 := 0
+	for n := par.N; n > 1; n >>= 1 {
+		nLog2++
+	}
+	if par.r < 32 {
+		return nLog2 - 9, nil
+	}
+	return nLog2 - 7, nil
+}
+
+func (c *crypter) SetSalt(salt common.Salt) { c.Salt = salt }
+
+func GetSalt() common.Salt {
+	return common.Salt{
+		MagicPrefix:   []byte(MagicPrefix),
+		SaltLenMin:    SaltLenMin,
+		SaltLenMax:    SaltLenMax,
+		RoundsDefault: RoundsDefault,
+		RoundsMin:     RoundsMin,
+		RoundsMax:     RoundsMax,
+	}
+}
+
+// generateSalt returns a random salt with the parameters for the given cost
+// factor, like libxcrypt does.
+func (c *crypter) generateSalt(cost int) []byte {
+	if cost < RoundsMin {
+		cost = RoundsMin
+	} else if cost > RoundsMax {
+		cost = RoundsMax
+	}
+
+	nLog2, r := uint32(cost+7), uint32(32)
+	if cost < 3 {
+		nLog2, r = uint32(cost+9), 8
+	}
+
+	raw := make([]byte, saltBytes)
+	rand.Read(raw)
+
+	out := make([]byte, 0, 7+SaltLenMax)
+	out = append(out, c.Salt.MagicPrefix...)
+	out = encodeUint32(out, flagRW+(flagsDefault-flagRW)>>2, 0) // flavor
+	out = encodeUint32(out, nLog2, 1)
+	out = encodeUint32(out, r, 1)
+	out = append(out, '$')
+	out = append(out, common.Base64_24Bit(raw)...)
+	return out
+}
+
+// parseSalt returns the setting, the parameters and the decoded salt of a salt
+// or a hashed key, like "$y$j9T$8L1dBiHKz8D6Z0Kzd.5RY1".
+func parseSalt(salt []byte) (setting []byte, par *params, raw []byte, err error) {
+	if !bytes.HasPrefix(salt, []byte(MagicPrefix)) {
+		return nil, nil, nil, common.ErrSaltPrefix
+	}
+	src := salt[len(MagicPrefix):]
+	par = &params{p: 1}
+
+	var flavor, nLog2, have uint32
+	if flavor, src, err = decodeUint32(src, 0); err != nil {
+		return
+	}
+	if flavor < flagRW {
+		par.flags = flavor
+	} else if flavor <= flagRW+(flagsRWMask>>2) {
+		par.flags = flagRW + (flavor-flagRW)<<2
+	} else {
+		return nil, nil, nil, common.ErrSaltFormat
+	}
+
+	if nLog2, src, err = decodeUint32(src, 1); err != nil {
+		return
+	}
+	if nLog2 > 63 {
+		return nil, nil, nil, common.ErrSaltRounds
+	}
+	par.N = 1 << nLog2
+	if par.r, src, err = decodeUint32(src, 1); err != nil {
+		return
+	}
+
+	if len(src) != 0 && src[0] != '$' {
+		if have, src, err = decodeUint32(src, 1); err != nil {
+			return
+		}
+		if have&1 != 0 {
+			if par.p, src, err = decodeUint32(src, 2); err != nil {
+				return
+			}
+		}
+		if have&2 != 0 {
+			if par.t, src, err = decodeUint32(src, 1); err != nil {
+				return
+			}
+		}
+		if have&4 != 0 {
+			if par.g, src, err = decodeUint32(src, 1); err != nil {
+				return
+			}
+		}
+		if have&8 != 0 {
+			var nromLog2 uint32
+			if nromLog2, src, err = decodeUint32(src, 1); err != nil {
+				return
+			}
+			if nromLog2 > 63 {
+				return nil, nil, nil, common.ErrSaltFormat
+			}
+			par.NROM = 1 << nromLog2
+		}
+	}
+
+	if len(src) == 0 || src[0] != '$' {
+		return nil, nil, nil, common.ErrSaltFormat
+	}
+	src = src[1:]
+
+	// The salt finishes at the separator of the hash, if any.
+	end := len(src)
+	if i := bytes.IndexByte(src, '$'); i != -1 {
+		end = i
+	}
+	if raw, err = decode64(src[:end]); err != nil {
+		return
+	}
+
+	setting = salt[:len(salt)-len(src)+end]
+	return setting, par, raw, nil
+}
+
+// == Encoding
+//
+
+// decodeUint32 decodes an integer of variable length, with the given minimum
+// value, returning the rest of src.
+func decodeUint32(src []byte, min uint32) (uint32, []byte, error) {
+	var start, end, chars, bits uint32 = 0, 47, 1, 0
+
+	if len(src) == 0 {
+		return 0, nil, common.ErrSaltFormat
+	}
+	c := atoi64(src[0])
+	if c > 63 {
+		return 0, nil, common.ErrSaltFormat
+	}
+	src = src[1:]
+
+	dst := min
+	for c > end {
+		dst += (end + 1 - start) << bits
+		start = end + 1
+		end = start + (62-end)/2
+		chars++
+		bits += 6
+	}
+	dst += (c - start) << bits
+
+	for chars--; chars > 0; chars-- {
+		if len(src) == 0 {
+			return 0, nil, common.ErrSaltFormat
+		}
+		if c = atoi64(src[0]); c > 63 {
+			return 0, nil, common.ErrSaltFormat
+		}
+		src = src[1:]
+		bits -= 6
+		dst += c << bits
+	}
+	return dst, src, nil
+}
+
+// encodeUint32 appends to dst the encoding of an integer of variable length,
+// with the given minimum value.
+func encodeUint32(dst []byte, src, min uint32) []byte {
+	var start, end, chars, bits uint32 = 0, 47, 1, 0
+
+	src -= min
+	for {
+		count := (end + 1 - start) << bits
+		if src < count {
+			break
+		}
+		start = end + 1
+		end = start + (62-end)/2
+		src -= count
+		chars++
+		bits += 6
+	}
+
+	dst = append(dst, alphabet[start+(src>>bits)])
+	for chars--; chars > 0; chars-- {
+		bits -= 6
+		dst = append(dst, alphabet[(src>>bits)&0x3f])
+	}
+	return dst
+}
+
+// decode64 decodes the bytes encoded by common.Base64_24Bit.
+func decode64(src []byte) ([]byte, error) {
+	dst := make([]byte, 0, len(src)*3/4)
+
+	for len(src) != 0 {
+		var value, bits uint32
+
+		for len(src) != 0 && bits < 24 {
+			c := atoi64(src[0])
+			if c > 63 {
+				return nil, common.ErrSaltFormat
+			}
+			src = src[1:]
+			value |= c << bits
+			bits += 6
+		}
+		// It must have at least one full byte.
+		if bits < 12 {
+			return nil, common.ErrSaltFormat
+		}
+
+		for ; bits >= 8; bits -= 8 {
+			dst = append(dst, byte(value))
+			value >>= 8
+		}
+		// The bits not used must be zero.
+		if value != 0 {
+			return nil, common.ErrSaltFormat
+		}
+	}
+	return dst, nil
+}
+
+// atoi64 returns the value of the character into the alphabet, or 64 whether it
+// is not found.
+func atoi64(c byte) uint32 {
+	if i := bytes.IndexByte([]byte(alphabet), c); i != -1 {
+		return uint32(i)
+	}
+	return 64
+}
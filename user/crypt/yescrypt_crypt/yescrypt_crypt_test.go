@@ -0,0 +1,108 @@
+// Copyright 2021 Jonas mg
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file.
+
+package yescrypt_crypt
+
+import (
+	"strings"
+	"testing"
+)
+
+var yescryptCrypt = New()
+
+func TestGenerate(t *testing.T) {
+	data := []struct {
+		salt []byte
+		key  []byte
+		out  string
+		cost int
+	}{
+		{
+			[]byte("$y$j75$BDRIqFxbBAwrjsHj9MXO.1"),
+			[]byte("password"),
+			"$y$j75$BDRIqFxbBAwrjsHj9MXO.1$vSRAu2VtIxJIjhv54HzBPAL8SBe1XzpKrY0k1sJAdD4",
+			1,
+		},
+		{
+			[]byte("$y$j85$Gh8iyelZEfe2FXKE/ioAh."),
+			[]byte(""),
+			"$y$j85$Gh8iyelZEfe2FXKE/ioAh.$GBOaPDm6qX4XEL4hUbRHYPBgwH0bLiuEb0ywejPAQE3",
+			2,
+		},
+		{
+			[]byte("$y$j9T$8L1dBiHKz8D6Z0Kzd.5RY1"),
+			[]byte("Lorem ipsum dolor sit amet"),
+			"$y$j9T$8L1dBiHKz8D6Z0Kzd.5RY1$68zr4GmkpLJIQX3/kIdYDj.Fi6vvqai9lWhltwNWboC",
+			5,
+		},
+		{
+			// The hash of a hashed key is ignored.
+			[]byte("$y$j9T$8L1dBiHKz8D6Z0Kzd.5RY1$.ccGE6gvRSgiFyqCZlJKppMEc11vd95veh1EHrtWf19"),
+			[]byte("password"),
+			"$y$j9T$8L1dBiHKz8D6Z0Kzd.5RY1$.ccGE6gvRSgiFyqCZlJKppMEc11vd95veh1EHrtWf19",
+			5,
+		},
+	}
+
+	for i, d := range data {
+		hash, err := yescryptCrypt.Generate(d.key, d.salt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hash != d.out {
+			t.Errorf("Test %d failed\nExpected: %s, got: %s", i, d.out, hash)
+		}
+
+		cost, err := yescryptCrypt.Cost(hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cost != d.cost {
+			t.Errorf("Test %d failed\nExpected: %d, got: %d", i, d.cost, cost)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	data := [][]byte{
+		[]byte("password"),
+		[]byte("12345"),
+		[]byte("That's amazing! I've got the same combination on my luggage!"),
+		[]byte("And change the combination on my luggage!"),
+		[]byte("         random  spa  c    ing."),
+		[]byte("94ajflkvjzpe8u3&*j1k513KLJ&*()"),
+	}
+	c := New()
+	salt := GetSalt()
+	salt.RoundsDefault = RoundsMin
+	c.SetSalt(salt)
+
+	for i, d := range data {
+		hash, err := c.Generate(d, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(hash, "$y$j75$") {
+			t.Errorf("Test %d failed: unexpected parameters in %s", i, hash)
+		}
+		if err = c.Verify(hash, d); err != nil {
+			t.Errorf("Test %d failed: %s", i, d)
+		}
+	}
+}
+
+func TestEncodeUint32(t *testing.T) {
+	for _, n := range []uint32{0, 1, 46, 47, 48, 54, 1000, 100000} {
+		enc := encodeUint32(nil, n, 0)
+		dec, rest, err := decodeUint32(enc, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dec != n || len(rest) != 0 {
+			t.Errorf("%d: got %d from %q", n, dec, enc)
+		}
+	}
+}
@@ -6,11 +6,161 @@
 
 package user
 
-import "testing"
+import (
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/tredoe/osutil/user/crypt"
+)
 
 func TestLookupCrypter(t *testing.T) {
-	_, err := lookupCrypter()
+	_, err := testDB.lookupCrypter()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCrypterOfMethod(t *testing.T) {
+	for method, prefix := range map[string]string{
+		"BCRYPT":   "$2b$",
+		"YESCRYPT": "$y$j75$", // YESCRYPT_COST_FACTOR 1
+	} {
+		db := newTestDB(t, map[string]string{
+			fileLogin: "ENCRYPT_METHOD " + method + "\nYESCRYPT_COST_FACTOR 1\n",
+		})
+
+		s := &Shadow{db: db}
+		s.Passwd([]byte("secret"))
+		if !strings.HasPrefix(s.password, prefix) {
+			t.Errorf("%s: expected prefix %q, got %q", method, prefix, s.password)
+		}
+		if err := crypt.NewFromHash(s.password).Verify(s.password, []byte("secret")); err != nil {
+			t.Errorf("%s: %s", method, err)
+		}
+	}
+}
+
+func TestVerifyPasswd(t *testing.T) {
+	const md5Hash = "$1$deadbeef$Q7g0UO4hRC0mgQUQ/qkjZ0" // "password"
+
+	db := newTestDB(t, map[string]string{
+		fileLogin:   "ENCRYPT_METHOD SHA512\n",
+		fileUser:    "u1:x:1001:1001::/home/u1:/bin/sh\nu2:x:1002:1001::/home/u2:/bin/sh\n",
+		fileGroup:   "g1:x:1001:\n",
+		fileShadow:  "u1:" + md5Hash + ":18000:0:99999:7:::\nu2:!" + md5Hash + ":18000:0:99999:7:::\n",
+		fileGShadow: "g1:!::\n",
+	})
+
+	if err := db.VerifyPasswd("u1", []byte("wrong")); err != crypt.ErrKeyMismatch {
+		t.Errorf("expected key mismatch, got %v", err)
+	}
+	if err := db.VerifyPasswd("u2", []byte("password")); err != ErrPasswdLocked {
+		t.Errorf("expected passwd locked, got %v", err)
+	}
+	s, err := db.LookupShadow("u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.password != md5Hash {
+		t.Fatal("expected to keep the passwd at failing the verification")
+	}
+
+	// The passwd is hashed again using SHA-512.
+	if err = db.VerifyPasswd("u1", []byte("password")); err != nil {
+		t.Fatal(err)
+	}
+	if s, err = db.LookupShadow("u1"); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(s.password, "$6$") {
+		t.Errorf("expected to hash the passwd again, got %q", s.password)
+	}
+	if s.changed != 18000 {
+		t.Errorf("expected to keep the date of last change, got %d", s.changed)
+	}
+	if err = db.VerifyPasswd("u1", []byte("password")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPasswdRounds(t *testing.T) {
+	db := newTestDB(t, map[string]string{
+		fileLogin: "ENCRYPT_METHOD SHA512\nSHA_CRYPT_MIN_ROUNDS 6000\nSHA_CRYPT_MAX_ROUNDS 7000\n",
+	})
+
+	s := &Shadow{db: db}
+	s.Passwd([]byte("secret"))
+	info, err := crypt.Identify(s.password)
 	if err != nil {
 		t.Fatal(err)
 	}
+	if info.Rounds < 6000 || info.Rounds > 7000 {
+		t.Errorf("expected rounds into the range of the configuration, got %d", info.Rounds)
+	}
+
+	if err = s.PasswdWithOptions([]byte("secret"), &PasswdOptions{Rounds: 10000}); err != nil {
+		t.Fatal(err)
+	}
+	if info, err = crypt.Identify(s.password); err != nil {
+		t.Fatal(err)
+	}
+	if info.Rounds != 10000 {
+		t.Errorf("expected the rounds set, got %d", info.Rounds)
+	}
+	if db.needsRehash(s.password) {
+		t.Error("expected to not need a new hash")
+	}
+
+	// The rounds by default are lower than the minimum.
+	defHash, err := crypt.New(crypt.SHA512).Generate([]byte("secret"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !db.needsRehash(defHash) {
+		t.Error("expected to need a new hash")
+	}
+}
+
+// errCrypter is a crypt function which fails at hashing.
+type errCrypter struct{ crypt.Crypter }
+
+func (errCrypter) Generate(key, salt []byte) (string, error) { return "", errors.New("no hash") }
+
+func TestPasswdError(t *testing.T) {
+	const gshadowData = "g1:$6$salt$hash::\n"
+
+	db := newTestDB(t, map[string]string{
+		fileLogin:   "ENCRYPT_METHOD SHA512\n",
+		fileGroup:   "g1:x:1000:\n",
+		fileGShadow: gshadowData,
+	})
+	if err := db.initConfig(); err != nil {
+		t.Fatal(err)
+	}
+	db.config.crypter, db.config.method = errCrypter{}, 0
+
+	s := &Shadow{db: db, password: "$6$salt$hash", changed: 18000}
+	if err := s.Passwd([]byte("secret")); err == nil {
+		t.Error("expected an error at hashing")
+	}
+	if s.password != "$6$salt$hash" || s.changed != 18000 {
+		t.Errorf("expected to keep the passwd, got %q", s.password)
+	}
+
+	gs := &GShadow{db: db, password: "$6$salt$hash"}
+	if err := gs.Passwd([]byte("secret")); err == nil {
+		t.Error("expected an error at hashing")
+	}
+	if gs.password != "$6$salt$hash" {
+		t.Errorf("expected to keep the passwd, got %q", gs.password)
+	}
+
+	if err := db.ChGPasswd("g1", []byte("secret")); err == nil {
+		t.Error("expected an error at changing the passwd")
+	}
+	if data, _ := ioutil.ReadFile(db.path(fileGShadow)); string(data) != gshadowData {
+		t.Errorf("expected to keep the file, got:\n%s", data)
+	}
 }
@@ -6,11 +6,49 @@
 
 package user
 
-// Since tests will be done in temporary files, there is to use variables to
-// change the values at testing.
-var (
+import "path/filepath"
+
+// Files of the database, relative to the root directory of a DB.
+const (
 	fileUser    = "/etc/passwd"
 	fileGroup   = "/etc/group"
 	fileShadow  = "/etc/shadow"
 	fileGShadow = "/etc/gshadow"
 )
+
+// A DB represents the users database placed under a root directory, so the
+// accounts of a container image or a chroot can be managed without touching
+// the ones of the host.
+type DB struct {
+	root   string
+	config configData
+	audit  AuditHook
+}
+
+// NewDB returns a database whose files are got from the directory root.
+// An empty root is handled like "/".
+func NewDB(root string) *DB {
+	if root == "" {
+		root = "/"
+	}
+	return &DB{root: filepath.Clean(root)}
+}
+
+// defaultDB is the database of the running system, used by the functions at
+// package level.
+var defaultDB = NewDB("/")
+
+// Root returns the root directory of the database.
+func (db *DB) Root() string { return db.root }
+
+// path returns the name of a file of the database into the root directory.
+func (db *DB) path(name string) string { return filepath.Join(db.root, name) }
+
+// dbOf returns the database to use for an entry; the default one when it is not
+// set.
+func dbOf(db *DB) *DB {
+	if db == nil {
+		return defaultDB
+	}
+	return db
+}
@@ -10,6 +10,28 @@ You must have enough privileges to access to databases in shadowed files
 '/etc/shadow' and '/etc/gshadow'. This usually means have to be root.
 Note: those files are backed-up before of be modified.
 
+The files are locked during their edition, in a way compatible with the tools of
+shadow-utils and "lckpwdf(3)", and they are replaced atomically so a crash
+cannot leave them corrupted.
+
+The functions at package level work on the database of the running system.
+To manage the accounts of a container image or a chroot, there is to use the
+methods of a DB created with the root directory where that system is placed:
+
+	db := user.NewDB("/var/lib/machines/foo")
+	uid, err := db.AddUser("bar", gid)
+
+The changes done in several steps can be grouped into a transaction, so they
+are written to the files all together or none of them:
+
+	tx, err := db.Begin()
+	...
+	gid, err := tx.AddGroup("bar")
+	...
+	uid, err := tx.AddUser("bar", gid)
+	...
+	err = tx.Commit()
+
 In testing, to print the configuration read from the system, there is to use
 "-v" flag.
 */
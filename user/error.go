@@ -15,6 +15,8 @@ import (
 var (
 	ErrUserExist  = errors.New("user already exists")
 	ErrGroupExist = errors.New("group already exists")
+
+	ErrUserGroup = errors.New("primary group set for an user with its own group")
 )
 
 // IsExist returns whether the error is known to report that an user or group
@@ -70,3 +72,28 @@ type rowError struct {
 func (e rowError) Error() string {
 	return fmt.Sprintf("format of row not valid on '%s'\n%s", e.file, e.row)
 }
+
+// A PostCommitError reports an error at handling the files of an account, like
+// its home directory, after of writing the files of the database; so the
+// changes of the database were done.
+type PostCommitError struct {
+	Err error
+}
+
+func (e PostCommitError) Error() string {
+	return "changes committed, but failed after: " + e.Err.Error()
+}
+
+func (e PostCommitError) Unwrap() error { return e.Err }
+
+// A TxError reports an inconsistency found at validating the changes of a
+// transaction.
+type TxError struct {
+	file   string
+	name   string
+	reason string
+}
+
+func (e TxError) Error() string {
+	return fmt.Sprintf("transaction not valid on '%s': %s: %q", e.file, e.reason, e.name)
+}
@@ -8,11 +8,10 @@ package user
 
 import (
 	"bufio"
-	"bytes"
 	"errors"
 	"io"
 	"os"
-	"sync"
+	"strings"
 
 	"github.com/tredoe/osutil/file"
 )
@@ -20,9 +19,11 @@ import (
 // A row represents the structure of a row into a file.
 type row interface {
 	// lookUp is the parser to looking for a value in the field of given line.
-	lookUp(line string, _field field, value interface{}) interface{}
+	// It returns an error whether the line is malformed.
+	lookUp(line string, _field field, value interface{}) (interface{}, error)
 
-	// filename returns the file name belongs to the file structure.
+	// filename returns the file name belongs to the file structure, relative to
+	// the root directory of the database.
 	filename() string
 
 	String() string
@@ -41,12 +42,14 @@ var errSearch = errors.New("no search")
 //   n > 0: at most n fields
 //   n == 0: the result is nil (zero fields)
 //   n < 0: all fields
-func lookUp(_row row, _field field, value interface{}, n int) (interface{}, error) {
+func (db *DB) lookUp(_row row, _field field, value interface{}, n int) (interface{}, error) {
 	if n == 0 {
 		return nil, errSearch
 	}
 
-	dbf, err := openDBFile(_row.filename(), os.O_RDONLY)
+	filename := db.path(_row.filename())
+
+	dbf, err := openDBFile(filename, os.O_RDONLY)
 	if err != nil {
 		return nil, err
 	}
@@ -56,12 +59,25 @@ func lookUp(_row row, _field field, value interface{}, n int) (interface{}, erro
 	entries := make([]interface{}, 0, 0)
 
 	for {
-		line, _, err := dbf.rd.ReadLine()
-		if err == io.EOF {
+		// The lines are read whole, since the ones of groups with a lot of
+		// members can be longer than the buffer.
+		line, err := dbf.rd.ReadString('\n')
+		if err != nil && (err != io.EOF || line == "") {
+			if err != io.EOF {
+				return nil, err
+			}
 			break
 		}
+		line = strings.TrimSuffix(line, "\n")
+
+		if isComment(line) {
+			continue
+		}
 
-		entry := _row.lookUp(string(line), _field, value)
+		entry, err := _row.lookUp(line, _field, value)
+		if err != nil {
+			return nil, err
+		}
 		if entry != nil {
 			entries = append(entries, entry)
 		}
@@ -76,7 +92,7 @@ func lookUp(_row row, _field field, value interface{}, n int) (interface{}, erro
 	if len(entries) != 0 {
 		return entries, nil
 	}
-	return nil, NoFoundError{_row.filename(), _field.String(), value}
+	return nil, NoFoundError{filename, _field.String(), value}
 }
 
 // == Editing
@@ -87,7 +103,6 @@ var DO_BACKUP = true
 
 // A dbfile represents the database file.
 type dbfile struct {
-	sync.Mutex
 	file *os.File
 	rd   *bufio.Reader
 }
@@ -99,14 +114,11 @@ func openDBFile(filename string, flag int) (*dbfile, error) {
 		return nil, err
 	}
 
-	db := &dbfile{file: f, rd: bufio.NewReader(f)}
-	db.Lock()
-	return db, nil
+	return &dbfile{file: f, rd: bufio.NewReader(f)}, nil
 }
 
 // close closes the file.
 func (db *dbfile) close() error {
-	db.Unlock()
 	return db.file.Close()
 }
 
@@ -126,69 +138,24 @@ func backup(filename string) error {
 	return nil
 }
 
-func edit(name string, r row) error { return _edit(name, r, false) }
-
-func del(name string, r row) error { return _edit(name, r, true) }
-
-// _edit is a generic editor for the given user/group name.
-// If remove is true, it removes the structure of the user/group name.
-//
-// TODO: get better performance if start to store since when the file is edited.
-// So there is to store the size of all lines read until that point to seek from
-// there.
-func _edit(name string, _row row, remove bool) (err error) {
-	filename := _row.filename()
-
-	dbf, err := openDBFile(filename, os.O_RDWR)
-	if err != nil {
-		return err
-	}
-	defer func() {
-		e := dbf.close()
-		if e != nil && err == nil {
-			err = e
-		}
-	}()
-
-	var buf bytes.Buffer
-	name_b := []byte(name)
-	isFound := false
-
-	for {
-		line, err2 := dbf.rd.ReadBytes('\n')
-		if err2 == io.EOF {
-			break
-		}
-
-		if !isFound && bytes.HasPrefix(line, name_b) {
-			isFound = true
-			if remove { // skip user
-				continue
-			}
-
-			line = []byte(_row.String())
-		}
-		if _, err = buf.Write(line); err != nil {
-			return err
-		}
-	}
-
-	if isFound {
-		if err = backup(filename); err != nil {
-			return
-		}
+// isComment reports whether the line is not an entry: a comment, a blank line
+// or a line of NIS, which are kept verbatim at editing the files.
+func isComment(line string) bool {
+	line = strings.TrimSpace(line)
+	return line == "" || line[0] == '#' || isNIS(line)
+}
 
-		if _, err = dbf.file.Seek(0, io.SeekStart); err != nil {
-			return
-		}
+// isNIS reports whether the line is an entry of compatibility with NIS, which
+// starts with "+" or "-".
+func isNIS(line string) bool {
+	return line != "" && (line[0] == '+' || line[0] == '-')
+}
 
-		var n int
-		n, err = dbf.file.Write(buf.Bytes())
-		if err != nil {
-			return
-		}
-		err = dbf.file.Truncate(int64(n))
+// splitLines returns the lines of the content of a file.
+func splitLines(data []byte) []string {
+	content := strings.TrimSuffix(string(data), "\n")
+	if content == "" {
+		return nil
 	}
-
-	return
+	return strings.Split(content, "\n")
 }
@@ -0,0 +1,53 @@
+// Copyright 2021 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package user
+
+import "testing"
+
+func TestLookUpMalformed(t *testing.T) {
+	db := newTestDB(t, map[string]string{
+		fileLogin:   "ENCRYPT_METHOD SHA512\n",
+		fileUser:    "u1:x:1000:1000::/home/u1:/bin/sh\nbad line\n",
+		fileGroup:   "g1:x:1000:\nbroken:x\n",
+		fileShadow:  "u1:*:18000:0:99999:7:::\nbad:*:18000\n",
+		fileGShadow: "g1:!::\nbroken:!\n",
+		fileSubUID:  "u1:100000:65536\nbad:100000\n",
+	})
+
+	lookups := map[string]func() error{
+		"user":    func() error { _, err := db.LookupUser("u2"); return err },
+		"uid":     func() error { _, err := db.LookupUID(2000); return err },
+		"group":   func() error { _, err := db.LookupGroup("g2"); return err },
+		"shadow":  func() error { _, err := db.LookupShadow("u2"); return err },
+		"gshadow": func() error { _, err := db.LookupGShadow("g2"); return err },
+		"subuid":  func() error { _, err := db.LookupSubUID("u2"); return err },
+	}
+	for name, fn := range lookups {
+		err := fn()
+		if _, ok := err.(rowError); !ok {
+			t.Errorf("%s: expected a rowError, got: %v", name, err)
+		}
+	}
+
+	// The entries before of the malformed row are found.
+	if _, err := db.LookupUser("u1"); err != nil {
+		t.Error(err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.LookupGroup("g2"); err == nil {
+		t.Error("expected an error from the row malformed")
+	}
+	if _, err = tx.AddUser("u2", 1000); err == nil {
+		t.Error("expected an error at adding an user with a row malformed")
+	}
+}
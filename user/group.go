@@ -9,7 +9,6 @@ package user
 import (
 	"errors"
 	"fmt"
-	"os"
 	"reflect"
 	"strconv"
 	"strings"
@@ -60,20 +59,34 @@ type Group struct {
 	UserList []string
 
 	addSystemGroup bool
+
+	db *DB // Database where the group is stored.
 }
 
 // NewGroup returns a new Group.
 func NewGroup(name string, members ...string) *Group {
+	return defaultDB.NewGroup(name, members...)
+}
+
+// NewGroup returns a new Group to add into the database.
+func (db *DB) NewGroup(name string, members ...string) *Group {
 	return &Group{
 		Name:     name,
 		password: "",
 		GID:      -1,
 		UserList: members,
+
+		db: db,
 	}
 }
 
 // NewSystemGroup adds a system group.
 func NewSystemGroup(name string, members ...string) *Group {
+	return defaultDB.NewSystemGroup(name, members...)
+}
+
+// NewSystemGroup returns a new system group to add into the database.
+func (db *DB) NewSystemGroup(name string, members ...string) *Group {
 	return &Group{
 		Name:     name,
 		password: "",
@@ -81,16 +94,21 @@ func NewSystemGroup(name string, members ...string) *Group {
 		UserList: members,
 
 		addSystemGroup: true,
+		db:             db,
 	}
 }
 
 func (g *Group) filename() string { return fileGroup }
 
-// IsOfSystem indicates whether it is a system group.
+// IsOfSystem indicates whether it is a system group, whose id is into the range
+// set by SYS_GID_MIN and SYS_GID_MAX, both included like in "groupadd(8)".
 func (g *Group) IsOfSystem() bool {
-	//loadConfig()
+	db := dbOf(g.db)
+	if db.initConfig() != nil {
+		return false
+	}
 
-	if g.GID > config.login.SYS_GID_MIN && g.GID < config.login.SYS_GID_MAX {
+	if g.GID >= db.config.policy.SysGIDMin && g.GID <= db.config.policy.SysGIDMax {
 		return true
 	}
 	return false
@@ -126,48 +144,40 @@ func parseGroup(row string) (*Group, error) {
 
 // lookUp parses the group line searching a value into the field.
 // Returns nil if it is not found.
-func (*Group) lookUp(line string, f field, value interface{}) interface{} {
+func (*Group) lookUp(line string, f field, value interface{}) (interface{}, error) {
 	_field := f.(groupField)
-	allField := strings.Split(line, ":")
-	arrayField := make(map[int][]string)
-	intField := make(map[int]int)
-
-	arrayField[3] = strings.Split(allField[3], ",")
 
-	// Check integers
-	var err error
-	if intField[2], err = strconv.Atoi(allField[2]); err != nil {
-		panic(atoiError{fileGroup, line, "GID"})
+	g, err := parseGroup(line)
+	if err != nil {
+		return nil, err
 	}
 
 	// Check fields
 	var isField bool
-	if G_NAME&_field != 0 && allField[0] == value.(string) {
+	if G_NAME&_field != 0 && g.Name == value.(string) {
 		isField = true
-	} else if G_PASSWD&_field != 0 && allField[1] == value.(string) {
+	} else if G_PASSWD&_field != 0 && g.password == value.(string) {
 		isField = true
-	} else if G_GID&_field != 0 && intField[2] == value.(int) {
+	} else if G_GID&_field != 0 && g.GID == value.(int) {
 		isField = true
-	} else if G_MEMBER&_field != 0 && checkGroup(arrayField[3], value.(string)) {
+	} else if G_MEMBER&_field != 0 && checkGroup(g.UserList, value.(string)) {
 		isField = true
 	} else if G_ALL&_field != 0 {
 		isField = true
 	}
 
 	if isField {
-		return &Group{
-			Name:     allField[0],
-			password: allField[1],
-			GID:      intField[2],
-			UserList: arrayField[3],
-		}
+		return g, nil
 	}
-	return nil
+	return nil, nil
 }
 
 // LookupGID looks up a group by group ID.
-func LookupGID(gid int) (*Group, error) {
-	entries, err := LookupInGroup(G_GID, gid, 1)
+func LookupGID(gid int) (*Group, error) { return defaultDB.LookupGID(gid) }
+
+// LookupGID looks up a group by group ID.
+func (db *DB) LookupGID(gid int) (*Group, error) {
+	entries, err := db.LookupInGroup(G_GID, gid, 1)
 	if err != nil {
 		return nil, err
 	}
@@ -176,8 +186,11 @@ func LookupGID(gid int) (*Group, error) {
 }
 
 // LookupGroup looks up a group by name.
-func LookupGroup(name string) (*Group, error) {
-	entries, err := LookupInGroup(G_NAME, name, 1)
+func LookupGroup(name string) (*Group, error) { return defaultDB.LookupGroup(name) }
+
+// LookupGroup looks up a group by name.
+func (db *DB) LookupGroup(name string) (*Group, error) {
+	entries, err := db.LookupInGroup(G_NAME, name, 1)
 	if err != nil {
 		return nil, err
 	}
@@ -192,7 +205,17 @@ func LookupGroup(name string) (*Group, error) {
 //   n == 0: the result is nil (zero fields)
 //   n < 0: all fields
 func LookupInGroup(field groupField, value interface{}, n int) ([]*Group, error) {
-	iEntries, err := lookUp(&Group{}, field, value, n)
+	return defaultDB.LookupInGroup(field, value, n)
+}
+
+// LookupInGroup looks up a group by the given values.
+//
+// The count determines the number of fields to return:
+//   n > 0: at most n fields
+//   n == 0: the result is nil (zero fields)
+//   n < 0: all fields
+func (db *DB) LookupInGroup(field groupField, value interface{}, n int) ([]*Group, error) {
+	iEntries, err := db.lookUp(&Group{}, field, value, n)
 	if err != nil {
 		return nil, err
 	}
@@ -203,11 +226,47 @@ func LookupInGroup(field groupField, value interface{}, n int) ([]*Group, error)
 
 	for i := 0; i < valueSlice.Len(); i++ {
 		entries[i] = valueSlice.Index(i).Interface().(*Group)
+		entries[i].db = db
 	}
 
 	return entries, err
 }
 
+// LookupGID looks up a group by group ID, into the changes staged.
+func (tx *Tx) LookupGID(gid int) (*Group, error) {
+	entries, err := tx.LookupInGroup(G_GID, gid, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	return entries[0], err
+}
+
+// LookupGroup looks up a group by name, into the changes staged.
+func (tx *Tx) LookupGroup(name string) (*Group, error) {
+	entries, err := tx.LookupInGroup(G_NAME, name, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	return entries[0], err
+}
+
+// LookupInGroup looks up a group by the given values, into the changes staged.
+func (tx *Tx) LookupInGroup(field groupField, value interface{}, n int) ([]*Group, error) {
+	iEntries, err := tx.lookUp(&Group{}, field, value, n)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]*Group, len(iEntries))
+	for i, v := range iEntries {
+		entries[i] = v.(*Group)
+		entries[i].db = tx.db
+	}
+	return entries, nil
+}
+
 // Getgroups returns a list of the numeric ids of groups that the caller
 // belongs to.
 func Getgroups() []int {
@@ -260,31 +319,64 @@ func GetgroupsName() []string {
 
 // AddGroup adds a group.
 func AddGroup(name string, members ...string) (gid int, err error) {
-	s := NewGShadow(name, members...)
-	if err = s.Add(nil); err != nil {
+	return defaultDB.AddGroup(name, members...)
+}
+
+// AddGroup adds a group.
+func (db *DB) AddGroup(name string, members ...string) (gid int, err error) {
+	err = db.update(func(tx *Tx) (err error) {
+		gid, err = tx.AddGroup(name, members...)
+		return
+	})
+	return
+}
+
+// AddGroup stages a group to add to both group and gshadow files.
+func (tx *Tx) AddGroup(name string, members ...string) (gid int, err error) {
+	if err = tx.addGShadow(tx.db.NewGShadow(name, members...), nil); err != nil {
 		return
 	}
 
-	return NewGroup(name, members...).Add()
+	return tx.addGroup(tx.db.NewGroup(name, members...))
 }
 
 // AddSystemGroup adds a system group.
 func AddSystemGroup(name string, members ...string) (gid int, err error) {
-	s := NewGShadow(name, members...)
-	if err = s.Add(nil); err != nil {
+	return defaultDB.AddSystemGroup(name, members...)
+}
+
+// AddSystemGroup adds a system group.
+func (db *DB) AddSystemGroup(name string, members ...string) (gid int, err error) {
+	err = db.update(func(tx *Tx) (err error) {
+		gid, err = tx.AddSystemGroup(name, members...)
+		return
+	})
+	return
+}
+
+// AddSystemGroup stages a system group to add to both group and gshadow files.
+func (tx *Tx) AddSystemGroup(name string, members ...string) (gid int, err error) {
+	if err = tx.addGShadow(tx.db.NewGShadow(name, members...), nil); err != nil {
 		return
 	}
 
-	return NewSystemGroup(name, members...).Add()
+	return tx.addGroup(tx.db.NewSystemGroup(name, members...))
 }
 
 // Add adds a new group.
 // Whether GID is < 0, it will choose the first id available in the range set
 // in the system configuration.
 func (g *Group) Add() (gid int, err error) {
-	loadConfig()
+	err = dbOf(g.db).updateEntry(func(tx *Tx) (err error) {
+		gid, err = tx.addGroup(g)
+		return
+	})
+	return
+}
 
-	group, err := LookupGroup(g.Name)
+// addGroup stages a new group.
+func (tx *Tx) addGroup(g *Group) (gid int, err error) {
+	group, err := tx.LookupGroup(g.Name)
 	if err != nil {
 		if _, ok := err.(NoFoundError); !ok {
 			return 0, err
@@ -294,54 +386,68 @@ func (g *Group) Add() (gid int, err error) {
 		return 0, ErrGroupExist
 	}
 
-	if g.Name == "" {
-		return 0, RequiredError("Name")
+	if err = tx.db.policyOf(nil).ValidateName(g.Name); err != nil {
+		return 0, err
 	}
 
-	var db *dbfile
 	if g.GID < 0 {
-		db, gid, err = nextGUID(g.addSystemGroup)
+		f, err := tx.file(fileGroup)
 		if err != nil {
-			db.close()
+			return 0, err
+		}
+		if gid, err = tx.db.policyOf(nil).nextGUID(f.lines, g.addSystemGroup, g.Name, -1); err != nil {
 			return 0, err
 		}
 		g.GID = gid
 	} else {
-		db, err = openDBFile(fileGroup, os.O_WRONLY|os.O_APPEND)
-		if err != nil {
-			return
-		}
-
 		// Check if Id is unique.
-		_, err = LookupGID(g.GID)
+		_, err = tx.LookupGID(g.GID)
 		if err == nil {
 			return 0, IdUsedError(g.GID)
 		} else if _, ok := err.(NoFoundError); !ok {
 			return 0, err
 		}
+		gid = g.GID
 	}
 
 	g.password = "x"
 
-	_, err = db.file.WriteString(g.String())
-	err2 := db.close()
-	if err2 != nil && err == nil {
-		err = err2
+	if err = tx.appendRow(g.Name, g); err != nil {
+		return 0, err
 	}
-	return
+	return gid, nil
 }
 
 // DelGroup removes a group from the system.
-func DelGroup(name string) (err error) {
-	err = del(name, &Group{})
-	if err == nil {
-		err = del(name, &GShadow{})
+func DelGroup(name string) error { return defaultDB.DelGroup(name) }
+
+// DelGroup removes a group from the database.
+func (db *DB) DelGroup(name string) error {
+	return db.update(func(tx *Tx) error { return tx.DelGroup(name) })
+}
+
+// DelGroup stages the removing of a group from both group and gshadow files.
+func (tx *Tx) DelGroup(name string) error {
+	if err := tx.del(name, &Group{}); err != nil {
+		return err
 	}
-	return
+	return ignoreNoFound(tx.del(name, &GShadow{}))
 }
 
 // AddUsersToGroup adds the members to a group.
 func AddUsersToGroup(name string, members ...string) error {
+	return defaultDB.AddUsersToGroup(name, members...)
+}
+
+// AddUsersToGroup adds the members to a group.
+func (db *DB) AddUsersToGroup(name string, members ...string) error {
+	return db.update(func(tx *Tx) error {
+		return tx.AddUsersToGroup(name, members...)
+	})
+}
+
+// AddUsersToGroup stages the adding of the members to a group.
+func (tx *Tx) AddUsersToGroup(name string, members ...string) error {
 	if len(members) == 0 {
 		return fmt.Errorf("no members to add")
 	}
@@ -352,7 +458,7 @@ func AddUsersToGroup(name string, members ...string) error {
 	}
 
 	// Group
-	gr, err := LookupGroup(name)
+	gr, err := tx.LookupGroup(name)
 	if err != nil {
 		return err
 	}
@@ -361,7 +467,7 @@ func AddUsersToGroup(name string, members ...string) error {
 	}
 
 	// Shadow group
-	sg, err := LookupGShadow(name)
+	sg, err := tx.LookupGShadow(name)
 	if err != nil {
 		return err
 	}
@@ -370,14 +476,10 @@ func AddUsersToGroup(name string, members ...string) error {
 	}
 
 	// Editing
-	if err = edit(name, gr); err != nil {
-		return err
-	}
-	if err = edit(name, sg); err != nil {
+	if err = tx.edit(name, gr); err != nil {
 		return err
 	}
-
-	return nil
+	return tx.edit(name, sg)
 }
 
 func _addMembers(userList *[]string, members ...string) error {
@@ -401,6 +503,18 @@ func _addMembers(userList *[]string, members ...string) error {
 
 // DelUsersInGroup removes the specific members from a group.
 func DelUsersInGroup(name string, members ...string) error {
+	return defaultDB.DelUsersInGroup(name, members...)
+}
+
+// DelUsersInGroup removes the specific members from a group.
+func (db *DB) DelUsersInGroup(name string, members ...string) error {
+	return db.update(func(tx *Tx) error {
+		return tx.DelUsersInGroup(name, members...)
+	})
+}
+
+// DelUsersInGroup stages the removing of the specific members from a group.
+func (tx *Tx) DelUsersInGroup(name string, members ...string) error {
 	if len(members) == 0 {
 		return ErrNoMembers
 	}
@@ -411,7 +525,7 @@ func DelUsersInGroup(name string, members ...string) error {
 	}
 
 	// Group
-	gr, err := LookupGroup(name)
+	gr, err := tx.LookupGroup(name)
 	if err != nil {
 		return err
 	}
@@ -420,7 +534,7 @@ func DelUsersInGroup(name string, members ...string) error {
 	}
 
 	// Shadow group
-	sg, err := LookupGShadow(name)
+	sg, err := tx.LookupGShadow(name)
 	if err != nil {
 		return err
 	}
@@ -429,14 +543,10 @@ func DelUsersInGroup(name string, members ...string) error {
 	}
 
 	// Editing
-	if err = edit(name, gr); err != nil {
-		return err
-	}
-	if err = edit(name, sg); err != nil {
+	if err = tx.edit(name, gr); err != nil {
 		return err
 	}
-
-	return nil
+	return tx.edit(name, sg)
 }
 
 func _delMembers(userList *[]string, members ...string) error {
@@ -470,6 +580,92 @@ func _delMembers(userList *[]string, members ...string) error {
 	return nil
 }
 
+// == Modify
+//
+
+// A GroupMod represents the changes to do in a group, like it is done by
+// "groupmod(8)". The fields which are nil are not changed.
+type GroupMod struct {
+	Name *string
+	GID  *int
+}
+
+// ModGroup modifies a group of the system.
+func ModGroup(name string, changes *GroupMod) error {
+	return defaultDB.ModGroup(name, changes)
+}
+
+// ModGroup modifies a group of the database.
+func (db *DB) ModGroup(name string, changes *GroupMod) error {
+	return db.update(func(tx *Tx) error { return tx.ModGroup(name, changes) })
+}
+
+// ModGroup stages the changes of a group.
+// Whether the name is changed, it is also changed in the shadowed file.
+// Whether the GID is changed, it is also changed in the users which have it
+// like primary group. A nil changes does not change anything.
+func (tx *Tx) ModGroup(name string, changes *GroupMod) error {
+	if changes == nil {
+		changes = &GroupMod{}
+	}
+
+	g, err := tx.LookupGroup(name)
+	if err != nil {
+		return err
+	}
+	oldGID := g.GID
+
+	if changes.Name != nil && *changes.Name != name {
+		if err = tx.db.policyOf(nil).ValidateName(*changes.Name); err != nil {
+			return err
+		}
+		if _, err = tx.LookupGroup(*changes.Name); err == nil {
+			return ErrGroupExist
+		} else if _, ok := err.(NoFoundError); !ok {
+			return err
+		}
+		g.Name = *changes.Name
+	}
+	if changes.GID != nil && *changes.GID != g.GID {
+		if _, err = tx.LookupGID(*changes.GID); err == nil {
+			return IdUsedError(*changes.GID)
+		} else if _, ok := err.(NoFoundError); !ok {
+			return err
+		}
+		g.GID = *changes.GID
+	}
+
+	if err = tx.edit(name, g); err != nil {
+		return err
+	}
+
+	if g.Name != name {
+		gs, err := tx.LookupGShadow(name)
+		if err == nil {
+			gs.Name = g.Name
+			if err = tx.edit(name, gs); err != nil {
+				return err
+			}
+		} else if ignoreNoFound(err) != nil {
+			return err
+		}
+	}
+
+	if g.GID != oldGID {
+		users, err := tx.LookupInUser(U_GID, oldGID, -1)
+		if ignoreNoFound(err) != nil {
+			return err
+		}
+		for _, u := range users {
+			u.GID = g.GID
+			if err = tx.edit(u.Name, u); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 // == Utility
 //
 
@@ -9,12 +9,13 @@ package user
 import (
 	"bufio"
 	"io"
+	"io/ioutil"
 	"os"
 	"testing"
 )
 
 func TestGroupParser(t *testing.T) {
-	f, err := os.Open(fileGroup)
+	f, err := os.Open(testDB.path(fileGroup))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -39,22 +40,22 @@ func TestGroupParser(t *testing.T) {
 }
 
 func TestGroupFull(t *testing.T) {
-	entry, err := LookupGID(os.Getgid())
+	entry, err := testDB.LookupGID(os.Getgid())
 	if err != nil || entry == nil {
 		t.Error(err)
 	}
 
-	entry, err = LookupGroup("root")
+	entry, err = testDB.LookupGroup("root")
 	if err != nil || entry == nil {
 		t.Error(err)
 	}
 
-	entries, err := LookupInGroup(G_MEMBER, "", -1)
+	entries, err := testDB.LookupInGroup(G_MEMBER, "", -1)
 	if err != nil || entries == nil {
 		t.Error(err)
 	}
 
-	entries, err = LookupInGroup(G_ALL, nil, -1)
+	entries, err = testDB.LookupInGroup(G_ALL, nil, -1)
 	if err != nil || len(entries) == 0 {
 		t.Error(err)
 	}
@@ -62,23 +63,23 @@ func TestGroupFull(t *testing.T) {
 
 func TestGroupCount(t *testing.T) {
 	count := 5
-	entries, err := LookupInGroup(G_ALL, nil, count)
+	entries, err := testDB.LookupInGroup(G_ALL, nil, count)
 	if err != nil || len(entries) != count {
 		t.Error(err)
 	}
 }
 
 func TestGroupError(t *testing.T) {
-	_, err := LookupGroup("!!!???")
+	_, err := testDB.LookupGroup("!!!???")
 	if _, ok := err.(NoFoundError); !ok {
 		t.Error("expected to report NoFoundError")
 	}
 
-	if _, err = LookupInGroup(G_MEMBER, "", 0); err != errSearch {
+	if _, err = testDB.LookupInGroup(G_MEMBER, "", 0); err != errSearch {
 		t.Error("expected to report errSearch")
 	}
 
-	g := &Group{}
+	g := &Group{db: testDB}
 	if _, err = g.Add(); err != RequiredError("Name") {
 		t.Error("expected to report RequiredError")
 	}
@@ -89,7 +90,7 @@ func TestGetGroups(t *testing.T) {
 	gnames := GetgroupsName()
 
 	for i, gid := range gids {
-		g, err := LookupGID(gid)
+		g, err := testDB.LookupGID(gid)
 		if err != nil {
 			t.Error(err)
 		}
@@ -101,10 +102,10 @@ func TestGetGroups(t *testing.T) {
 }
 
 func TestGroup_Add(t *testing.T) {
-	group := NewGroup(GROUP, MEMBERS...)
+	group := testDB.NewGroup(GROUP, MEMBERS...)
 	testGroupAdd(t, group, MEMBERS, false)
 
-	group = NewSystemGroup(SYS_GROUP, MEMBERS...)
+	group = testDB.NewSystemGroup(SYS_GROUP, MEMBERS...)
 	testGroupAdd(t, group, MEMBERS, true)
 }
 
@@ -149,7 +150,7 @@ func testGroupAdd(t *testing.T, group *Group, members []string, ofSystem bool) {
 		name = GROUP
 	}
 
-	g, err := LookupGroup(name)
+	g, err := testDB.LookupGroup(name)
 	if err != nil {
 		t.Fatalf("%s: ", err)
 	}
@@ -166,30 +167,30 @@ func TestGroup_Members(t *testing.T) {
 	group := "g1"
 	member := "m0"
 
-	_, err := AddGroup(group, MEMBERS...)
+	_, err := testDB.AddGroup(group, MEMBERS...)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	g_first, err := LookupGroup(group)
+	g_first, err := testDB.LookupGroup(group)
 	if err != nil {
 		t.Fatal(err)
 	}
-	sg_first, err := LookupGShadow(group)
+	sg_first, err := testDB.LookupGShadow(group)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	err = AddUsersToGroup(group, member)
+	err = testDB.AddUsersToGroup(group, member)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	g_last, err := LookupGroup(group)
+	g_last, err := testDB.LookupGroup(group)
 	if err != nil {
 		t.Fatal(err)
 	}
-	sg_last, err := LookupGShadow(group)
+	sg_last, err := testDB.LookupGShadow(group)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -209,16 +210,16 @@ func TestGroup_Members(t *testing.T) {
 
 	// == Delete
 
-	err = DelUsersInGroup(group, member, USER)
+	err = testDB.DelUsersInGroup(group, member, USER)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	g_del, err := LookupGroup(group)
+	g_del, err := testDB.LookupGroup(group)
 	if err != nil {
 		t.Fatal(err)
 	}
-	sg_del, err := LookupGShadow(group)
+	sg_del, err := testDB.LookupGShadow(group)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -232,3 +233,75 @@ func TestGroup_Members(t *testing.T) {
 		t.Error("gshadow file: expected to remove members of a group")
 	}
 }
+
+func TestModGroup(t *testing.T) {
+	const name, newName = "g_mod", "g_mod2"
+
+	gid, err := testDB.AddGroup(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uid, err := testDB.AddUser("u_gmod", gid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	groupName, newGID := newName, gid+1000
+	if err = testDB.ModGroup(name, &GroupMod{Name: &groupName, GID: &newGID}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = testDB.LookupGroup(name); err == nil {
+		t.Errorf("expected to rename group %q", name)
+	}
+	g, err := testDB.LookupGroup(newName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.GID != newGID {
+		t.Errorf("expected to get GID %d, got %d", newGID, g.GID)
+	}
+	if _, err = testDB.LookupGShadow(newName); err != nil {
+		t.Error(err)
+	}
+	u, err := testDB.LookupUID(uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.GID != newGID {
+		t.Errorf("expected to change the primary group of the user, got GID %d", u.GID)
+	}
+
+	rootGID := 0
+	if err = testDB.ModGroup(newName, &GroupMod{GID: &rootGID}); err != IdUsedError(0) {
+		t.Errorf("expected to report IdUsedError, got: %v", err)
+	}
+
+	if err = testDB.DelUser(u.Name); err != nil {
+		t.Error(err)
+	}
+	if err = testDB.DelGroup(newName); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestModGroupNil(t *testing.T) {
+	const groupData = "g1:x:1000:u1\n"
+
+	db := newTestDB(t, map[string]string{
+		fileLogin:   "ENCRYPT_METHOD SHA512\n",
+		fileUser:    "u1:x:1000:1000::/home/u1:/bin/sh\n",
+		fileGroup:   groupData,
+		fileShadow:  "u1:*:18000:0:99999:7:::\n",
+		fileGShadow: "g1:!::u1\n",
+	})
+	if err := db.ModGroup("g1", nil); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := ioutil.ReadFile(db.path(fileGroup)); string(data) != groupData {
+		t.Errorf("expected to not change the group, got:\n%s", data)
+	}
+	if err := db.ModGroup("g2", nil); err == nil {
+		t.Error("expected to report the group not found")
+	}
+}
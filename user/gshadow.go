@@ -8,7 +8,6 @@ package user
 
 import (
 	"fmt"
-	"os"
 	"reflect"
 	"strings"
 )
@@ -80,13 +79,22 @@ type GShadow struct {
 	// Members can access the group without being prompted for a password.
 	// You should use the same list of users as in /etc/group.
 	UserList []string
+
+	db *DB // Database where the shadowed group is stored.
 }
 
 // NewGShadow returns a new GShadow.
 func NewGShadow(username string, members ...string) *GShadow {
+	return defaultDB.NewGShadow(username, members...)
+}
+
+// NewGShadow returns a new GShadow to add into the database.
+func (db *DB) NewGShadow(username string, members ...string) *GShadow {
 	return &GShadow{
 		Name:     username,
 		UserList: members,
+
+		db: db,
 	}
 }
 
@@ -105,10 +113,10 @@ func parseGShadow(row string) (*GShadow, error) {
 	}
 
 	return &GShadow{
-		fields[0],
-		fields[1],
-		strings.Split(fields[2], ","),
-		strings.Split(fields[3], ","),
+		Name:      fields[0],
+		password:  fields[1],
+		AdminList: strings.Split(fields[2], ","),
+		UserList:  strings.Split(fields[3], ","),
 	}, nil
 }
 
@@ -117,43 +125,41 @@ func parseGShadow(row string) (*GShadow, error) {
 
 // lookUp parses the shadowed group line searching a value into the field.
 // Returns nil if it isn't found.
-func (*GShadow) lookUp(line string, f field, value interface{}) interface{} {
+func (*GShadow) lookUp(line string, f field, value interface{}) (interface{}, error) {
 	_field := f.(gshadowField)
 	_value := value.(string)
-	allField := strings.Split(line, ":")
-	arrayField := make(map[int][]string)
 
-	arrayField[2] = strings.Split(allField[2], ",")
-	arrayField[3] = strings.Split(allField[3], ",")
+	gs, err := parseGShadow(line)
+	if err != nil {
+		return nil, err
+	}
 
 	// Check fields
 	var isField bool
-	if GS_NAME&_field != 0 && allField[0] == _value {
+	if GS_NAME&_field != 0 && gs.Name == _value {
 		isField = true
-	} else if GS_PASSWD&_field != 0 && allField[1] == _value {
+	} else if GS_PASSWD&_field != 0 && gs.password == _value {
 		isField = true
-	} else if GS_ADMIN&_field != 0 && checkGroup(arrayField[2], _value) {
+	} else if GS_ADMIN&_field != 0 && checkGroup(gs.AdminList, _value) {
 		isField = true
-	} else if GS_MEMBER&_field != 0 && checkGroup(arrayField[3], _value) {
+	} else if GS_MEMBER&_field != 0 && checkGroup(gs.UserList, _value) {
 		isField = true
 	} else if GS_ALL&_field != 0 {
 		isField = true
 	}
 
 	if isField {
-		return &GShadow{
-			allField[0],
-			allField[1],
-			arrayField[2],
-			arrayField[3],
-		}
+		return gs, nil
 	}
-	return nil
+	return nil, nil
 }
 
 // LookupGShadow looks up a shadowed group by name.
-func LookupGShadow(name string) (*GShadow, error) {
-	entries, err := LookupInGShadow(GS_NAME, name, 1)
+func LookupGShadow(name string) (*GShadow, error) { return defaultDB.LookupGShadow(name) }
+
+// LookupGShadow looks up a shadowed group by name.
+func (db *DB) LookupGShadow(name string) (*GShadow, error) {
+	entries, err := db.LookupInGShadow(GS_NAME, name, 1)
 	if err != nil {
 		return nil, err
 	}
@@ -168,9 +174,19 @@ func LookupGShadow(name string) (*GShadow, error) {
 //   n == 0: the result is nil (zero fields)
 //   n < 0: all fields
 func LookupInGShadow(field gshadowField, value string, n int) ([]*GShadow, error) {
+	return defaultDB.LookupInGShadow(field, value, n)
+}
+
+// LookupInGShadow looks up a shadowed group by the given values.
+//
+// The count determines the number of fields to return:
+//   n > 0: at most n fields
+//   n == 0: the result is nil (zero fields)
+//   n < 0: all fields
+func (db *DB) LookupInGShadow(field gshadowField, value string, n int) ([]*GShadow, error) {
 	checkRoot()
 
-	iEntries, err := lookUp(&GShadow{}, field, value, n)
+	iEntries, err := db.lookUp(&GShadow{}, field, value, n)
 	if err != nil {
 		return nil, err
 	}
@@ -181,11 +197,40 @@ func LookupInGShadow(field gshadowField, value string, n int) ([]*GShadow, error
 
 	for i := 0; i < valueSlice.Len(); i++ {
 		entries[i] = valueSlice.Index(i).Interface().(*GShadow)
+		entries[i].db = db
 	}
 
 	return entries, err
 }
 
+// LookupGShadow looks up a shadowed group by name, into the changes staged.
+func (tx *Tx) LookupGShadow(name string) (*GShadow, error) {
+	entries, err := tx.LookupInGShadow(GS_NAME, name, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	return entries[0], err
+}
+
+// LookupInGShadow looks up a shadowed group by the given values, into the
+// changes staged.
+func (tx *Tx) LookupInGShadow(field gshadowField, value string, n int) ([]*GShadow, error) {
+	checkRoot()
+
+	iEntries, err := tx.lookUp(&GShadow{}, field, value, n)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]*GShadow, len(iEntries))
+	for i, v := range iEntries {
+		entries[i] = v.(*GShadow)
+		entries[i].db = tx.db
+	}
+	return entries, nil
+}
+
 // == Editing
 //
 
@@ -193,10 +238,13 @@ func LookupInGShadow(field gshadowField, value string, n int) ([]*GShadow, error
 // If the key is not nil, generates a hashed password.
 //
 // It is created a backup before of modify the original file.
-func (gs *GShadow) Add(key []byte) (err error) {
-	loadConfig()
+func (gs *GShadow) Add(key []byte) error {
+	return dbOf(gs.db).updateEntry(func(tx *Tx) error { return tx.addGShadow(gs, key) })
+}
 
-	gshadow, err := LookupGShadow(gs.Name)
+// addGShadow stages a new shadowed group.
+func (tx *Tx) addGShadow(gs *GShadow, key []byte) (err error) {
+	gshadow, err := tx.LookupGShadow(gs.Name)
 	if err != nil {
 		if _, ok := err.(NoFoundError); !ok {
 			return
@@ -206,32 +254,176 @@ func (gs *GShadow) Add(key []byte) (err error) {
 		return ErrGroupExist
 	}
 
-	if gs.Name == "" {
-		return RequiredError("Name")
+	if err = tx.db.policyOf(nil).ValidateName(gs.Name); err != nil {
+		return err
+	}
+
+	if key != nil {
+		hash, err := tx.db.hashPasswd(key, nil)
+		if err != nil {
+			return err
+		}
+		gs.password = hash
+	} else {
+		gs.password = "*" // Password disabled.
 	}
 
-	// Backup
-	if err = backup(fileGShadow); err != nil {
-		return
+	return tx.appendRow(gs.Name, gs)
+}
+
+// == Administrators
+//
+
+// AddGroupAdmins adds the administrators to a group, who can change its
+// password and its members, like "gpasswd(1)" allows.
+func AddGroupAdmins(name string, admins ...string) error {
+	return defaultDB.AddGroupAdmins(name, admins...)
+}
+
+// AddGroupAdmins adds the administrators to a group.
+func (db *DB) AddGroupAdmins(name string, admins ...string) error {
+	return db.update(func(tx *Tx) error { return tx.AddGroupAdmins(name, admins...) })
+}
+
+// AddGroupAdmins stages the adding of the administrators to a group.
+func (tx *Tx) AddGroupAdmins(name string, admins ...string) error {
+	if len(admins) == 0 {
+		return fmt.Errorf("no administrators to add")
+	}
+	if err := tx.checkUsers("admins", admins); err != nil {
+		return err
 	}
 
-	db, err := openDBFile(fileGShadow, os.O_WRONLY|os.O_APPEND)
+	gs, err := tx.groupGShadow(name)
 	if err != nil {
-		return
+		return err
 	}
-	defer func() {
-		e := db.close()
-		if e != nil && err == nil {
-			err = e
-		}
-	}()
+	if err = _addMembers(&gs.AdminList, admins...); err != nil {
+		return err
+	}
+	return tx.edit(name, gs)
+}
 
-	if key != nil {
-		gs.password, _ = config.crypter.Generate(key, nil)
-	} else {
-		gs.password = "*" // Password disabled.
+// DelGroupAdmins removes the administrators from a group.
+func DelGroupAdmins(name string, admins ...string) error {
+	return defaultDB.DelGroupAdmins(name, admins...)
+}
+
+// DelGroupAdmins removes the administrators from a group.
+func (db *DB) DelGroupAdmins(name string, admins ...string) error {
+	return db.update(func(tx *Tx) error { return tx.DelGroupAdmins(name, admins...) })
+}
+
+// DelGroupAdmins stages the removing of the administrators from a group.
+func (tx *Tx) DelGroupAdmins(name string, admins ...string) error {
+	if len(admins) == 0 {
+		return ErrNoMembers
+	}
+
+	gs, err := tx.LookupGShadow(name)
+	if err != nil {
+		return err
+	}
+	if err = _delMembers(&gs.AdminList, admins...); err != nil {
+		return err
+	}
+	return tx.edit(name, gs)
+}
+
+// SetGroupAdmins sets the list of administrators of a group, like
+// "gpasswd -A". An empty list removes all.
+func SetGroupAdmins(name string, admins ...string) error {
+	return defaultDB.SetGroupAdmins(name, admins...)
+}
+
+// SetGroupAdmins sets the list of administrators of a group, like
+// "gpasswd -A". An empty list removes all.
+func (db *DB) SetGroupAdmins(name string, admins ...string) error {
+	return db.update(func(tx *Tx) error { return tx.SetGroupAdmins(name, admins...) })
+}
+
+// SetGroupAdmins stages the list of administrators of a group.
+func (tx *Tx) SetGroupAdmins(name string, admins ...string) error {
+	if err := tx.checkUsers("admins", admins); err != nil {
+		return err
+	}
+
+	gs, err := tx.groupGShadow(name)
+	if err != nil {
+		return err
+	}
+	gs.AdminList = admins
+	return tx.edit(name, gs)
+}
+
+// SetGroupMembers sets the list of members of a group, in both group and
+// gshadow files, like "gpasswd -M". An empty list removes all.
+func SetGroupMembers(name string, members ...string) error {
+	return defaultDB.SetGroupMembers(name, members...)
+}
+
+// SetGroupMembers sets the list of members of a group, in both group and
+// gshadow files, like "gpasswd -M". An empty list removes all.
+func (db *DB) SetGroupMembers(name string, members ...string) error {
+	return db.update(func(tx *Tx) error { return tx.SetGroupMembers(name, members...) })
+}
+
+// SetGroupMembers stages the list of members of a group, in both group and
+// gshadow files.
+func (tx *Tx) SetGroupMembers(name string, members ...string) error {
+	if err := tx.checkUsers("members", members); err != nil {
+		return err
+	}
+
+	gs, err := tx.groupGShadow(name)
+	if err != nil {
+		return err
+	}
+	gr, err := tx.LookupGroup(name)
+	if err != nil {
+		return err
+	}
+
+	gr.UserList = members
+	gs.UserList = members
+	if err = tx.edit(name, gr); err != nil {
+		return err
+	}
+	return tx.edit(name, gs)
+}
+
+// groupGShadow returns the shadowed entry of an existent group. Whether it is
+// not found, it is staged a new one with the members of the group, like
+// "gpasswd(1)" does.
+func (tx *Tx) groupGShadow(name string) (*GShadow, error) {
+	gr, err := tx.LookupGroup(name)
+	if err != nil {
+		return nil, err
 	}
 
-	_, err = db.file.WriteString(gs.String())
-	return
+	gs, err := tx.LookupGShadow(name)
+	if err == nil {
+		return gs, nil
+	} else if _, ok := err.(NoFoundError); !ok {
+		return nil, err
+	}
+
+	gs = tx.db.NewGShadow(name, gr.UserList...)
+	if err = tx.addGShadow(gs, nil); err != nil {
+		return nil, err
+	}
+	return gs, nil
+}
+
+// checkUsers checks that the users of the list are not empty and exist.
+func (tx *Tx) checkUsers(field string, users []string) error {
+	for i, v := range users {
+		if v == "" {
+			return EmptyMemberError(fmt.Sprintf("%s[%d]", field, i))
+		}
+		if _, err := tx.LookupUser(v); err != nil {
+			return err
+		}
+	}
+	return nil
 }
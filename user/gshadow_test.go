@@ -9,12 +9,13 @@ package user
 import (
 	"bufio"
 	"io"
+	"io/ioutil"
 	"os"
 	"testing"
 )
 
 func TestGShadowParser(t *testing.T) {
-	f, err := os.Open(fileGShadow)
+	f, err := os.Open(testDB.path(fileGShadow))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -39,17 +40,17 @@ func TestGShadowParser(t *testing.T) {
 }
 
 func TestGShadowFull(t *testing.T) {
-	entry, err := LookupGShadow("root")
+	entry, err := testDB.LookupGShadow("root")
 	if err != nil || entry == nil {
 		t.Error(err)
 	}
 
-	entries, err := LookupInGShadow(GS_PASSWD, "!", -1)
+	entries, err := testDB.LookupInGShadow(GS_PASSWD, "!", -1)
 	if err != nil || entries == nil {
 		t.Error(err)
 	}
 
-	entries, err = LookupInGShadow(GS_ALL, "", -1)
+	entries, err = testDB.LookupInGShadow(GS_ALL, "", -1)
 	if err != nil || len(entries) == 0 {
 		t.Error(err)
 	}
@@ -57,30 +58,30 @@ func TestGShadowFull(t *testing.T) {
 
 func TestGShadowCount(t *testing.T) {
 	count := 5
-	entries, err := LookupInGShadow(GS_ALL, "", count)
+	entries, err := testDB.LookupInGShadow(GS_ALL, "", count)
 	if err != nil || len(entries) != count {
 		t.Error(err)
 	}
 }
 
 func TestGShadowError(t *testing.T) {
-	_, err := LookupGShadow("!!!???")
+	_, err := testDB.LookupGShadow("!!!???")
 	if _, ok := err.(NoFoundError); !ok {
 		t.Error("expected to report NoFoundError")
 	}
 
-	if _, err = LookupInGShadow(GS_MEMBER, "", 0); err != errSearch {
+	if _, err = testDB.LookupInGShadow(GS_MEMBER, "", 0); err != errSearch {
 		t.Error("expected to report errSearch")
 	}
 
-	gs := &GShadow{}
+	gs := &GShadow{db: testDB}
 	if err = gs.Add(nil); err != RequiredError("Name") {
 		t.Error("expected to report RequiredError")
 	}
 }
 
 func TestGShadow_Add(t *testing.T) {
-	shadow := NewGShadow(GROUP, MEMBERS...)
+	shadow := testDB.NewGShadow(GROUP, MEMBERS...)
 	err := shadow.Add(nil)
 	if err != nil {
 		t.Fatal(err)
@@ -94,7 +95,7 @@ func TestGShadow_Add(t *testing.T) {
 		}
 	}
 
-	s, err := LookupGShadow(GROUP)
+	s, err := testDB.LookupGShadow(GROUP)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -110,20 +111,81 @@ var (
 )
 
 func TestGShadowCrypt(t *testing.T) {
-	gs, err := LookupGShadow(GROUP)
+	gs, err := testDB.LookupGShadow(GROUP)
 	if err != nil {
 		t.Fatal(err)
 	}
 	gs.Passwd(groupKey1)
-	if err = config.crypter.Verify(gs.password, groupKey1); err != nil {
+	if err = testDB.config.crypter.Verify(gs.password, groupKey1); err != nil {
 		t.Fatalf("expected to get the same hashed password for %q", groupKey1)
 	}
 
-	if err = ChGPasswd(GROUP, groupKey2); err != nil {
+	if err = testDB.ChGPasswd(GROUP, groupKey2); err != nil {
 		t.Fatalf("expected to change password: %s", err)
 	}
-	gs, _ = LookupGShadow(GROUP)
-	if err = config.crypter.Verify(gs.password, groupKey2); err != nil {
+	gs, _ = testDB.LookupGShadow(GROUP)
+	if err = testDB.config.crypter.Verify(gs.password, groupKey2); err != nil {
 		t.Fatalf("ChGPasswd: expected to get the same hashed password for %q", groupKey2)
 	}
 }
+
+func TestGroupAdmins(t *testing.T) {
+	db := newTestDB(t, map[string]string{
+		fileLogin:   "ENCRYPT_METHOD SHA512\n",
+		fileUser:    "u1:x:1000:1000::/home/u1:/bin/sh\nu2:x:1001:1000::/home/u2:/bin/sh\nu3:x:1002:1000::/home/u3:/bin/sh\n",
+		fileGroup:   "staff:x:1000:u1\ndev:x:1001:u2\n",
+		fileShadow:  "u1:*:18000::::::\nu2:*:18000::::::\nu3:*:18000::::::\n",
+		fileGShadow: "staff:!::u1\n",
+	})
+
+	if err := db.AddGroupAdmins("staff", "u1", "u2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AddGroupAdmins("staff", "u2"); err == nil {
+		t.Error("expected error by administrator already set")
+	}
+	if err := db.AddGroupAdmins("staff", "nobody"); err == nil {
+		t.Error("expected error by user not found")
+	}
+	if err := db.DelGroupAdmins("staff", "u1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.DelGroupAdmins("staff", "u3"); err != ErrNoMembers {
+		t.Errorf("expected ErrNoMembers, got %v", err)
+	}
+
+	if err := db.SetGroupMembers("staff", "u2", "u3"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetGroupMembers("staff", "u2", ""); err == nil {
+		t.Error("expected error by empty member")
+	}
+
+	// The shadowed entry is created whether it does not exist.
+	if err := db.SetGroupAdmins("dev", "u3"); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		fileGroup:   "staff:x:1000:u2,u3\ndev:x:1001:u2\n",
+		fileGShadow: "staff:!:u2:u2,u3\ndev:*:u3:u2\n",
+	}
+	for name, data := range expected {
+		got, err := ioutil.ReadFile(db.path(name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != data {
+			t.Errorf("%s: expected\n%s\ngot\n%s", name, data, got)
+		}
+	}
+
+	if err := db.SetGroupMembers("staff"); err != nil {
+		t.Fatal(err)
+	}
+	if gs, err := db.LookupGShadow("staff"); err != nil {
+		t.Fatal(err)
+	} else if len(memberList(gs.UserList)) != 0 {
+		t.Errorf("expected no members, got %v", gs.UserList)
+	}
+}
@@ -0,0 +1,40 @@
+// Copyright 2021 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package user
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/tredoe/osutil/file"
+)
+
+// newTestDB returns a database into a temporary directory, with the given
+// files. The configuration file of useradd is created whether it is not set.
+func newTestDB(t *testing.T, files map[string]string) *DB {
+	root, err := ioutil.TempDir("", file.PREFIX_TEMP+"user-db_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(root) })
+	db := NewDB(root)
+
+	if _, ok := files[fileUseradd]; !ok {
+		files[fileUseradd] = ""
+	}
+	for name, data := range files {
+		if err = os.MkdirAll(filepath.Dir(db.path(name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err = ioutil.WriteFile(db.path(name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db
+}
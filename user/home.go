@@ -0,0 +1,232 @@
+// Copyright 2021 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package user
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// homePath returns the path of a home directory into the root directory of the
+// database.
+// The directory has to be absolute and without ".." elements, and it cannot be
+// placed out of the root directory through symbolic links; else it returns a
+// HomeError.
+func (db *DB) homePath(dir string) (string, error) {
+	if !filepath.IsAbs(dir) {
+		return "", HomeError(dir)
+	}
+	for _, elem := range strings.Split(dir, "/") {
+		if elem == ".." {
+			return "", HomeError(dir)
+		}
+	}
+	name := db.path(dir)
+	if name == db.root {
+		return "", HomeError(dir)
+	}
+
+	root, err := filepath.EvalSymlinks(db.root)
+	if err != nil {
+		return "", err
+	}
+	// The nearest parent directory which exists has to be into the root.
+	for parent := filepath.Dir(name); ; parent = filepath.Dir(parent) {
+		real, err := filepath.EvalSymlinks(parent)
+		if err == nil {
+			if !inDir(root, real) {
+				return "", HomeError(dir)
+			}
+			break
+		}
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+		if parent == filepath.Dir(parent) {
+			break
+		}
+	}
+	return name, nil
+}
+
+// checkHomes checks the paths of the home directories.
+func (db *DB) checkHomes(dirs ...string) error {
+	for _, dir := range dirs {
+		if _, err := db.homePath(dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// inDir reports whether the path name is placed into the directory dir.
+func inDir(dir, name string) bool {
+	if dir == "/" || name == dir {
+		return true
+	}
+	return strings.HasPrefix(name, dir+string(filepath.Separator))
+}
+
+// checkNoSymlink returns a HomeError whether the home directory is a symbolic
+// link, which is not followed at removing or changing the owner of its files.
+func checkNoSymlink(name, dir string) error {
+	info, err := os.Lstat(name)
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		return HomeError(dir)
+	}
+	return nil
+}
+
+// checkHomeOwner returns a HomeError whether the home directory of the user is
+// a symbolic link or it is not owned by the user; like "userdel -r", so a
+// directory of the system set like home (as "/usr/sbin" or "/dev") is not
+// removed.
+func (db *DB) checkHomeOwner(u *User) error {
+	dir, err := db.homePath(u.Dir)
+	if err != nil {
+		return err
+	}
+	if err = checkNoSymlink(dir, u.Dir); err != nil {
+		return err
+	}
+
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	if uid, _ := fileOwner(info); uid != u.UID {
+		return HomeError(u.Dir)
+	}
+	return nil
+}
+
+// createHome creates the home directory of the user with the mode got from the
+// policy, copying the files of the skeleton directory (SKEL in the configuration
+// of useradd), all of them owned by the user.
+// Like "useradd(8)", the directory is not touched whether it already exists.
+func (db *DB) createHome(u *User, p *Policy) error {
+	dir, err := db.homePath(u.Dir)
+	if err != nil {
+		return err
+	}
+
+	if found, err := exist(dir); found || err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+		return err
+	}
+
+	if err := os.Mkdir(dir, 0700); err != nil {
+		return err
+	}
+	if err := os.Chown(dir, u.UID, u.GID); err != nil {
+		return err
+	}
+
+	if err = copySkel(db.path(p.Skel), dir, u.UID, u.GID); err != nil {
+		return err
+	}
+	// The mode is set at the end since it could not allow to write into.
+	return os.Chmod(dir, p.HomeMode)
+}
+
+// copySkel copies the files of the skeleton directory into the home directory,
+// owned by the given user and group ids. A skeleton not found is skipped.
+func copySkel(skel, home string, uid, gid int) error {
+	if found, err := exist(skel); !found {
+		return err
+	}
+
+	return filepath.Walk(skel, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(skel, name)
+		if err != nil || rel == "." {
+			return err
+		}
+		dst := filepath.Join(home, rel)
+
+		switch mode := info.Mode(); {
+		case mode.IsDir():
+			if err = os.Mkdir(dst, mode.Perm()); err != nil {
+				return err
+			}
+			if err = os.Chmod(dst, mode.Perm()); err != nil {
+				return err
+			}
+		case mode&os.ModeSymlink != 0:
+			target, err := os.Readlink(name)
+			if err != nil {
+				return err
+			}
+			if err = os.Symlink(target, dst); err != nil {
+				return err
+			}
+		case mode.IsRegular():
+			if err = copyFile(name, dst, mode.Perm()); err != nil {
+				return err
+			}
+		default: // Devices, sockets and pipes are not copied.
+			return nil
+		}
+		return os.Lchown(dst, uid, gid)
+	})
+}
+
+// copyFile copies the content of the file src to the new file dst.
+func copyFile(src, dst string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err = out.Close(); err != nil {
+		return err
+	}
+	return os.Chmod(dst, perm)
+}
+
+// removeHome removes the home directory and the mail spool of the user, like
+// "userdel -r". Files not found are skipped.
+// A home directory which is a symbolic link or is not owned by the user is not
+// removed.
+func (db *DB) removeHome(u *User, p *Policy) error {
+	if u.Dir != "" && filepath.Clean(u.Dir) != "/" &&
+		filepath.Clean(u.Dir) != filepath.Clean(p.Home) {
+		err := db.checkHomeOwner(u)
+		if err == nil {
+			err = os.RemoveAll(db.path(u.Dir))
+		}
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	if p.MailDir != "" {
+		err := os.Remove(db.path(filepath.Join(p.MailDir, u.Name)))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,180 @@
+// Copyright 2021 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package user
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHome(t *testing.T) {
+	const name = "u_home"
+
+	skel := testDB.path(testDB.config.policy.Skel)
+	if err := os.MkdirAll(filepath.Join(skel, ".config"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(skel, ".profile"), []byte("# foo\n"), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	uid, err := testDB.AddUserWithOptions(name, GID, &AddUserOptions{CreateHome: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := testDB.LookupUser(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	home := testDB.path(u.Dir)
+
+	info, err := os.Stat(home)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != testDB.config.policy.HomeMode {
+		t.Errorf("expected mode %o, got %o", testDB.config.policy.HomeMode, info.Mode().Perm())
+	}
+	if owner, _ := fileOwner(info); owner != uid {
+		t.Errorf("expected to be owned by %d, got %d", uid, owner)
+	}
+
+	info, err = os.Stat(filepath.Join(home, ".profile"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0640 {
+		t.Errorf("skeleton: expected mode 0640, got %o", info.Mode().Perm())
+	}
+	if owner, group := fileOwner(info); owner != uid || group != GID {
+		t.Errorf("skeleton: expected to be owned by %d:%d, got %d:%d", uid, GID, owner, group)
+	}
+	if found, _ := exist(filepath.Join(home, ".config")); !found {
+		t.Error("skeleton: expected to copy the directories")
+	}
+
+	mail := testDB.path(filepath.Join(testDB.config.policy.MailDir, name))
+	if err = os.MkdirAll(filepath.Dir(mail), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(mail, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = testDB.DelUserWithOptions(name, &DelUserOptions{RemoveHome: true}); err != nil {
+		t.Fatal(err)
+	}
+	if found, _ := exist(home); found {
+		t.Error("expected to remove the home directory")
+	}
+	if found, _ := exist(mail); found {
+		t.Error("expected to remove the mail spool")
+	}
+}
+
+func TestHomePath(t *testing.T) {
+	db := newTestDB(t, map[string]string{
+		fileLogin:  "ENCRYPT_METHOD SHA512\n",
+		fileUser:   "u1:x:1000:1000::/../victim:/bin/sh\nu2:x:1001:1000::/link/u2:/bin/sh\nu3:x:1002:1000::/home/u3:/bin/sh\n",
+		fileShadow: "u1:*:18000:0:99999:7:::\nu2:*:18000:0:99999:7:::\nu3:*:18000:0:99999:7:::\n",
+	})
+	outside, err := ioutil.TempDir("", "test-user-outside_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(outside)
+
+	if err = os.Symlink(outside, db.path("/link")); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.MkdirAll(db.path("/home"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Symlink(outside, db.path("/home/u3")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, dir := range []string{"home/u1", "/home/../../u1", "/link/u1", "/"} {
+		if _, err = db.homePath(dir); err != HomeError(dir) {
+			t.Errorf("%s: expected HomeError, got: %v", dir, err)
+		}
+	}
+	if name, err := db.homePath("/home/u1"); err != nil || name != db.path("/home/u1") {
+		t.Errorf("unexpected path %q: %v", name, err)
+	}
+
+	opts := &DelUserOptions{RemoveHome: true}
+	if err = db.DelUserWithOptions("u1", opts); err != HomeError("/../victim") {
+		t.Errorf("expected HomeError, got: %v", err)
+	}
+	if err = db.DelUserWithOptions("u2", opts); err != HomeError("/link/u2") {
+		t.Errorf("expected HomeError, got: %v", err)
+	}
+	if err = db.DelUserWithOptions("u3", opts); err != HomeError("/home/u3") {
+		t.Errorf("expected HomeError for a symbolic link, got: %v", err)
+	}
+	if found, _ := exist(outside); !found {
+		t.Error("expected to not remove the directory placed out of the root")
+	}
+}
+
+func TestRemoveHomeOwner(t *testing.T) {
+	db := newTestDB(t, map[string]string{
+		fileLogin:  "ENCRYPT_METHOD SHA512\n",
+		fileUser:   "daemon:x:1:1::/usr/sbin:/bin/sh\nu1:x:1000:1000::/home/u1:/bin/sh\n",
+		fileShadow: "daemon:*:18000:0:99999:7:::\nu1:*:18000:0:99999:7:::\n",
+	})
+	for _, dir := range []string{"/usr/sbin", "/home/u1"} {
+		if err := os.MkdirAll(db.path(dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Lchown(db.path("/usr/sbin"), 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Lchown(db.path("/home/u1"), 1000, 1000); err != nil {
+		t.Fatal(err)
+	}
+	opts := &DelUserOptions{RemoveHome: true}
+
+	// The directory of the system is owned by other user.
+	if err := db.DelUserWithOptions("daemon", opts); err != HomeError("/usr/sbin") {
+		t.Errorf("expected HomeError, got: %v", err)
+	}
+	if _, err := db.LookupUser("daemon"); err != nil {
+		t.Errorf("expected to keep the user: %v", err)
+	}
+	if found, _ := exist(db.path("/usr/sbin")); !found {
+		t.Error("expected to not remove a directory owned by other user")
+	}
+
+	// The owner is checked again after of writing the files.
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = tx.DelUserWithOptions("u1", opts); err != nil {
+		tx.Rollback()
+		t.Fatal(err)
+	}
+	if err = os.Lchown(db.path("/home/u1"), 0, 0); err != nil {
+		tx.Rollback()
+		t.Fatal(err)
+	}
+	err = tx.Commit()
+	if e, ok := err.(PostCommitError); !ok || e.Err != HomeError("/home/u1") {
+		t.Errorf("expected PostCommitError, got: %v", err)
+	}
+	if _, err = db.LookupUser("u1"); err == nil {
+		t.Error("expected to keep the user removed")
+	}
+	if found, _ := exist(db.path("/home/u1")); !found {
+		t.Error("expected to not remove a directory owned by other user")
+	}
+}
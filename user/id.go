@@ -7,151 +7,237 @@
 package user
 
 import (
-	"io"
-	"os"
+	"hash/fnv"
+	"io/ioutil"
 	"strconv"
 )
 
-// nextUID returns the next free user id to use, according to whether it is a
-// system's user.
-func nextUID(isSystem bool) (db *dbfile, uid int, err error) {
-	loadConfig()
+// An IDAllocation represents the way to choose a free id for a new user or
+// group, into the range of ids set in the policy.
+type IDAllocation int
 
-	db, err = openDBFile(fileUser, os.O_RDWR)
-	if err != nil {
-		return
-	}
+const (
+	// AllocNext chooses the id after the highest one used; or the lowest id
+	// free whether the highest one is at the end of the range.
+	AllocNext IDAllocation = iota
 
-	// Seek to file half size.
+	// AllocLowest chooses the lowest id free, so the ids of the accounts
+	// removed are reused.
+	AllocLowest
 
-	info, err := db.file.Stat()
-	if err != nil {
-		db.close()
-		return nil, 0, err
-	}
-	if _, err = db.file.Seek(info.Size()/2, io.SeekStart); err != nil {
-		db.close()
-		return nil, 0, err
-	}
-	// To starting to read from a new line
-	if _, _, err = db.rd.ReadLine(); err != nil {
-		db.close()
-		return nil, 0, err
+	// AllocHash chooses the id got from a hash of the name, so an account
+	// gets the same id on every host; whether it is used, the next free one is
+	// chosen. Without a name, it is done like AllocNext.
+	AllocHash
+)
+
+// An IDRange represents a range of ids, from Min to Max both inclusive.
+type IDRange struct {
+	Min int
+	Max int
+}
+
+// inRanges reports whether the id is into some of the ranges.
+func inRanges(ranges []IDRange, id int) bool {
+	for _, r := range ranges {
+		if id >= r.Min && id <= r.Max {
+			return true
+		}
 	}
+	return false
+}
 
+// nextUID returns the next free user id to use for the named user, according
+// to whether it is a system's user. The ids are got from the lines of the user
+// file.
+// The preferred id is returned whether it is free, like the id of the primary
+// group when PreferSameID is set; a negative value is to have no preference.
+func (p *Policy) nextUID(lines []string, isSystem bool, name string, prefer int) (int, error) {
 	var minUid, maxUid int
 	if isSystem {
-		minUid, maxUid = config.login.SYS_UID_MIN, config.login.SYS_UID_MAX
+		minUid, maxUid = p.SysUIDMin, p.SysUIDMax
 	} else {
-		minUid, maxUid = config.login.UID_MIN, config.login.UID_MAX
+		minUid, maxUid = p.UIDMin, p.UIDMax
 	}
 
-	for {
-		line, _, err := db.rd.ReadLine()
-		if err == io.EOF {
-			break
+	used := make(map[int]bool)
+
+	for _, line := range lines {
+		if isComment(line) {
+			continue
 		}
 
-		u, err := parseUser(string(line))
+		u, err := parseUser(line)
 		if err != nil {
-			db.close()
-			return nil, 0, err
+			return 0, err
 		}
 		if u.UID >= minUid && u.UID <= maxUid {
-			uid = u.UID
+			used[u.UID] = true
 		}
 	}
 
-	uid++
-	if uid == maxUid {
-		return nil, 0, &IdRangeError{maxUid, isSystem, true}
+	if uid, ok := p.allocID(used, p.ReservedUIDs, name, prefer, minUid, maxUid); ok {
+		return uid, nil
 	}
-	return
+	return 0, &IdRangeError{maxUid, isSystem, true}
 }
 
-// nextGUID returns the next free group id to use, according to whether it is a
-// system's group.
-func nextGUID(isSystem bool) (db *dbfile, gid int, err error) {
-	loadConfig()
-
-	db, err = openDBFile(fileGroup, os.O_RDWR)
-	if err != nil {
-		return
+// nextGUID returns the next free group id to use for the named group, according
+// to whether it is a system's group. The ids are got from the lines of the group
+// file.
+// The preferred id is returned whether it is free; a negative value is to have
+// no preference.
+func (p *Policy) nextGUID(lines []string, isSystem bool, name string, prefer int) (int, error) {
+	var minGid, maxGid int
+	if isSystem {
+		minGid, maxGid = p.SysGIDMin, p.SysGIDMax
+	} else {
+		minGid, maxGid = p.GIDMin, p.GIDMax
 	}
 
-	// Seek to file half size.
+	used := make(map[int]bool)
 
-	info, err := db.file.Stat()
-	if err != nil {
-		db.close()
-		return nil, 0, err
-	}
-	if _, err = db.file.Seek(info.Size()/2, io.SeekStart); err != nil {
-		db.close()
-		return nil, 0, err
+	for _, line := range lines {
+		if isComment(line) {
+			continue
+		}
+
+		gr, err := parseGroup(line)
+		if err != nil {
+			return 0, err
+		}
+		if gr.GID >= minGid && gr.GID <= maxGid {
+			used[gr.GID] = true
+		}
 	}
-	// To starting to read from a new line
-	if _, _, err = db.rd.ReadLine(); err != nil {
-		db.close()
-		return nil, 0, err
+
+	if gid, ok := p.allocID(used, p.ReservedGIDs, name, prefer, minGid, maxGid); ok {
+		return gid, nil
 	}
+	return 0, &IdRangeError{maxGid, isSystem, false}
+}
 
-	var minGid, maxGid int
-	if isSystem {
-		minGid, maxGid = config.login.SYS_GID_MIN, config.login.SYS_GID_MAX
-	} else {
-		minGid, maxGid = config.login.GID_MIN, config.login.GID_MAX
+// allocID returns a free id in the range, skipping the ones used and the ones
+// reserved, according to the allocation of the policy.
+func (p *Policy) allocID(used map[int]bool, reserved []IDRange, name string, prefer, min, max int) (int, bool) {
+	free := func(id int) bool { return !used[id] && !inRanges(reserved, id) }
+
+	if prefer >= min && prefer <= max && free(prefer) {
+		return prefer, true
 	}
 
-	for {
-		line, _, err := db.rd.ReadLine()
-		if err == io.EOF {
+	switch p.IDAllocation {
+	case AllocLowest:
+		for id := min; id <= max; id++ {
+			if free(id) {
+				return id, true
+			}
+		}
+		return 0, false
+
+	case AllocHash:
+		if name == "" || max < min {
 			break
 		}
+		h := fnv.New32a()
+		h.Write([]byte(name))
 
-		gr, err := parseGroup(string(line))
-		if err != nil {
-			db.close()
-			return nil, 0, err
+		n := max - min + 1
+		start := int(h.Sum32() % uint32(n))
+		for i := 0; i < n; i++ {
+			if id := min + (start+i)%n; free(id) {
+				return id, true
+			}
 		}
-		if gr.GID >= minGid && gr.GID <= maxGid {
-			gid = gr.GID
+		return 0, false
+	}
+
+	highest := min - 1
+	for id := range used {
+		if id > highest {
+			highest = id
+		}
+	}
+	for id := highest + 1; id <= max; id++ {
+		if free(id) {
+			return id, true
 		}
 	}
+	for id := min; id <= highest; id++ {
+		if free(id) {
+			return id, true
+		}
+	}
+	return 0, false
+}
 
-	gid++
-	if gid == maxGid {
-		return nil, 0, &IdRangeError{maxGid, isSystem, false}
+// readLines returns the lines of the named file of the database.
+func (db *DB) readLines(name string) ([]string, error) {
+	data, err := ioutil.ReadFile(db.path(name))
+	if err != nil {
+		return nil, err
 	}
-	return
+	return splitLines(data), nil
 }
 
 // NextSystemUID returns the next free system user id to use.
-func NextSystemUID() (int, error) {
-	db, uid, err := nextUID(true)
-	db.close()
-	return uid, err
+func NextSystemUID() (int, error) { return defaultDB.NextSystemUID() }
+
+// NextSystemUID returns the next free system user id to use.
+func (db *DB) NextSystemUID() (int, error) {
+	lines, err := db.readLines(fileUser)
+	if err != nil {
+		return 0, err
+	}
+	if err = db.initConfig(); err != nil {
+		return 0, err
+	}
+	return db.policyOf(nil).nextUID(lines, true, "", -1)
 }
 
 // NextSystemGID returns the next free system group id to use.
-func NextSystemGID() (int, error) {
-	db, gid, err := nextGUID(true)
-	db.close()
-	return gid, err
+func NextSystemGID() (int, error) { return defaultDB.NextSystemGID() }
+
+// NextSystemGID returns the next free system group id to use.
+func (db *DB) NextSystemGID() (int, error) {
+	lines, err := db.readLines(fileGroup)
+	if err != nil {
+		return 0, err
+	}
+	if err = db.initConfig(); err != nil {
+		return 0, err
+	}
+	return db.policyOf(nil).nextGUID(lines, true, "", -1)
 }
 
 // NextUID returns the next free user id to use.
-func NextUID() (int, error) {
-	db, uid, err := nextUID(false)
-	db.close()
-	return uid, err
+func NextUID() (int, error) { return defaultDB.NextUID() }
+
+// NextUID returns the next free user id to use.
+func (db *DB) NextUID() (int, error) {
+	lines, err := db.readLines(fileUser)
+	if err != nil {
+		return 0, err
+	}
+	if err = db.initConfig(); err != nil {
+		return 0, err
+	}
+	return db.policyOf(nil).nextUID(lines, false, "", -1)
 }
 
 // NextGID returns the next free group id to use.
-func NextGID() (int, error) {
-	db, gid, err := nextGUID(false)
-	db.close()
-	return gid, err
+func NextGID() (int, error) { return defaultDB.NextGID() }
+
+// NextGID returns the next free group id to use.
+func (db *DB) NextGID() (int, error) {
+	lines, err := db.readLines(fileGroup)
+	if err != nil {
+		return 0, err
+	}
+	if err = db.initConfig(); err != nil {
+		return 0, err
+	}
+	return db.policyOf(nil).nextGUID(lines, false, "", -1)
 }
 
 // * * *
@@ -15,7 +15,7 @@ import (
 // in the systems file.
 
 func TestID(t *testing.T) {
-	id, err := NextSystemUID()
+	id, err := testDB.NextSystemUID()
 	if err != nil {
 		t.Error(err)
 	}
@@ -23,24 +23,110 @@ func TestID(t *testing.T) {
 		fmt.Print(" Next system UID: ", id)
 	}
 
-	if id, err = NextUID(); err != nil {
+	if id, err = testDB.NextUID(); err != nil {
 		t.Error(err)
 	}
 	if testing.Verbose() {
 		fmt.Println("\tNext UID:", id)
 	}
 
-	if id, err = NextSystemGID(); err != nil {
+	if id, err = testDB.NextSystemGID(); err != nil {
 		t.Error(err)
 	}
 	if testing.Verbose() {
 		fmt.Print(" Next system GID: ", id)
 	}
 
-	if id, err = NextGID(); err != nil {
+	if id, err = testDB.NextGID(); err != nil {
 		t.Error(err)
 	}
 	if testing.Verbose() {
 		fmt.Println("\tNext GID:", id)
 	}
 }
+
+func TestAllocID(t *testing.T) {
+	used := map[int]bool{1000: true, 1001: true, 1003: true}
+
+	for _, tt := range []struct {
+		policy   Policy
+		reserved []IDRange
+		prefer   int
+		expected int
+	}{
+		{Policy{}, nil, -1, 1004},
+		{Policy{}, []IDRange{{1004, 1006}}, -1, 1007},
+		{Policy{}, nil, 1001, 1004},
+		{Policy{}, nil, 1002, 1002},
+		{Policy{}, nil, 2000, 1004}, // Out of range.
+		{Policy{}, []IDRange{{1002, 1002}}, 1002, 1004},
+		{Policy{IDAllocation: AllocLowest}, nil, -1, 1002},
+		{Policy{IDAllocation: AllocLowest}, []IDRange{{1002, 1002}}, -1, 1004},
+		{Policy{IDAllocation: AllocNext}, []IDRange{{1004, 1010}}, -1, 1002}, // Wrapped.
+	} {
+		id, ok := tt.policy.allocID(used, tt.reserved, "", tt.prefer, 1000, 1010)
+		if !ok || id != tt.expected {
+			t.Errorf("%+v, reserved %v, prefer %d: expected %d, got %d (%v)",
+				tt.policy, tt.reserved, tt.prefer, tt.expected, id, ok)
+		}
+	}
+
+	// Full range
+	p := &Policy{IDAllocation: AllocLowest}
+	if _, ok := p.allocID(used, []IDRange{{1002, 1002}}, "", -1, 1000, 1003); ok {
+		t.Error("expected no free id")
+	}
+
+	// Hash
+	p = &Policy{IDAllocation: AllocHash}
+	id1, ok := p.allocID(map[int]bool{}, nil, "postgres", -1, 1000, 60000)
+	if !ok {
+		t.Fatal("expected an id")
+	}
+	if id, _ := p.allocID(map[int]bool{}, nil, "postgres", -1, 1000, 60000); id != id1 {
+		t.Errorf("expected the same id, got %d and %d", id1, id)
+	}
+	if id, _ := p.allocID(map[int]bool{}, nil, "redis", -1, 1000, 60000); id == id1 {
+		t.Errorf("expected other id for other name, got %d", id)
+	}
+	if id, _ := p.allocID(map[int]bool{id1: true}, nil, "postgres", -1, 1000, 60000); id != id1+1 &&
+		!(id1 == 60000 && id == 1000) {
+		t.Errorf("expected the next id to %d, got %d", id1, id)
+	}
+	if id, _ := p.allocID(used, nil, "", -1, 1000, 1010); id != 1004 {
+		t.Errorf("expected the next id without name, got %d", id)
+	}
+}
+
+func TestPreferSameID(t *testing.T) {
+	db := newTestDB(t, map[string]string{
+		fileLogin:   "ENCRYPT_METHOD SHA512\nUID_MIN 1000\nUID_MAX 60000\nGID_MIN 1000\nGID_MAX 60000\n",
+		fileUser:    "u1:x:1000:1000::/home/u1:/bin/sh\n",
+		fileGroup:   "g1:x:1000:\ng2:x:1005:\n",
+		fileShadow:  "u1:*:18000::::::\n",
+		fileGShadow: "g1:!::\ng2:!::\n",
+	})
+
+	p, err := db.LoadPolicy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.PreferSameID = true
+	p.ReservedUIDs = []IDRange{{1001, 1001}}
+
+	uid, err := db.AddUserWithOptions("u2", 1005, &AddUserOptions{Policy: p})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uid != 1005 {
+		t.Errorf("expected the UID of the GID, got %d", uid)
+	}
+
+	// GID already used like UID.
+	if uid, err = db.AddUserWithOptions("u3", 1000, &AddUserOptions{Policy: p}); err != nil {
+		t.Fatal(err)
+	}
+	if uid != 1006 {
+		t.Errorf("expected the next UID, got %d", uid)
+	}
+}
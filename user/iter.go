@@ -0,0 +1,340 @@
+// Copyright 2021 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package user
+
+import (
+	"io"
+	"os"
+	"strings"
+)
+
+// An Iter iterates over the lines of a file of the database, in the order they
+// are stored.
+//
+// The lines which are not entries, like comments, blank lines or the ones of
+// NIS (starting with "+" or "-"), are returned too, so a file can be listed or
+// rebuilt without losing them.
+//
+//	it, err := db.IterUsers()
+//	if err != nil { ... }
+//	defer it.Close()
+//	for it.Next() {
+//		if u := it.User(); u != nil { ... }
+//	}
+//	if err = it.Err(); err != nil { ... }
+type Iter struct {
+	db       *DB
+	filename string
+	parse    func(row string) (interface{}, error)
+	read     func() (string, error)
+	close    func() error
+
+	line  int
+	text  string
+	entry interface{}
+	err   error
+}
+
+// newIter returns an iterator over the lines of the named file.
+func (db *DB) newIter(name string, parse func(string) (interface{}, error)) (*Iter, error) {
+	filename := db.path(name)
+
+	dbf, err := openDBFile(filename, os.O_RDONLY)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Iter{
+		db:       db,
+		filename: filename,
+		parse:    parse,
+		read: func() (string, error) {
+			line, err := dbf.rd.ReadString('\n')
+			if err == io.EOF && line != "" {
+				err = nil
+			}
+			return strings.TrimSuffix(line, "\n"), err
+		},
+		close: dbf.close,
+	}, nil
+}
+
+// newIter returns an iterator over the lines staged of the named file.
+func (tx *Tx) newIter(name string, parse func(string) (interface{}, error)) (*Iter, error) {
+	f, err := tx.file(name)
+	if err != nil {
+		return nil, err
+	}
+	lines := f.lines
+
+	return &Iter{
+		db:       tx.db,
+		filename: tx.db.path(name),
+		parse:    parse,
+		read: func() (string, error) {
+			if len(lines) == 0 {
+				return "", io.EOF
+			}
+			line := lines[0]
+			lines = lines[1:]
+			return line, nil
+		},
+		close: func() error { return nil },
+	}, nil
+}
+
+// Next advances to the next line, which is then available through the methods
+// Line, Text and the ones of the entries. It returns false when there are no
+// more lines or an error happens, like a malformed entry.
+func (it *Iter) Next() bool {
+	if it.err != nil {
+		return false
+	}
+
+	text, err := it.read()
+	if err != nil {
+		if err != io.EOF {
+			it.err = err
+		}
+		it.text, it.entry = "", nil
+		return false
+	}
+	it.line++
+	it.text, it.entry = text, nil
+
+	if isComment(text) {
+		return true
+	}
+
+	if it.entry, it.err = it.parse(text); it.err != nil {
+		it.entry = nil
+		return false
+	}
+
+	switch v := it.entry.(type) {
+	case *User:
+		v.db = it.db
+	case *Group:
+		v.db = it.db
+	case *Shadow:
+		v.db = it.db
+	case *GShadow:
+		v.db = it.db
+	}
+	return true
+}
+
+// Err returns the error found at iterating, if any.
+func (it *Iter) Err() error { return it.err }
+
+// Close closes the file.
+func (it *Iter) Close() error { return it.close() }
+
+// Filename returns the name of the file iterated.
+func (it *Iter) Filename() string { return it.filename }
+
+// Line returns the number of the actual line, starting at 1.
+func (it *Iter) Line() int { return it.line }
+
+// Text returns the content of the actual line, without the newline.
+func (it *Iter) Text() string { return it.text }
+
+// IsComment reports whether the actual line is not an entry, like a comment, a
+// blank line or a line of NIS.
+func (it *Iter) IsComment() bool { return it.entry == nil }
+
+// User returns the user of the actual line, or nil whether it is not an entry
+// of the users file.
+func (it *Iter) User() *User {
+	u, _ := it.entry.(*User)
+	return u
+}
+
+// Group returns the group of the actual line, or nil whether it is not an entry
+// of the groups file.
+func (it *Iter) Group() *Group {
+	g, _ := it.entry.(*Group)
+	return g
+}
+
+// Shadow returns the shadowed user of the actual line, or nil whether it is not
+// an entry of the shadowed users file.
+func (it *Iter) Shadow() *Shadow {
+	s, _ := it.entry.(*Shadow)
+	return s
+}
+
+// GShadow returns the shadowed group of the actual line, or nil whether it is
+// not an entry of the shadowed groups file.
+func (it *Iter) GShadow() *GShadow {
+	gs, _ := it.entry.(*GShadow)
+	return gs
+}
+
+// entries returns the entries of the lines left, closing the iterator.
+func (it *Iter) entries() ([]interface{}, error) {
+	defer it.Close()
+
+	entries := make([]interface{}, 0, 16)
+	for it.Next() {
+		if it.entry != nil {
+			entries = append(entries, it.entry)
+		}
+	}
+	if it.err != nil {
+		return nil, it.err
+	}
+	return entries, nil
+}
+
+func parseUserEntry(row string) (interface{}, error)    { return parseUser(row) }
+func parseGroupEntry(row string) (interface{}, error)   { return parseGroup(row) }
+func parseShadowEntry(row string) (interface{}, error)  { return parseShadow(row) }
+func parseGShadowEntry(row string) (interface{}, error) { return parseGShadow(row) }
+
+// * * *
+
+// IterUsers returns an iterator over the lines of the users file.
+func IterUsers() (*Iter, error) { return defaultDB.IterUsers() }
+
+// IterUsers returns an iterator over the lines of the users file.
+func (db *DB) IterUsers() (*Iter, error) { return db.newIter(fileUser, parseUserEntry) }
+
+// IterUsers returns an iterator over the lines staged of the users file.
+func (tx *Tx) IterUsers() (*Iter, error) { return tx.newIter(fileUser, parseUserEntry) }
+
+// IterGroups returns an iterator over the lines of the groups file.
+func IterGroups() (*Iter, error) { return defaultDB.IterGroups() }
+
+// IterGroups returns an iterator over the lines of the groups file.
+func (db *DB) IterGroups() (*Iter, error) { return db.newIter(fileGroup, parseGroupEntry) }
+
+// IterGroups returns an iterator over the lines staged of the groups file.
+func (tx *Tx) IterGroups() (*Iter, error) { return tx.newIter(fileGroup, parseGroupEntry) }
+
+// IterShadows returns an iterator over the lines of the shadowed users file.
+func IterShadows() (*Iter, error) { return defaultDB.IterShadows() }
+
+// IterShadows returns an iterator over the lines of the shadowed users file.
+func (db *DB) IterShadows() (*Iter, error) { return db.newIter(fileShadow, parseShadowEntry) }
+
+// IterShadows returns an iterator over the lines staged of the shadowed users
+// file.
+func (tx *Tx) IterShadows() (*Iter, error) { return tx.newIter(fileShadow, parseShadowEntry) }
+
+// IterGShadows returns an iterator over the lines of the shadowed groups file.
+func IterGShadows() (*Iter, error) { return defaultDB.IterGShadows() }
+
+// IterGShadows returns an iterator over the lines of the shadowed groups file.
+func (db *DB) IterGShadows() (*Iter, error) { return db.newIter(fileGShadow, parseGShadowEntry) }
+
+// IterGShadows returns an iterator over the lines staged of the shadowed groups
+// file.
+func (tx *Tx) IterGShadows() (*Iter, error) { return tx.newIter(fileGShadow, parseGShadowEntry) }
+
+// == All entries
+//
+
+// AllUsers returns all users, in the order they are stored.
+func AllUsers() ([]*User, error) { return defaultDB.AllUsers() }
+
+// AllUsers returns all users, in the order they are stored.
+func (db *DB) AllUsers() ([]*User, error) { return allUsers(db.IterUsers()) }
+
+// AllUsers returns all users staged, in the order they are stored.
+func (tx *Tx) AllUsers() ([]*User, error) { return allUsers(tx.IterUsers()) }
+
+func allUsers(it *Iter, err error) ([]*User, error) {
+	if err != nil {
+		return nil, err
+	}
+	iEntries, err := it.entries()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]*User, len(iEntries))
+	for i, v := range iEntries {
+		entries[i] = v.(*User)
+	}
+	return entries, nil
+}
+
+// AllGroups returns all groups, in the order they are stored.
+func AllGroups() ([]*Group, error) { return defaultDB.AllGroups() }
+
+// AllGroups returns all groups, in the order they are stored.
+func (db *DB) AllGroups() ([]*Group, error) { return allGroups(db.IterGroups()) }
+
+// AllGroups returns all groups staged, in the order they are stored.
+func (tx *Tx) AllGroups() ([]*Group, error) { return allGroups(tx.IterGroups()) }
+
+func allGroups(it *Iter, err error) ([]*Group, error) {
+	if err != nil {
+		return nil, err
+	}
+	iEntries, err := it.entries()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]*Group, len(iEntries))
+	for i, v := range iEntries {
+		entries[i] = v.(*Group)
+	}
+	return entries, nil
+}
+
+// AllShadows returns all shadowed users, in the order they are stored.
+func AllShadows() ([]*Shadow, error) { return defaultDB.AllShadows() }
+
+// AllShadows returns all shadowed users, in the order they are stored.
+func (db *DB) AllShadows() ([]*Shadow, error) { return allShadows(db.IterShadows()) }
+
+// AllShadows returns all shadowed users staged, in the order they are stored.
+func (tx *Tx) AllShadows() ([]*Shadow, error) { return allShadows(tx.IterShadows()) }
+
+func allShadows(it *Iter, err error) ([]*Shadow, error) {
+	if err != nil {
+		return nil, err
+	}
+	iEntries, err := it.entries()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]*Shadow, len(iEntries))
+	for i, v := range iEntries {
+		entries[i] = v.(*Shadow)
+	}
+	return entries, nil
+}
+
+// AllGShadows returns all shadowed groups, in the order they are stored.
+func AllGShadows() ([]*GShadow, error) { return defaultDB.AllGShadows() }
+
+// AllGShadows returns all shadowed groups, in the order they are stored.
+func (db *DB) AllGShadows() ([]*GShadow, error) { return allGShadows(db.IterGShadows()) }
+
+// AllGShadows returns all shadowed groups staged, in the order they are stored.
+func (tx *Tx) AllGShadows() ([]*GShadow, error) { return allGShadows(tx.IterGShadows()) }
+
+func allGShadows(it *Iter, err error) ([]*GShadow, error) {
+	if err != nil {
+		return nil, err
+	}
+	iEntries, err := it.entries()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]*GShadow, len(iEntries))
+	for i, v := range iEntries {
+		entries[i] = v.(*GShadow)
+	}
+	return entries, nil
+}
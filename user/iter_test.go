@@ -0,0 +1,121 @@
+// Copyright 2021 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package user
+
+import "testing"
+
+func TestIter(t *testing.T) {
+	db := newTestDB(t, map[string]string{
+		fileLogin: "ENCRYPT_METHOD SHA512\n",
+		fileUser: "# local accounts\n" +
+			"root:x:0:0:root:/root:/bin/sh\n" +
+			"\n" +
+			"u1:x:1000:1000::/home/u1:/bin/sh\n" +
+			"+@netgroup::::::\n" +
+			"u2:x:1001:1000::/home/u2:/bin/sh",
+		fileGroup:   "root:x:0:\ng1:x:1000:u1,u2\n",
+		fileShadow:  "root:*:18000:0:99999:7:::\nu1:*:18000:0:99999:7:::\nu2:*:18000:0:99999:7:::\n",
+		fileGShadow: "root:!::\ng1:!::u1,u2\n",
+	})
+
+	it, err := db.IterUsers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.Close()
+
+	expected := []struct {
+		comment bool
+		text    string
+		name    string
+	}{
+		{true, "# local accounts", ""},
+		{false, "root:x:0:0:root:/root:/bin/sh", "root"},
+		{true, "", ""},
+		{false, "u1:x:1000:1000::/home/u1:/bin/sh", "u1"},
+		{true, "+@netgroup::::::", ""},
+		{false, "u2:x:1001:1000::/home/u2:/bin/sh", "u2"},
+	}
+	i := 0
+	for ; it.Next(); i++ {
+		if i == len(expected) {
+			t.Fatalf("unexpected line %d: %q", it.Line(), it.Text())
+		}
+		e := expected[i]
+
+		if it.Line() != i+1 {
+			t.Errorf("expected line %d, got %d", i+1, it.Line())
+		}
+		if it.Text() != e.text {
+			t.Errorf("line %d: expected %q, got %q", i+1, e.text, it.Text())
+		}
+		if it.IsComment() != e.comment {
+			t.Errorf("line %d: expected comment %v", i+1, e.comment)
+		}
+		if u := it.User(); u != nil && u.Name != e.name {
+			t.Errorf("line %d: expected user %q, got %q", i+1, e.name, u.Name)
+		}
+		if it.Group() != nil {
+			t.Errorf("line %d: expected no group", i+1)
+		}
+	}
+	if err = it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if i != len(expected) {
+		t.Errorf("expected %d lines, got %d", len(expected), i)
+	}
+
+	users, err := db.AllUsers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 3 || users[0].Name != "root" || users[2].Name != "u2" {
+		t.Errorf("unexpected users: %v", users)
+	}
+	if groups, err := db.AllGroups(); err != nil {
+		t.Error(err)
+	} else if len(groups) != 2 || groups[1].Name != "g1" {
+		t.Errorf("unexpected groups: %v", groups)
+	}
+	if shadows, err := db.AllShadows(); err != nil {
+		t.Error(err)
+	} else if len(shadows) != 3 {
+		t.Errorf("unexpected shadowed users: %v", shadows)
+	}
+	if gshadows, err := db.AllGShadows(); err != nil {
+		t.Error(err)
+	} else if len(gshadows) != 2 {
+		t.Errorf("unexpected shadowed groups: %v", gshadows)
+	}
+
+	// Staged changes
+	err = db.update(func(tx *Tx) error {
+		if _, err := tx.AddGroup("g2"); err != nil {
+			return err
+		}
+		groups, err := tx.AllGroups()
+		if err != nil {
+			return err
+		}
+		if len(groups) != 3 || groups[2].Name != "g2" {
+			t.Errorf("expected the group staged, got %v", groups)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Malformed entry
+	db = newTestDB(t, map[string]string{
+		fileUser: "root:x:0:0:root:/root:/bin/sh\nbad:x:abc:0::/:/bin/sh\n",
+	})
+	if _, err = db.AllUsers(); err == nil {
+		t.Error("expected error by malformed entry")
+	}
+}
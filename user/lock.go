@@ -0,0 +1,265 @@
+// Copyright 2021 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package user
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// The locking is compatible with the one used by the tools of shadow-utils
+// (useradd, vipw, ...) and by "lckpwdf(3)":
+//
+//   + '/etc/.pwd.lock' is locked with "fcntl(2)" during the whole operation.
+//   + Every file to modify is locked creating '{name}.lock', which stores the
+//     PID of the process that owns the lock.
+
+// filePwdLock is the file locked by "lckpwdf(3)".
+const filePwdLock = "/etc/.pwd.lock"
+
+// lockTimeout is the time to wait for getting the locks, like "lckpwdf(3)".
+var lockTimeout = 15 * time.Second
+
+// lockSem serializes the operations of edition into the actual process, since
+// the locks of files are only useful between different processes. It is held
+// from the beginning of a transaction until it is finished, so a method of a DB
+// which edits the database cannot be called while a transaction is open; it
+// would wait until the time of lockTimeout, returning ErrLocked.
+var lockSem = make(chan struct{}, 1)
+
+// ErrLocked is returned when the files of the database are locked by another
+// process or by a transaction not finished of the actual one, after of waiting
+// for them.
+var ErrLocked = errors.New("the database is locked")
+
+// A dbLock represents the locks got to edit files of a database.
+type dbLock struct {
+	pwd   *os.File
+	files []string // Lock files created.
+}
+
+// lock gets the locks to edit the given files of the database.
+// It has to be released using unlock.
+func (db *DB) lock(names ...string) (_ *dbLock, err error) {
+	deadline := time.Now().Add(lockTimeout)
+
+	timer := time.NewTimer(lockTimeout)
+	select {
+	case lockSem <- struct{}{}:
+		timer.Stop()
+	case <-timer.C:
+		return nil, ErrLocked
+	}
+
+	lk := &dbLock{}
+	ok := false
+	// The locks got are released at failing, even by a panic.
+	defer func() {
+		if !ok {
+			lk.unlock()
+		}
+	}()
+
+	if err = lk.lockPwd(db.path(filePwdLock), deadline); err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		if err = lk.lockFile(db.path(name), deadline); err != nil {
+			return nil, err
+		}
+	}
+	ok = true
+	return lk, nil
+}
+
+// lockPwd locks the file used by "lckpwdf(3)".
+func (lk *dbLock) lockPwd(filename string, deadline time.Time) error {
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+
+	flock := &syscall.Flock_t{Type: syscall.F_WRLCK, Whence: io.SeekStart}
+	for {
+		err = syscall.FcntlFlock(f.Fd(), syscall.F_SETLK, flock)
+		if err == nil {
+			lk.pwd = f
+			return nil
+		}
+		if err != syscall.EAGAIN && err != syscall.EACCES {
+			f.Close()
+			return err
+		}
+		if time.Now().After(deadline) {
+			f.Close()
+			return ErrLocked
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// lockFile creates the lock file for the named file, linking it from a file
+// with the PID of the process, just like it is done in shadow-utils.
+func (lk *dbLock) lockFile(filename string, deadline time.Time) error {
+	lockName := filename + ".lock"
+	pidName := filename + "." + strconv.Itoa(os.Getpid())
+
+	err := ioutil.WriteFile(pidName, []byte(strconv.Itoa(os.Getpid())), 0600)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(pidName)
+
+	for {
+		err = os.Link(pidName, lockName)
+		if err == nil {
+			lk.files = append(lk.files, lockName)
+			return nil
+		}
+		if !os.IsExist(err) {
+			return err
+		}
+
+		// Remove the lock whether its process is not running.
+		if isStaleLock(lockName) {
+			if err = os.Remove(lockName); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			continue
+		}
+		if time.Now().After(deadline) {
+			return ErrLocked
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// isStaleLock checks whether the process that created the lock file is not
+// running.
+func isStaleLock(lockName string) bool {
+	b, err := ioutil.ReadFile(lockName)
+	if err != nil {
+		return false
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil || pid <= 0 {
+		return true
+	}
+	return syscall.Kill(pid, 0) == syscall.ESRCH
+}
+
+// unlock releases the locks.
+func (lk *dbLock) unlock() (err error) {
+	defer func() { <-lockSem }()
+
+	for i := len(lk.files) - 1; i >= 0; i-- {
+		if e := os.Remove(lk.files[i]); e != nil && err == nil {
+			err = e
+		}
+	}
+	if lk.pwd != nil {
+		// Closing the file releases the lock of fcntl.
+		if e := lk.pwd.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return
+}
+
+// writeFile replaces the named file by other one with the data, so a crash
+// cannot leave the file half written.
+func writeFile(filename string, data []byte) error {
+	tmpName, err := writeTemp(filename, data)
+	if err != nil {
+		return err
+	}
+	if err = os.Rename(tmpName, filename); err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+
+	return syncDir(filepath.Dir(filename))
+}
+
+// writeTemp writes the data into '{name}+' (like shadow-utils), synced to disk,
+// to replace later the named file through a rename.
+// The mode and the owner of the original file are preserved.
+func writeTemp(filename string, data []byte) (tmpName string, err error) {
+	var perm os.FileMode = 0644
+	uid, gid := -1, -1
+
+	info, err := os.Stat(filename)
+	if err == nil {
+		perm = info.Mode().Perm()
+		uid, gid = fileOwner(info)
+	} else if !os.IsNotExist(err) {
+		return "", err
+	}
+
+	name := filename + "+"
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(name)
+		}
+	}()
+
+	// The permissions could have been masked by umask at creating the file.
+	if err = f.Chmod(perm); err != nil {
+		return "", err
+	}
+	if uid != -1 {
+		if err = f.Chown(uid, gid); err != nil {
+			return "", err
+		}
+	}
+
+	if _, err = f.Write(data); err != nil {
+		return "", err
+	}
+	if err = f.Sync(); err != nil {
+		return "", err
+	}
+	if err = f.Close(); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// fileOwner returns the user and group ids that own a file, or -1 whether they
+// cannot be got.
+func fileOwner(info os.FileInfo) (uid, gid int) {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return int(st.Uid), int(st.Gid)
+	}
+	return -1, -1
+}
+
+// syncDir commits to disk the entries of a directory, so a file renamed is not
+// lost after a crash.
+func syncDir(name string) error {
+	d, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if e := d.Close(); e != nil && err == nil {
+		err = e
+	}
+	return err
+}
@@ -0,0 +1,72 @@
+// Copyright 2021 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package user
+
+import (
+	"io/ioutil"
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestLock(t *testing.T) {
+	lockName := testDB.path(fileUser) + ".lock"
+
+	// A lock of a process that is not running has to be removed.
+	if err := ioutil.WriteFile(lockName, []byte("999999999"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	lk, err := testDB.lock(fileUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(lockName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pid := string(b); pid != strconv.Itoa(os.Getpid()) {
+		t.Errorf("lock file: expected PID %d, got %s", os.Getpid(), pid)
+	}
+
+	if err = lk.unlock(); err != nil {
+		t.Fatal(err)
+	}
+	if found, _ := exist(lockName); found {
+		t.Error("expected to remove the lock file")
+	}
+}
+
+func TestWriteFile(t *testing.T) {
+	filename := testDB.path("/etc/test-write")
+	if err := ioutil.WriteFile(filename, []byte("foo\n"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(filename)
+
+	if err := writeFile(filename, []byte("bar\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "bar\n" {
+		t.Errorf("expected to get the new content, got %q", b)
+	}
+	info, err := os.Stat(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0640 {
+		t.Errorf("expected to preserve the mode 0640, got %o", info.Mode().Perm())
+	}
+	if found, _ := exist(filename + "+"); found {
+		t.Error("expected to rename the temporary file")
+	}
+}
@@ -0,0 +1,211 @@
+// Copyright 2021 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// Group membership
+
+package user
+
+import "os"
+
+// A Member represents an user which belongs to a group, with the ways in that
+// it belongs.
+type Member struct {
+	Name string
+
+	Primary bool // The group is the primary one of the user.
+	Listed  bool // Listed like member in the group or gshadow files.
+	Admin   bool // Listed like administrator in the gshadow file.
+}
+
+// IsMember reports whether the user gets the privileges of the group, what is
+// not done by being only its administrator.
+func (m *Member) IsMember() bool { return m.Primary || m.Listed }
+
+// GroupsOfUser returns the groups of an user, like "id(1)": the primary group
+// at first, and then the ones where it is listed like member, in the order they
+// are stored.
+func GroupsOfUser(name string) ([]*Group, error) { return defaultDB.GroupsOfUser(name) }
+
+// GroupsOfUser returns the groups of an user, like "id(1)": the primary group
+// at first, and then the ones where it is listed like member, in the order they
+// are stored.
+func (db *DB) GroupsOfUser(name string) ([]*Group, error) {
+	m, err := db.membership()
+	if err != nil {
+		return nil, err
+	}
+	return m.groupsOfUser(name)
+}
+
+// GroupsOfUser returns the groups of an user, into the changes staged.
+func (tx *Tx) GroupsOfUser(name string) ([]*Group, error) {
+	m, err := tx.membership()
+	if err != nil {
+		return nil, err
+	}
+	return m.groupsOfUser(name)
+}
+
+// MembersOfGroup returns the users which belong to a group, including the ones
+// whose primary group it is and its administrators.
+// The members listed are at first, then the users of the primary group, and at
+// last the administrators which are not members.
+func MembersOfGroup(name string) ([]*Member, error) { return defaultDB.MembersOfGroup(name) }
+
+// MembersOfGroup returns the users which belong to a group, including the ones
+// whose primary group it is and its administrators.
+// The members listed are at first, then the users of the primary group, and at
+// last the administrators which are not members.
+func (db *DB) MembersOfGroup(name string) ([]*Member, error) {
+	m, err := db.membership()
+	if err != nil {
+		return nil, err
+	}
+	return m.membersOfGroup(name)
+}
+
+// MembersOfGroup returns the users which belong to a group, into the changes
+// staged.
+func (tx *Tx) MembersOfGroup(name string) ([]*Member, error) {
+	m, err := tx.membership()
+	if err != nil {
+		return nil, err
+	}
+	return m.membersOfGroup(name)
+}
+
+// * * *
+
+// membership represents the entries needed to resolve the membership.
+type membership struct {
+	db       *DB
+	users    []*User
+	groups   []*Group
+	gshadows []*GShadow // Nil whether the gshadow file does not exist.
+}
+
+func (db *DB) membership() (*membership, error) {
+	users, err := db.AllUsers()
+	if err != nil {
+		return nil, err
+	}
+	groups, err := db.AllGroups()
+	if err != nil {
+		return nil, err
+	}
+	gshadows, err := db.AllGShadows()
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return &membership{db, users, groups, gshadows}, nil
+}
+
+func (tx *Tx) membership() (*membership, error) {
+	users, err := tx.AllUsers()
+	if err != nil {
+		return nil, err
+	}
+	groups, err := tx.AllGroups()
+	if err != nil {
+		return nil, err
+	}
+	gshadows, err := tx.AllGShadows()
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return &membership{tx.db, users, groups, gshadows}, nil
+}
+
+func (m *membership) groupsOfUser(name string) ([]*Group, error) {
+	var user *User
+	for _, u := range m.users {
+		if u.Name == name {
+			user = u
+			break
+		}
+	}
+	if user == nil {
+		return nil, NoFoundError{m.db.path(fileUser), "Name", name}
+	}
+
+	// Members listed only in the gshadow file.
+	listed := make(map[string]bool)
+	for _, gs := range m.gshadows {
+		if checkGroup(gs.UserList, name) {
+			listed[gs.Name] = true
+		}
+	}
+
+	groups := make([]*Group, 0)
+	for _, g := range m.groups {
+		if g.GID == user.GID {
+			groups = append([]*Group{g}, groups...)
+		} else if checkGroup(g.UserList, name) || listed[g.Name] {
+			groups = append(groups, g)
+		}
+	}
+	return groups, nil
+}
+
+func (m *membership) membersOfGroup(name string) ([]*Member, error) {
+	var group *Group
+	for _, g := range m.groups {
+		if g.Name == name {
+			group = g
+			break
+		}
+	}
+	if group == nil {
+		return nil, NoFoundError{m.db.path(fileGroup), "Name", name}
+	}
+
+	members := make([]*Member, 0)
+	memberOf := make(map[string]*Member)
+	add := func(name string) *Member {
+		if mb, ok := memberOf[name]; ok {
+			return mb
+		}
+		mb := &Member{Name: name}
+		memberOf[name] = mb
+		members = append(members, mb)
+		return mb
+	}
+
+	for _, v := range group.UserList {
+		if v != "" {
+			add(v).Listed = true
+		}
+	}
+	var gshadow *GShadow
+	for _, gs := range m.gshadows {
+		if gs.Name == name {
+			gshadow = gs
+			break
+		}
+	}
+	if gshadow != nil {
+		for _, v := range gshadow.UserList {
+			if v != "" {
+				add(v).Listed = true
+			}
+		}
+	}
+
+	for _, u := range m.users {
+		if u.GID == group.GID {
+			add(u.Name).Primary = true
+		}
+	}
+
+	if gshadow != nil {
+		for _, v := range gshadow.AdminList {
+			if v != "" {
+				add(v).Admin = true
+			}
+		}
+	}
+	return members, nil
+}
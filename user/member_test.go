@@ -0,0 +1,100 @@
+// Copyright 2021 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package user
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMembership(t *testing.T) {
+	db := newTestDB(t, map[string]string{
+		fileUser: "root:x:0:0:root:/root:/bin/sh\n" +
+			"u1:x:1000:1000::/home/u1:/bin/sh\n" +
+			"u2:x:1001:1000::/home/u2:/bin/sh\n" +
+			"u3:x:1002:1002::/home/u3:/bin/sh\n",
+		fileGroup: "root:x:0:\n" +
+			"staff:x:1000:u3\n" +
+			"wheel:x:10:u1\n" +
+			"dev:x:1001:\n" +
+			"u3:x:1002:\n",
+		fileGShadow: "root:*::\n" +
+			"staff:!:root:u3\n" +
+			"wheel:!::u1\n" +
+			"dev:!:u3:u2\n" +
+			"u3:!::\n",
+	})
+
+	for name, expected := range map[string][]string{
+		"u1":   {"staff", "wheel"},
+		"u2":   {"staff", "dev"},
+		"u3":   {"u3", "staff"},
+		"root": {"root"},
+	} {
+		groups, err := db.GroupsOfUser(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := make([]string, len(groups))
+		for i, g := range groups {
+			got[i] = g.Name
+		}
+		if !reflect.DeepEqual(got, expected) {
+			t.Errorf("%s: expected groups %v, got %v", name, expected, got)
+		}
+	}
+	if _, err := db.GroupsOfUser("nobody"); err == nil {
+		t.Error("expected error")
+	} else if _, ok := err.(NoFoundError); !ok {
+		t.Errorf("expected NoFoundError, got %v", err)
+	}
+
+	for name, expected := range map[string][]Member{
+		"staff": {
+			{Name: "u3", Listed: true},
+			{Name: "u1", Primary: true},
+			{Name: "u2", Primary: true},
+			{Name: "root", Admin: true},
+		},
+		"dev": {
+			{Name: "u2", Listed: true},
+			{Name: "u3", Admin: true},
+		},
+		"u3": {
+			{Name: "u3", Primary: true},
+		},
+	} {
+		members, err := db.MembersOfGroup(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := make([]Member, len(members))
+		for i, m := range members {
+			got[i] = *m
+		}
+		if !reflect.DeepEqual(got, expected) {
+			t.Errorf("%s: expected members %+v, got %+v", name, expected, got)
+		}
+	}
+	if members, _ := db.MembersOfGroup("dev"); members[1].IsMember() {
+		t.Error("expected an administrator not to be member")
+	}
+	if _, err := db.MembersOfGroup("nogroup"); err == nil {
+		t.Error("expected error")
+	}
+
+	// Without gshadow file
+	db = newTestDB(t, map[string]string{
+		fileUser:  "u1:x:1000:1000::/home/u1:/bin/sh\n",
+		fileGroup: "staff:x:1000:\n",
+	})
+	if members, err := db.MembersOfGroup("staff"); err != nil {
+		t.Error(err)
+	} else if len(members) != 1 || !members[0].Primary {
+		t.Errorf("unexpected members: %v", members)
+	}
+}
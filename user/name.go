@@ -0,0 +1,101 @@
+// Copyright 2021 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package user
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// DefaultNameRegex is the regular expression used by shadow-utils to check the
+// names of users and groups.
+const DefaultNameRegex = `^[a-z_][a-z0-9_-]*[$]?$`
+
+// NameMaxLen is the maximum length of a name of user or group, by the size of
+// the field of user name into the utmp records.
+const NameMaxLen = 32
+
+// An InvalidNameError reports a name of user or group which is not valid.
+type InvalidNameError struct {
+	name   string
+	reason string
+}
+
+func (e InvalidNameError) Error() string {
+	return fmt.Sprintf("name not valid: %q: %s", e.name, e.reason)
+}
+
+// ValidateName checks whether the name is valid for an user or group, by the
+// policy of the system.
+func ValidateName(name string) error { return defaultDB.ValidateName(name) }
+
+// ValidateName checks whether the name is valid for an user or group, by the
+// policy of the database.
+func (db *DB) ValidateName(name string) error {
+	if err := db.initConfig(); err != nil {
+		return err
+	}
+	return db.config.policy.ValidateName(name)
+}
+
+// ValidateName checks whether the name is valid for an user or group.
+//
+// The name has to be of the POSIX portable filename character set
+// ("A-Z", "a-z", "0-9", ".", "_" and "-"), plus a final "$" used by the
+// machine accounts of Samba; it cannot start with "-", be fully numeric,
+// nor be "." or "..". Besides, it has to be at most NameMaxLen characters
+// long, and it has to match the NameRegex.
+func (p *Policy) ValidateName(name string) error {
+	if name == "" {
+		return RequiredError("Name")
+	}
+
+	maxLen := p.NameMaxLen
+	if maxLen <= 0 {
+		maxLen = NameMaxLen
+	}
+	if len(name) > maxLen {
+		return InvalidNameError{name, fmt.Sprintf("longer than %d characters", maxLen)}
+	}
+	if name == "." || name == ".." {
+		return InvalidNameError{name, "reserved"}
+	}
+	if name[0] == '-' {
+		return InvalidNameError{name, "it starts with a dash"}
+	}
+
+	numeric := true
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+
+		switch {
+		case c >= '0' && c <= '9':
+			continue
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '.', c == '_', c == '-':
+		case c == '$' && i == len(name)-1:
+		default:
+			return InvalidNameError{name, fmt.Sprintf("character not valid: %q", c)}
+		}
+		numeric = false
+	}
+	if numeric {
+		return InvalidNameError{name, "fully numeric"}
+	}
+
+	expr := p.NameRegex
+	if expr == "" {
+		expr = DefaultNameRegex
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return err
+	}
+	if !re.MatchString(name) {
+		return InvalidNameError{name, "it does not match " + expr}
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+// Copyright 2021 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package user
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateName(t *testing.T) {
+	p := &Policy{}
+
+	for _, name := range []string{
+		"root", "_apt", "systemd-network", "u1", "host$", strings.Repeat("a", 32),
+	} {
+		if err := p.ValidateName(name); err != nil {
+			t.Errorf("%q: %s", name, err)
+		}
+	}
+
+	for _, name := range []string{
+		"bob:x", "bob\nroot:x:0:0::/:/bin/sh", "-bob", "Bob", "123", ".", "..",
+		"bo b", "bo$b", "bób", strings.Repeat("a", 33),
+	} {
+		if _, ok := p.ValidateName(name).(InvalidNameError); !ok {
+			t.Errorf("%q: expected InvalidNameError", name)
+		}
+	}
+	if _, ok := p.ValidateName("").(RequiredError); !ok {
+		t.Error("expected RequiredError")
+	}
+
+	p = &Policy{NameRegex: `^[a-zA-Z][a-zA-Z0-9.]*$`, NameMaxLen: 8}
+	if err := p.ValidateName("John.Doe"); err != nil {
+		t.Error(err)
+	}
+	for _, name := range []string{"john_doe", "john.doe1"} {
+		if _, ok := p.ValidateName(name).(InvalidNameError); !ok {
+			t.Errorf("%q: expected InvalidNameError", name)
+		}
+	}
+}
+
+func TestNameRegex(t *testing.T) {
+	db := newTestDB(t, map[string]string{
+		fileLogin:   "ENCRYPT_METHOD SHA512\nGID_MIN 1000\nGID_MAX 60000\n",
+		fileAdduser: "NAME_REGEX=\"^[a-z][-a-z0-9_.]*$\"\n",
+		fileUser:    "root:x:0:0:root:/root:/bin/sh\n",
+		fileGroup:   "root:x:0:\n",
+		fileShadow:  "root:*:18000:0:99999:7:::\n",
+		fileGShadow: "root:!::\n",
+	})
+
+	if err := db.ValidateName("john.doe"); err != nil {
+		t.Error(err)
+	}
+	if _, ok := db.ValidateName("_apt").(InvalidNameError); !ok {
+		t.Error("expected InvalidNameError by NAME_REGEX")
+	}
+
+	// All the paths to add and rename
+	if _, err := db.AddGroup("bad:name"); err == nil {
+		t.Error("expected error at adding group")
+	} else if _, ok := err.(InvalidNameError); !ok {
+		t.Errorf("expected InvalidNameError, got %v", err)
+	}
+	if _, err := db.AddUser("Bad", 0); err == nil {
+		t.Error("expected error at adding user")
+	} else if _, ok := err.(InvalidNameError); !ok {
+		t.Errorf("expected InvalidNameError, got %v", err)
+	}
+
+	if _, err := db.AddGroup("staff"); err != nil {
+		t.Fatal(err)
+	}
+	name := "-staff"
+	if err := db.ModGroup("staff", &GroupMod{Name: &name}); err == nil {
+		t.Error("expected error at renaming group")
+	} else if _, ok := err.(InvalidNameError); !ok {
+		t.Errorf("expected InvalidNameError, got %v", err)
+	}
+	if err := db.ModUser("root", &UserMod{Name: &name}); err == nil {
+		t.Error("expected error at renaming user")
+	} else if _, ok := err.(InvalidNameError); !ok {
+		t.Errorf("expected InvalidNameError, got %v", err)
+	}
+}
@@ -0,0 +1,334 @@
+// Copyright 2021 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// Name Service Switch
+
+package user
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+const fileNSSwitch = "/etc/nsswitch.conf"
+
+// A Backend looks up the users and groups from a source of the Name Service
+// Switch.
+//
+// A DB is the backend of the local files.
+type Backend interface {
+	LookupUser(name string) (*User, error)
+	LookupUID(uid int) (*User, error)
+	LookupGroup(name string) (*Group, error)
+	LookupGID(gid int) (*Group, error)
+}
+
+var _ Backend = (*DB)(nil)
+
+// == Getent
+//
+
+// A Getent is the backend which delegates the lookups in a service of the
+// Name Service Switch of the running system (like "sss", "ldap" or "systemd")
+// to the command "getent(1)".
+//
+// It always queries the host where it runs, whatever the root of a DB.
+type Getent struct {
+	// Service is the name of the service to query; if it is empty, there are
+	// used all the ones configured into the system.
+	Service string
+
+	// Command is the path of the command; "getent" by default.
+	Command string
+}
+
+// LookupUser looks up an user by name.
+func (g *Getent) LookupUser(name string) (*User, error) {
+	line, err := g.run("passwd", name, "Name")
+	if err != nil {
+		return nil, err
+	}
+	return parseUser(line)
+}
+
+// LookupUID looks up an user by user ID.
+func (g *Getent) LookupUID(uid int) (*User, error) {
+	line, err := g.run("passwd", strconv.Itoa(uid), "UID")
+	if err != nil {
+		return nil, err
+	}
+	return parseUser(line)
+}
+
+// LookupGroup looks up a group by name.
+func (g *Getent) LookupGroup(name string) (*Group, error) {
+	line, err := g.run("group", name, "Name")
+	if err != nil {
+		return nil, err
+	}
+	return parseGroup(line)
+}
+
+// LookupGID looks up a group by group ID.
+func (g *Getent) LookupGID(gid int) (*Group, error) {
+	line, err := g.run("group", strconv.Itoa(gid), "GID")
+	if err != nil {
+		return nil, err
+	}
+	return parseGroup(line)
+}
+
+// run returns the entry got from the database for the key.
+// The exit status 2 of getent means that the key was not found.
+func (g *Getent) run(database, key, field string) (string, error) {
+	cmd := g.Command
+	if cmd == "" {
+		cmd = "getent"
+	}
+	args := make([]string, 0, 5)
+	if g.Service != "" {
+		args = append(args, "-s", g.Service)
+	}
+	// The key could start with a dash, like "-s".
+	args = append(args, database, "--", key)
+
+	out, err := exec.Command(cmd, args...).Output()
+	if err != nil {
+		if e, ok := err.(*exec.ExitError); ok && e.ExitCode() == 2 {
+			return "", NoFoundError{"getent " + database, field, key}
+		}
+		return "", err
+	}
+
+	line := string(bytes.TrimSpace(out))
+	if i := strings.IndexByte(line, '\n'); i != -1 {
+		line = line[:i]
+	}
+	return line, nil
+}
+
+// == Resolver
+//
+
+// A Resolver looks up the users and groups into the sources configured for the
+// databases "passwd" and "group" in the Name Service Switch, like "id(1)" does
+// in the hosts joined to a directory service.
+//
+// The services "files" and "compat" are resolved by the DB, and the rest ones by
+// a Getent, unless a backend is set for them. Since a Getent queries the running
+// system, it is not used whether the root of the DB is not "/"; then those
+// services are not available, unless a backend is set for them.
+type Resolver struct {
+	db       *DB
+	passwd   []nssSource
+	group    []nssSource
+	backends map[string]Backend
+}
+
+// A nssSource represents a service of a database in the Name Service Switch.
+type nssSource struct {
+	service string
+	// The lookup finishes when the entry is not found, by "[NOTFOUND=return]".
+	notFoundReturn bool
+}
+
+// NewResolver returns a resolver configured by the file "/etc/nsswitch.conf".
+func NewResolver() (*Resolver, error) { return defaultDB.NewResolver() }
+
+// NewResolver returns a resolver configured by the file "/etc/nsswitch.conf" of
+// the database. Whether that file does not exist, there is only used the DB.
+func (db *DB) NewResolver() (*Resolver, error) {
+	r := &Resolver{
+		db:       db,
+		backends: make(map[string]Backend),
+	}
+
+	f, err := os.Open(db.path(fileNSSwitch))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+	} else {
+		defer f.Close()
+
+		sc := bufio.NewScanner(f)
+		for sc.Scan() {
+			line := sc.Text()
+			if i := strings.IndexByte(line, '#'); i != -1 {
+				line = line[:i]
+			}
+			i := strings.IndexByte(line, ':')
+			if i == -1 {
+				continue
+			}
+
+			switch strings.TrimSpace(line[:i]) {
+			case "passwd":
+				r.passwd = parseNSSources(line[i+1:])
+			case "group":
+				r.group = parseNSSources(line[i+1:])
+			}
+		}
+		if err = sc.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(r.passwd) == 0 {
+		r.passwd = []nssSource{{service: "files"}}
+	}
+	if len(r.group) == 0 {
+		r.group = []nssSource{{service: "files"}}
+	}
+	return r, nil
+}
+
+// parseNSSources parses the services of a database, like
+// "files sss [NOTFOUND=return] systemd".
+func parseNSSources(s string) []nssSource {
+	sources := make([]nssSource, 0, 4)
+
+	for _, v := range strings.Fields(strings.Replace(s, "[", " [", -1)) {
+		if v[0] != '[' {
+			sources = append(sources, nssSource{service: v})
+			continue
+		}
+		if len(sources) == 0 {
+			continue
+		}
+		action := strings.ToUpper(strings.Trim(v, "[]"))
+		if action == "NOTFOUND=RETURN" {
+			sources[len(sources)-1].notFoundReturn = true
+		}
+	}
+	return sources
+}
+
+// Services returns the services configured for the database, "passwd" or
+// "group".
+func (r *Resolver) Services(database string) []string {
+	var sources []nssSource
+	switch database {
+	case "passwd":
+		sources = r.passwd
+	case "group":
+		sources = r.group
+	}
+
+	services := make([]string, len(sources))
+	for i, v := range sources {
+		services[i] = v.service
+	}
+	return services
+}
+
+// SetBackend sets the backend to use for the service.
+func (r *Resolver) SetBackend(service string, b Backend) { r.backends[service] = b }
+
+// backend returns the backend of the service.
+func (r *Resolver) backend(service string) Backend {
+	if b, ok := r.backends[service]; ok {
+		return b
+	}
+	switch service {
+	case "files", "compat":
+		return r.db
+	}
+	if r.db.root != "/" {
+		return unavailBackend(service)
+	}
+	return &Getent{Service: service}
+}
+
+// An unavailBackend is the backend of a service which cannot be queried.
+type unavailBackend string
+
+func (b unavailBackend) err() error {
+	return fmt.Errorf("service %q is not available out of the root directory \"/\"", string(b))
+}
+
+func (b unavailBackend) LookupUser(string) (*User, error)   { return nil, b.err() }
+func (b unavailBackend) LookupUID(int) (*User, error)       { return nil, b.err() }
+func (b unavailBackend) LookupGroup(string) (*Group, error) { return nil, b.err() }
+func (b unavailBackend) LookupGID(int) (*Group, error)      { return nil, b.err() }
+
+// lookUp calls fn with the backend of every source, until an entry is found.
+//
+// The sources which are not available are skipped, like the NSS does; their
+// error is only returned when no source reported that the entry was not found.
+func (r *Resolver) lookUp(sources []nssSource, fn func(Backend) (interface{}, error)) (interface{}, error) {
+	var errNoFound, errOther error
+
+	for _, src := range sources {
+		entry, err := fn(r.backend(src.service))
+		if err == nil {
+			return entry, nil
+		}
+
+		if _, ok := err.(NoFoundError); ok {
+			errNoFound = err
+			if src.notFoundReturn {
+				break
+			}
+		} else if errOther == nil {
+			errOther = err
+		}
+	}
+
+	if errNoFound != nil {
+		return nil, errNoFound
+	}
+	return nil, errOther
+}
+
+// LookupUser looks up an user by name.
+func (r *Resolver) LookupUser(name string) (*User, error) {
+	entry, err := r.lookUp(r.passwd, func(b Backend) (interface{}, error) {
+		return b.LookupUser(name)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entry.(*User), nil
+}
+
+// LookupUID looks up an user by user ID.
+func (r *Resolver) LookupUID(uid int) (*User, error) {
+	entry, err := r.lookUp(r.passwd, func(b Backend) (interface{}, error) {
+		return b.LookupUID(uid)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entry.(*User), nil
+}
+
+// LookupGroup looks up a group by name.
+func (r *Resolver) LookupGroup(name string) (*Group, error) {
+	entry, err := r.lookUp(r.group, func(b Backend) (interface{}, error) {
+		return b.LookupGroup(name)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entry.(*Group), nil
+}
+
+// LookupGID looks up a group by group ID.
+func (r *Resolver) LookupGID(gid int) (*Group, error) {
+	entry, err := r.lookUp(r.group, func(b Backend) (interface{}, error) {
+		return b.LookupGID(gid)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entry.(*Group), nil
+}
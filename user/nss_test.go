@@ -0,0 +1,209 @@
+// Copyright 2021 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package user
+
+import (
+	"errors"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+// stubBackend is a backend with the entries in memory.
+type stubBackend struct {
+	users  []*User
+	groups []*Group
+	err    error
+}
+
+func (b *stubBackend) LookupUser(name string) (*User, error) {
+	return b.user(func(u *User) bool { return u.Name == name }, "Name", name)
+}
+
+func (b *stubBackend) LookupUID(uid int) (*User, error) {
+	return b.user(func(u *User) bool { return u.UID == uid }, "UID", uid)
+}
+
+func (b *stubBackend) LookupGroup(name string) (*Group, error) {
+	return b.group(func(g *Group) bool { return g.Name == name }, "Name", name)
+}
+
+func (b *stubBackend) LookupGID(gid int) (*Group, error) {
+	return b.group(func(g *Group) bool { return g.GID == gid }, "GID", gid)
+}
+
+func (b *stubBackend) user(match func(*User) bool, field string, value interface{}) (*User, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	for _, u := range b.users {
+		if match(u) {
+			return u, nil
+		}
+	}
+	return nil, NoFoundError{"stub", field, value}
+}
+
+func (b *stubBackend) group(match func(*Group) bool, field string, value interface{}) (*Group, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	for _, g := range b.groups {
+		if match(g) {
+			return g, nil
+		}
+	}
+	return nil, NoFoundError{"stub", field, value}
+}
+
+func TestResolver(t *testing.T) {
+	db := newTestDB(t, map[string]string{
+		fileNSSwitch: "# comment\n" +
+			"passwd:  files sss [NOTFOUND=return] systemd\n" +
+			"group:   files[SUCCESS=merge] ldap # comment\n" +
+			"hosts:   files dns\n",
+		fileUser:  "root:x:0:0:root:/root:/bin/sh\n",
+		fileGroup: "root:x:0:\n",
+	})
+
+	r, err := db.NewResolver()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := r.Services("passwd"); !reflect.DeepEqual(s, []string{"files", "sss", "systemd"}) {
+		t.Errorf("unexpected services of passwd: %v", s)
+	}
+	if s := r.Services("group"); !reflect.DeepEqual(s, []string{"files", "ldap"}) {
+		t.Errorf("unexpected services of group: %v", s)
+	}
+
+	sss := &stubBackend{
+		users:  []*User{{Name: "jane", UID: 5000, GID: 5000}},
+		groups: []*Group{{Name: "staff", GID: 5000}},
+	}
+	systemd := &stubBackend{users: []*User{{Name: "homed", UID: 60000}}}
+	r.SetBackend("sss", sss)
+	r.SetBackend("systemd", systemd)
+	r.SetBackend("ldap", sss)
+
+	if u, err := r.LookupUser("root"); err != nil || u.UID != 0 {
+		t.Errorf("expected user of files, got %v, %v", u, err)
+	}
+	if u, err := r.LookupUser("jane"); err != nil || u.UID != 5000 {
+		t.Errorf("expected user of sss, got %v, %v", u, err)
+	}
+	if u, err := r.LookupUID(5000); err != nil || u.Name != "jane" {
+		t.Errorf("expected user of sss, got %v, %v", u, err)
+	}
+	if _, err = r.LookupUser("homed"); err == nil {
+		t.Error("expected to stop at NOTFOUND=return")
+	} else if _, ok := err.(NoFoundError); !ok {
+		t.Errorf("expected NoFoundError, got %v", err)
+	}
+	if g, err := r.LookupGID(5000); err != nil || g.Name != "staff" {
+		t.Errorf("expected group of ldap, got %v, %v", g, err)
+	}
+	if g, err := r.LookupGroup("root"); err != nil || g.GID != 0 {
+		t.Errorf("expected group of files, got %v, %v", g, err)
+	}
+
+	// The sources not available are skipped.
+	errUnavail := errors.New("unavailable")
+	sss.err = errUnavail
+	r.SetBackend("sss", sss)
+	if u, err := r.LookupUser("homed"); err != nil || u.UID != 60000 {
+		t.Errorf("expected user of systemd, got %v, %v", u, err)
+	}
+	if _, err = r.LookupGroup("staff"); err == nil {
+		t.Error("expected error")
+	} else if _, ok := err.(NoFoundError); !ok {
+		t.Errorf("expected NoFoundError, got %v", err)
+	}
+
+	// Out of the root "/", the services are only resolved by the backends set.
+	r.backends = make(map[string]Backend)
+	if _, ok := r.backend("sss").(*Getent); ok {
+		t.Error("expected to not use getent out of the root \"/\"")
+	}
+	if u, err := r.LookupUser("root"); err != nil || u.UID != 0 {
+		t.Errorf("expected user of files, got %v, %v", u, err)
+	}
+	if _, err = r.LookupUser("jane"); err == nil {
+		t.Error("expected error")
+	} else if _, ok := err.(NoFoundError); !ok {
+		t.Errorf("expected NoFoundError, got %v", err)
+	}
+	r.db = NewDB("/")
+	if _, ok := r.backend("sss").(*Getent); !ok {
+		t.Error("expected to use getent at the root \"/\"")
+	}
+
+	// Without nsswitch.conf
+	db = newTestDB(t, map[string]string{fileUser: "root:x:0:0:root:/root:/bin/sh\n"})
+	if r, err = db.NewResolver(); err != nil {
+		t.Fatal(err)
+	}
+	if s := r.Services("passwd"); !reflect.DeepEqual(s, []string{"files"}) {
+		t.Errorf("unexpected services of passwd: %v", s)
+	}
+	if _, err = r.LookupUser("root"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestGetent(t *testing.T) {
+	db := newTestDB(t, map[string]string{})
+
+	// Fake command which only knows the entries of the service "sss".
+	cmd := db.path("/getent")
+	script := `#!/bin/sh
+[ "$1" = "-s" ] && [ "$2" = "sss" ] || exit 2
+[ "$4" = "--" ] || exit 1
+case "$3 $5" in
+"passwd jane"|"passwd 5000") echo "jane:*:5000:5000:Jane:/home/jane:/bin/sh" ;;
+"group staff"|"group 5000") echo "staff:*:5000:jane,john" ;;
+*) exit 2 ;;
+esac
+`
+	if err := ioutil.WriteFile(cmd, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	g := &Getent{Service: "sss", Command: cmd}
+	if u, err := g.LookupUser("jane"); err != nil {
+		t.Error(err)
+	} else if u.UID != 5000 || u.Dir != "/home/jane" {
+		t.Errorf("unexpected user: %v", u)
+	}
+	if u, err := g.LookupUID(5000); err != nil || u.Name != "jane" {
+		t.Errorf("unexpected user: %v, %v", u, err)
+	}
+	if gr, err := g.LookupGroup("staff"); err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(gr.UserList, []string{"jane", "john"}) {
+		t.Errorf("unexpected group: %v", gr)
+	}
+	if gr, err := g.LookupGID(5000); err != nil || gr.Name != "staff" {
+		t.Errorf("unexpected group: %v, %v", gr, err)
+	}
+
+	if _, err := g.LookupUser("john"); err == nil {
+		t.Error("expected error")
+	} else if _, ok := err.(NoFoundError); !ok {
+		t.Errorf("expected NoFoundError, got %v", err)
+	}
+	// The key is not taken like an option.
+	if _, err := g.LookupUser("-s"); err == nil {
+		t.Error("expected error")
+	} else if _, ok := err.(NoFoundError); !ok {
+		t.Errorf("expected NoFoundError, got %v", err)
+	}
+	g.Service = "ldap"
+	if _, err := g.LookupUser("jane"); err == nil {
+		t.Error("expected error")
+	}
+}
@@ -0,0 +1,219 @@
+// Copyright 2021 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package user
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+// A Policy represents the settings used to create and handle the accounts, got
+// from the files 'login.defs' and 'default/useradd'.
+//
+// The policy of a database is loaded at the first edition, and it can be
+// overridden for a call through the options of that call.
+type Policy struct {
+	// == login.defs
+
+	PassMinDays int // PASS_MIN_DAYS
+	PassMaxDays int // PASS_MAX_DAYS
+	PassMinLen  int // PASS_MIN_LEN
+	PassWarnAge int // PASS_WARN_AGE
+
+	SysUIDMin int // SYS_UID_MIN
+	SysUIDMax int // SYS_UID_MAX
+	SysGIDMin int // SYS_GID_MIN
+	SysGIDMax int // SYS_GID_MAX
+
+	UIDMin int // UID_MIN
+	UIDMax int // UID_MAX
+	GIDMin int // GID_MIN
+	GIDMax int // GID_MAX
+
+	SubUIDMin   int // SUB_UID_MIN
+	SubUIDMax   int // SUB_UID_MAX
+	SubUIDCount int // SUB_UID_COUNT
+	SubGIDMin   int // SUB_GID_MIN
+	SubGIDMax   int // SUB_GID_MAX
+	SubGIDCount int // SUB_GID_COUNT
+
+	EncryptMethod      string // ENCRYPT_METHOD, in upper case.
+	SHACryptMinRounds  int    // SHA_CRYPT_MIN_ROUNDS
+	SHACryptMaxRounds  int    // SHA_CRYPT_MAX_ROUNDS
+	YescryptCostFactor int    // YESCRYPT_COST_FACTOR
+
+	Umask    os.FileMode // UMASK
+	HomeMode os.FileMode // HOME_MODE; got from Umask whether it is not set.
+
+	UserGroupsEnab bool   // USERGROUPS_ENAB
+	CreateHome     bool   // CREATE_HOME; used by AddUser and nil AddUserOptions.
+	MailDir        string // MAIL_DIR
+
+	// == default/useradd
+
+	Home  string // HOME
+	Shell string // SHELL
+	Skel  string // SKEL
+
+	// Group is the name or the id of the primary group of the new users, when
+	// it is not created a group for the user.
+	Group string // GROUP
+
+	// Inactive is the number of days after a password has expired before the
+	// account will be disabled. A negative value disables the feature.
+	Inactive int // INACTIVE
+
+	// Expire is the date when the new accounts will be disabled, in the format
+	// YYYY-MM-DD. An empty value is to never expire.
+	Expire string // EXPIRE
+
+	// == adduser.conf
+
+	// NameRegex is the regular expression which the names of users and groups
+	// have to match; DefaultNameRegex whether it is not set.
+	NameRegex string // NAME_REGEX
+
+	// NameMaxLen is the maximum length of the names of users and groups.
+	NameMaxLen int
+
+	// == Allocation of ids
+
+	// IDAllocation is the way to choose the ids of new users and groups.
+	IDAllocation IDAllocation
+
+	// ReservedUIDs and ReservedGIDs are ranges of ids which are never chosen
+	// for new users and groups.
+	ReservedUIDs []IDRange
+	ReservedGIDs []IDRange
+
+	// PreferSameID chooses for a new user the id of its primary group, whether
+	// it is free and into the range of ids.
+	PreferSameID bool
+}
+
+// LoadPolicy returns the policy of the system.
+func LoadPolicy() (*Policy, error) { return defaultDB.LoadPolicy() }
+
+// LoadPolicy returns a copy of the policy of the database, to inspect it or to
+// override it in some call.
+func (db *DB) LoadPolicy() (*Policy, error) {
+	if err := db.initConfig(); err != nil {
+		return nil, err
+	}
+	pol := db.config.policy
+	return &pol, nil
+}
+
+// policyOf returns the policy to use in a call; the one of the database when p
+// is nil.
+func (db *DB) policyOf(p *Policy) *Policy {
+	if p == nil {
+		// The error is returned by Begin, so the transactions only use a
+		// configuration loaded.
+		db.initConfig()
+		return &db.config.policy
+	}
+	return p
+}
+
+// newPolicy returns the policy got from the configuration files.
+func newPolicy(login *confLogin, useradd *confUseradd) Policy {
+	p := Policy{
+		PassMinDays: login.PASS_MIN_DAYS,
+		PassMaxDays: login.PASS_MAX_DAYS,
+		PassMinLen:  login.PASS_MIN_LEN,
+		PassWarnAge: login.PASS_WARN_AGE,
+
+		SysUIDMin: login.SYS_UID_MIN,
+		SysUIDMax: login.SYS_UID_MAX,
+		SysGIDMin: login.SYS_GID_MIN,
+		SysGIDMax: login.SYS_GID_MAX,
+
+		UIDMin: login.UID_MIN,
+		UIDMax: login.UID_MAX,
+		GIDMin: login.GID_MIN,
+		GIDMax: login.GID_MAX,
+
+		SubUIDMin:   login.SUB_UID_MIN,
+		SubUIDMax:   login.SUB_UID_MAX,
+		SubUIDCount: login.SUB_UID_COUNT,
+		SubGIDMin:   login.SUB_GID_MIN,
+		SubGIDMax:   login.SUB_GID_MAX,
+		SubGIDCount: login.SUB_GID_COUNT,
+
+		EncryptMethod:      strings.ToUpper(login.ENCRYPT_METHOD),
+		SHACryptMinRounds:  login.SHA_CRYPT_MIN_ROUNDS,
+		SHACryptMaxRounds:  login.SHA_CRYPT_MAX_ROUNDS,
+		YescryptCostFactor: login.YESCRYPT_COST_FACTOR,
+
+		Umask:    parseMode(login.UMASK, 022),
+		HomeMode: parseMode(login.HOME_MODE, 0),
+
+		UserGroupsEnab: parseYesNo(login.USERGROUPS_ENAB),
+		CreateHome:     parseYesNo(login.CREATE_HOME),
+		MailDir:        login.MAIL_DIR,
+
+		Home:   useradd.HOME,
+		Shell:  useradd.SHELL,
+		Skel:   useradd.SKEL,
+		Group:  useradd.GROUP,
+		Expire: useradd.EXPIRE,
+
+		Inactive: -1,
+
+		NameRegex:  DefaultNameRegex,
+		NameMaxLen: NameMaxLen,
+	}
+
+	if p.HomeMode == 0 {
+		p.HomeMode = 0777 &^ p.Umask
+	}
+	if useradd.INACTIVE != "" {
+		if i, err := strconv.Atoi(useradd.INACTIVE); err == nil {
+			p.Inactive = i
+		}
+	}
+
+	if p.SubUIDMin == 0 {
+		p.SubUIDMin = 100000
+	}
+	if p.SubUIDMax == 0 {
+		p.SubUIDMax = 600100000
+	}
+	if p.SubUIDCount == 0 {
+		p.SubUIDCount = 65536
+	}
+	if p.SubGIDMin == 0 {
+		p.SubGIDMin = 100000
+	}
+	if p.SubGIDMax == 0 {
+		p.SubGIDMax = 600100000
+	}
+	if p.SubGIDCount == 0 {
+		p.SubGIDCount = 65536
+	}
+
+	return p
+}
+
+// parseMode parses a file mode in octal, returning def whether it is not valid.
+func parseMode(s string, def os.FileMode) os.FileMode {
+	if s == "" {
+		return def
+	}
+	mode, err := strconv.ParseUint(s, 8, 32)
+	if err != nil {
+		return def
+	}
+	return os.FileMode(mode).Perm()
+}
+
+// parseYesNo parses the boolean values used in 'login.defs'.
+func parseYesNo(s string) bool {
+	return strings.EqualFold(s, "yes") || strings.EqualFold(s, "true")
+}
@@ -0,0 +1,82 @@
+// Copyright 2021 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package user
+
+import "testing"
+
+func TestParsePolicy(t *testing.T) {
+	p := newPolicy(
+		&confLogin{UMASK: "077", USERGROUPS_ENAB: "yes", CREATE_HOME: "no"},
+		&confUseradd{INACTIVE: "30"},
+	)
+
+	if p.Umask != 077 {
+		t.Errorf("UMASK: expected 077, got %o", p.Umask)
+	}
+	if p.HomeMode != 0700 {
+		t.Errorf("HOME_MODE: expected to get it from UMASK, got %o", p.HomeMode)
+	}
+	if !p.UserGroupsEnab || p.CreateHome {
+		t.Error("expected to parse the values yes/no")
+	}
+	if p.Inactive != 30 {
+		t.Errorf("INACTIVE: expected 30, got %d", p.Inactive)
+	}
+	if p.SubUIDMin == 0 || p.SubUIDCount == 0 {
+		t.Error("SUB_UID: expected to set the default values")
+	}
+
+	p = newPolicy(&confLogin{HOME_MODE: "0750"}, &confUseradd{})
+	if p.HomeMode != 0750 {
+		t.Errorf("HOME_MODE: expected 0750, got %o", p.HomeMode)
+	}
+	if p.Inactive != -1 {
+		t.Errorf("INACTIVE: expected to be disabled, got %d", p.Inactive)
+	}
+}
+
+func TestLoadPolicy(t *testing.T) {
+	const name = "u_policy"
+
+	p, err := testDB.LoadPolicy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Home == "" || p.Shell == "" {
+		t.Fatalf("expected to load the policy, got: %+v", p)
+	}
+
+	// The policy got is a copy.
+	p.Home = "/srv"
+	p.Shell = "/bin/false"
+	p.Inactive = 10
+	if testDB.config.policy.Home == p.Home {
+		t.Fatal("expected to get a copy of the policy")
+	}
+
+	if _, err = testDB.AddUserWithOptions(name, GID, &AddUserOptions{Policy: p}); err != nil {
+		t.Fatal(err)
+	}
+	u, err := testDB.LookupUser(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Dir != "/srv/"+name || u.Shell != p.Shell {
+		t.Errorf("expected to override the policy, got: %s", u)
+	}
+	s, err := testDB.LookupShadow(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Inactive != p.Inactive {
+		t.Errorf("INACTIVE: expected %d, got %d", p.Inactive, s.Inactive)
+	}
+
+	if err = testDB.DelUser(name); err != nil {
+		t.Error(err)
+	}
+}
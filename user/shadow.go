@@ -8,7 +8,6 @@ package user
 
 import (
 	"fmt"
-	"os"
 	"reflect"
 	"strconv"
 	"strings"
@@ -93,7 +92,8 @@ func parseChange(s string) (changeType, error) {
 // that a day has (24*60*60) which is done by functions "setChange" and
 // "SetExpire".
 //
-// To simulate an empty field in numeric fields, it is used a negative value.
+// To simulate an empty field in numeric fields, it is used a negative value
+// (Never), since the value 0 has its own meaning in some of them.
 type Shadow struct {
 	// Login name. (Unique)
 	//
@@ -192,20 +192,54 @@ type Shadow struct {
 	//
 	// This field is reserved for future use.
 	flag int
+
+	// The optional fields Inactive, expire and flag have been set by the
+	// package, so their value 0 is not empty. In a Shadow built out of the
+	// package, the value 0 of those fields and of Min is handled like empty.
+	agesSet bool
+
+	db *DB // Database where the shadowed user is stored.
 }
 
 // NewShadow returns a structure Shadow with fields "Min", "Max" and "Warn"
 // got from the system configuration, and enabling the features of password aging.
-func NewShadow(username string) *Shadow {
-	loadConfig()
+func NewShadow(username string) *Shadow { return defaultDB.NewShadow(username) }
 
-	return &Shadow{
-		Name:    username,
-		changed: _ENABLE_AGING,
-		Min:     config.login.PASS_MIN_DAYS,
-		Max:     config.login.PASS_MAX_DAYS,
-		Warn:    config.login.PASS_WARN_AGE,
+// NewShadow returns a structure Shadow with fields "Min", "Max" and "Warn"
+// got from the configuration of the database, and enabling the features of
+// password aging.
+func (db *DB) NewShadow(username string) *Shadow { return db.newShadow(username, nil) }
+
+// newShadow returns a structure Shadow with the aging information got from the
+// policy p, or from the one of the database whether it is nil.
+// The fields "Inactive" and "expire" are set from INACTIVE and EXPIRE, like it
+// is done by "useradd(8)"; a date of expiration not valid is skipped.
+func (db *DB) newShadow(username string, p *Policy) *Shadow {
+	p = db.policyOf(p)
+
+	s := &Shadow{
+		Name:     username,
+		changed:  _ENABLE_AGING,
+		Min:      p.PassMinDays,
+		Max:      p.PassMaxDays,
+		Warn:     p.PassWarnAge,
+		Inactive: Never,
+		expire:   Never,
+		flag:     Never,
+		agesSet:  true,
+
+		db: db,
+	}
+
+	if p.Inactive >= 0 {
+		s.Inactive = p.Inactive
+	}
+	if p.Expire != "" {
+		if t, err := time.Parse("2006-01-02", p.Expire); err == nil {
+			s.SetExpire(&t)
+		}
 	}
+	return s
 }
 
 // setChange sets the date of the last password change to the current one.
@@ -227,30 +261,47 @@ func (s *Shadow) SetExpire(t *time.Time) { s.expire = secToDay(t.Unix()) }
 func (s *Shadow) filename() string { return fileShadow }
 
 func (s *Shadow) String() string {
-	var min, max, warn, inactive, expire, flag string
-
-	// Optional fields
-	if s.Min != 0 {
-		min = strconv.Itoa(s.Min)
-	}
-	if s.Max != 0 {
-		max = strconv.Itoa(s.Max)
-	}
-	if s.Warn != 0 {
-		warn = strconv.Itoa(s.Warn)
+	inactive, expire, flag := s.optionalAges()
+	min := s.Min
+	if !s.agesSet && min == 0 {
+		min = Never
 	}
-	if s.Inactive != 0 {
-		inactive = strconv.Itoa(s.Inactive)
-	}
-	if s.expire != 0 {
-		expire = strconv.Itoa(s.expire)
+
+	return fmt.Sprintf("%s:%s:%s:%s:%s:%s:%s:%s:%s\n",
+		s.Name, s.password, s.changed, formatAge(min), formatAge(s.Max),
+		formatAge(s.Warn), formatAge(inactive), formatAge(expire), formatAge(flag))
+}
+
+// optionalAges returns the fields Inactive, expire and flag, being Never the
+// value 0 of a Shadow built out of the package.
+func (s *Shadow) optionalAges() (inactive, expire, flag int) {
+	inactive, expire, flag = s.Inactive, s.expire, s.flag
+	if !s.agesSet {
+		for _, v := range []*int{&inactive, &expire, &flag} {
+			if *v == 0 {
+				*v = Never
+			}
+		}
 	}
-	if s.flag != 0 {
-		flag = strconv.Itoa(s.flag)
+	return
+}
+
+// formatAge returns the value of an optional numeric field, which is empty
+// whether it is negative.
+func formatAge(v int) string {
+	if v < 0 {
+		return ""
 	}
+	return strconv.Itoa(v)
+}
 
-	return fmt.Sprintf("%s:%s:%s:%s:%s:%s:%s:%s:%s\n",
-		s.Name, s.password, s.changed, min, max, warn, inactive, expire, flag)
+// parseAge parses an optional numeric field, returning Never whether it is
+// empty.
+func parseAge(s string) (int, error) {
+	if s == "" {
+		return Never, nil
+	}
+	return strconv.Atoi(s)
 }
 
 // parseShadow parses the row of a shadowed password.
@@ -260,56 +311,30 @@ func parseShadow(row string) (*Shadow, error) {
 		return nil, rowError{fileShadow, row}
 	}
 
-	var min, max, warn, inactive, expire, flag int
-
 	changed, err := parseChange(fields[2])
 	if err != nil {
 		return nil, atoiError{fileShadow, row, "changed"}
 	}
 
 	// Optional fields
-
-	if fields[3] != "" {
-		if min, err = strconv.Atoi(fields[3]); err != nil {
-			return nil, atoiError{fileShadow, row, "Min"}
-		}
-	}
-	if fields[4] != "" {
-		if max, err = strconv.Atoi(fields[4]); err != nil {
-			return nil, atoiError{fileShadow, row, "Max"}
-		}
-	}
-	if fields[5] != "" {
-		if warn, err = strconv.Atoi(fields[5]); err != nil {
-			return nil, atoiError{fileShadow, row, "Warn"}
-		}
-	}
-	if fields[6] != "" {
-		if inactive, err = strconv.Atoi(fields[6]); err != nil {
-			return nil, atoiError{fileShadow, row, "Inactive"}
-		}
-	}
-	if fields[7] != "" {
-		if expire, err = strconv.Atoi(fields[7]); err != nil {
-			return nil, atoiError{fileShadow, row, "expire"}
-		}
-	}
-	if fields[8] != "" {
-		if flag, err = strconv.Atoi(fields[8]); err != nil {
-			return nil, atoiError{fileShadow, row, "flag"}
+	var ages [6]int
+	for i, name := range []string{"Min", "Max", "Warn", "Inactive", "expire", "flag"} {
+		if ages[i], err = parseAge(fields[3+i]); err != nil {
+			return nil, atoiError{fileShadow, row, name}
 		}
 	}
 
 	return &Shadow{
-		fields[0],
-		fields[1],
-		changed,
-		min,
-		max,
-		warn,
-		inactive,
-		expire,
-		flag,
+		Name:     fields[0],
+		password: fields[1],
+		changed:  changed,
+		Min:      ages[0],
+		Max:      ages[1],
+		Warn:     ages[2],
+		Inactive: ages[3],
+		expire:   ages[4],
+		flag:     ages[5],
+		agesSet:  true,
 	}, nil
 }
 
@@ -318,91 +343,50 @@ func parseShadow(row string) (*Shadow, error) {
 
 // lookUp parses the shadow passwd line searching a value into the field.
 // Returns nil if is not found.
-func (*Shadow) lookUp(line string, f field, value interface{}) interface{} {
+func (*Shadow) lookUp(line string, f field, value interface{}) (interface{}, error) {
 	_field := f.(shadowField)
-	allField := strings.Split(line, ":")
-	intField := make(map[int]int)
 
-	// Check integers
-	changed, err := parseChange(allField[2])
+	s, err := parseShadow(line)
 	if err != nil {
-		panic(atoiError{fileShadow, line, "changed"})
-	}
-	// These fields could be empty.
-	if allField[3] != "" {
-		if intField[3], err = strconv.Atoi(allField[3]); err != nil {
-			panic(atoiError{fileShadow, line, "Min"})
-		}
-	}
-	if allField[4] != "" {
-		if intField[4], err = strconv.Atoi(allField[4]); err != nil {
-			panic(atoiError{fileShadow, line, "Max"})
-		}
-	}
-	if allField[5] != "" {
-		if intField[5], err = strconv.Atoi(allField[5]); err != nil {
-			panic(atoiError{fileShadow, line, "Warn"})
-		}
-	}
-	if allField[6] != "" {
-		if intField[6], err = strconv.Atoi(allField[6]); err != nil {
-			panic(atoiError{fileShadow, line, "Inactive"})
-		}
-	}
-	if allField[7] != "" {
-		if intField[7], err = strconv.Atoi(allField[7]); err != nil {
-			panic(atoiError{fileShadow, line, "expire"})
-		}
-	}
-	if allField[8] != "" {
-		if intField[8], err = strconv.Atoi(allField[8]); err != nil {
-			panic(atoiError{fileShadow, line, "flag"})
-		}
+		return nil, err
 	}
 
 	// Check fields
 	var isField bool
-	if S_NAME&_field != 0 && allField[0] == value.(string) {
+	if S_NAME&_field != 0 && s.Name == value.(string) {
 		isField = true
-	} else if S_PASSWD&_field != 0 && allField[1] == value.(string) {
+	} else if S_PASSWD&_field != 0 && s.password == value.(string) {
 		isField = true
-	} else if S_CHANGED&_field != 0 && int(changed) == value.(int) {
+	} else if S_CHANGED&_field != 0 && int(s.changed) == value.(int) {
 		isField = true
-	} else if S_MIN&_field != 0 && intField[3] == value.(int) {
+	} else if S_MIN&_field != 0 && s.Min == value.(int) {
 		isField = true
-	} else if S_MAX&_field != 0 && intField[4] == value.(int) {
+	} else if S_MAX&_field != 0 && s.Max == value.(int) {
 		isField = true
-	} else if S_WARN&_field != 0 && intField[5] == value.(int) {
+	} else if S_WARN&_field != 0 && s.Warn == value.(int) {
 		isField = true
-	} else if S_INACTIVE&_field != 0 && intField[6] == value.(int) {
+	} else if S_INACTIVE&_field != 0 && s.Inactive == value.(int) {
 		isField = true
-	} else if S_EXPIRE&_field != 0 && intField[7] == value.(int) {
+	} else if S_EXPIRE&_field != 0 && s.expire == value.(int) {
 		isField = true
-	} else if S_FLAG&_field != 0 && intField[8] == value.(int) {
+	} else if S_FLAG&_field != 0 && s.flag == value.(int) {
 		isField = true
 	} else if S_ALL&_field != 0 {
 		isField = true
 	}
 
 	if isField {
-		return &Shadow{
-			allField[0],
-			allField[1],
-			changed,
-			intField[3],
-			intField[4],
-			intField[5],
-			intField[6],
-			intField[7],
-			intField[8],
-		}
+		return s, nil
 	}
-	return nil
+	return nil, nil
 }
 
 // LookupShadow looks for the entry for the given user name.
-func LookupShadow(name string) (*Shadow, error) {
-	entries, err := LookupInShadow(S_NAME, name, 1)
+func LookupShadow(name string) (*Shadow, error) { return defaultDB.LookupShadow(name) }
+
+// LookupShadow looks for the entry for the given user name.
+func (db *DB) LookupShadow(name string) (*Shadow, error) {
+	entries, err := db.LookupInShadow(S_NAME, name, 1)
 	if err != nil {
 		return nil, err
 	}
@@ -417,9 +401,19 @@ func LookupShadow(name string) (*Shadow, error) {
 //   n == 0: the result is nil (zero fields)
 //   n < 0: all fields
 func LookupInShadow(field shadowField, value interface{}, n int) ([]*Shadow, error) {
+	return defaultDB.LookupInShadow(field, value, n)
+}
+
+// LookupInShadow looks up a shadowed password by the given values.
+//
+// The count determines the number of fields to return:
+//   n > 0: at most n fields
+//   n == 0: the result is nil (zero fields)
+//   n < 0: all fields
+func (db *DB) LookupInShadow(field shadowField, value interface{}, n int) ([]*Shadow, error) {
 	checkRoot()
 
-	iEntries, err := lookUp(&Shadow{}, field, value, n)
+	iEntries, err := db.lookUp(&Shadow{}, field, value, n)
 	if err != nil {
 		return nil, err
 	}
@@ -430,22 +424,57 @@ func LookupInShadow(field shadowField, value interface{}, n int) ([]*Shadow, err
 
 	for i := 0; i < valueSlice.Len(); i++ {
 		entries[i] = valueSlice.Index(i).Interface().(*Shadow)
+		entries[i].db = db
 	}
 
 	return entries, err
 }
 
+// LookupShadow looks for the entry for the given user name, into the changes
+// staged.
+func (tx *Tx) LookupShadow(name string) (*Shadow, error) {
+	entries, err := tx.LookupInShadow(S_NAME, name, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	return entries[0], err
+}
+
+// LookupInShadow looks up a shadowed password by the given values, into the
+// changes staged.
+func (tx *Tx) LookupInShadow(field shadowField, value interface{}, n int) ([]*Shadow, error) {
+	checkRoot()
+
+	iEntries, err := tx.lookUp(&Shadow{}, field, value, n)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]*Shadow, len(iEntries))
+	for i, v := range iEntries {
+		entries[i] = v.(*Shadow)
+		entries[i].db = tx.db
+	}
+	return entries, nil
+}
+
 // == Editing
 //
 
 // Add adds a new shadowed user.
 // If the key is not nil, generates a hashed password.
+// Whether the Shadow has not been got from this package, the value 0 of the
+// field Inactive is handled like empty.
 //
 // It is created a backup before of modify the original file.
-func (s *Shadow) Add(key []byte) (err error) {
-	loadConfig()
+func (s *Shadow) Add(key []byte) error {
+	return dbOf(s.db).updateEntry(func(tx *Tx) error { return tx.addShadow(s, key) })
+}
 
-	shadow, err := LookupShadow(s.Name)
+// addShadow stages a new shadowed user.
+func (tx *Tx) addShadow(s *Shadow, key []byte) (err error) {
+	shadow, err := tx.LookupShadow(s.Name)
 	if err != nil {
 		if _, ok := err.(NoFoundError); !ok {
 			return
@@ -455,8 +484,8 @@ func (s *Shadow) Add(key []byte) (err error) {
 		return ErrUserExist
 	}
 
-	if s.Name == "" {
-		return RequiredError("Name")
+	if err = tx.db.policyOf(nil).ValidateName(s.Name); err != nil {
+		return err
 	}
 	if s.Max == 0 {
 		return RequiredError("Max")
@@ -464,25 +493,20 @@ func (s *Shadow) Add(key []byte) (err error) {
 	if s.Warn == 0 {
 		return RequiredError("Warn")
 	}
-
-	// Backup
-	if err = backup(fileShadow); err != nil {
-		return
-	}
-
-	db, err := openDBFile(fileShadow, os.O_WRONLY|os.O_APPEND)
-	if err != nil {
-		return
-	}
-	defer func() {
-		e := db.close()
-		if e != nil && err == nil {
-			err = e
+	if !s.agesSet {
+		if s.Min == 0 {
+			s.Min = Never
 		}
-	}()
+		s.Inactive, s.expire, s.flag = s.optionalAges()
+		s.agesSet = true
+	}
 
 	if key != nil {
-		s.password, _ = config.crypter.Generate(key, nil)
+		hash, err := tx.db.hashPasswd(key, nil)
+		if err != nil {
+			return err
+		}
+		s.password = hash
 		if s.changed == _ENABLE_AGING {
 			s.setChange()
 		}
@@ -490,6 +514,5 @@ func (s *Shadow) Add(key []byte) (err error) {
 		s.password = "*" // Password disabled.
 	}
 
-	_, err = db.file.WriteString(s.String())
-	return
+	return tx.appendRow(s.Name, s)
 }
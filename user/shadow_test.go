@@ -9,12 +9,14 @@ package user
 import (
 	"bufio"
 	"io"
+	"io/ioutil"
 	"os"
 	"testing"
+	"time"
 )
 
 func TestShadowParser(t *testing.T) {
-	f, err := os.Open(fileShadow)
+	f, err := os.Open(testDB.path(fileShadow))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -39,17 +41,17 @@ func TestShadowParser(t *testing.T) {
 }
 
 func TestShadowFull(t *testing.T) {
-	entry, err := LookupShadow("root")
+	entry, err := testDB.LookupShadow("root")
 	if err != nil || entry == nil {
 		t.Error(err)
 	}
 
-	entries, err := LookupInShadow(S_PASSWD, "!", -1)
+	entries, err := testDB.LookupInShadow(S_PASSWD, "!", -1)
 	if err != nil || entries == nil {
 		t.Error(err)
 	}
 
-	entries, err = LookupInShadow(S_ALL, nil, -1)
+	entries, err = testDB.LookupInShadow(S_ALL, nil, -1)
 	if err != nil || len(entries) == 0 {
 		t.Error(err)
 	}
@@ -57,36 +59,36 @@ func TestShadowFull(t *testing.T) {
 
 func TestShadowCount(t *testing.T) {
 	count := 2
-	entries, err := LookupInShadow(S_MIN, 0, count)
+	entries, err := testDB.LookupInShadow(S_MIN, 0, count)
 	if err != nil || len(entries) != count {
 		t.Error(err)
 	}
 
 	count = 5
-	entries, err = LookupInShadow(S_ALL, nil, count)
+	entries, err = testDB.LookupInShadow(S_ALL, nil, count)
 	if err != nil || len(entries) != count {
 		t.Error(err)
 	}
 }
 
 func TestShadowError(t *testing.T) {
-	_, err := LookupShadow("!!!???")
+	_, err := testDB.LookupShadow("!!!???")
 	if _, ok := err.(NoFoundError); !ok {
 		t.Error("expected to report NoFoundError")
 	}
 
-	if _, err = LookupInShadow(S_MIN, 0, 0); err != errSearch {
+	if _, err = testDB.LookupInShadow(S_MIN, 0, 0); err != errSearch {
 		t.Error("expected to report errSearch")
 	}
 
-	s := &Shadow{}
+	s := &Shadow{db: testDB}
 	if err = s.Add(nil); err != RequiredError("Name") {
 		t.Error("expected to report RequiredError")
 	}
 }
 
 func TestShadow_Add(t *testing.T) {
-	shadow := NewShadow(USER)
+	shadow := testDB.NewShadow(USER)
 	err := shadow.Add(nil)
 	if err != nil {
 		t.Fatal(err)
@@ -100,7 +102,7 @@ func TestShadow_Add(t *testing.T) {
 		}
 	}
 
-	s, err := LookupShadow(USER)
+	s, err := testDB.LookupShadow(USER)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -110,26 +112,50 @@ func TestShadow_Add(t *testing.T) {
 	}
 }
 
+func TestShadowLiteral(t *testing.T) {
+	s := &Shadow{Name: "bob", Max: 99999, Warn: 7}
+	if row := s.String(); row != "bob::0::99999:7:::\n" {
+		t.Errorf("expected the fields unset like empty, got: %q", row)
+	}
+
+	db := newTestDB(t, map[string]string{
+		fileLogin:  "ENCRYPT_METHOD SHA512\n",
+		fileShadow: "",
+	})
+	s.db = db
+	if err := s.Add(nil); err != nil {
+		t.Fatal(err)
+	}
+	if s, err := db.LookupShadow("bob"); err != nil {
+		t.Fatal(err)
+	} else if s.Inactive != Never || s.expire != Never || s.AccountExpired(time.Now()) {
+		t.Errorf("expected an account not expired, got: %s", s)
+	}
+	if data, _ := ioutil.ReadFile(db.path(fileShadow)); string(data) != "bob:*:0::99999:7:::\n" {
+		t.Errorf("unexpected file:\n%s", data)
+	}
+}
+
 var (
 	userKey1 = []byte("123")
 	userKey2 = []byte("456")
 )
 
 func TestShadowCrypt(t *testing.T) {
-	s, err := LookupShadow(USER)
+	s, err := testDB.LookupShadow(USER)
 	if err != nil {
 		t.Fatal(err)
 	}
 	s.Passwd(userKey1)
-	if err = config.crypter.Verify(s.password, userKey1); err != nil {
+	if err = testDB.config.crypter.Verify(s.password, userKey1); err != nil {
 		t.Fatalf("expected to get the same hashed password for %q", userKey1)
 	}
 
-	if err = ChPasswd(USER, userKey2); err != nil {
+	if err = testDB.ChPasswd(USER, userKey2); err != nil {
 		t.Fatalf("expected to change password: %s", err)
 	}
-	s, _ = LookupShadow(USER)
-	if err = config.crypter.Verify(s.password, userKey2); err != nil {
+	s, _ = testDB.LookupShadow(USER)
+	if err = testDB.config.crypter.Verify(s.password, userKey2); err != nil {
 		t.Fatalf("ChPasswd: expected to get the same hashed password for %q", userKey2)
 	}
 }
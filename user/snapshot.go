@@ -0,0 +1,649 @@
+// Copyright 2021 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// Snapshots of the accounts
+
+package user
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// A Snapshot represents the state of the accounts of a database, to be audited
+// or to be applied like the desired state of other one.
+//
+// The users and groups are in the order they are stored. It is read and written
+// in JSON, which is a stable format to be stored or compared.
+type Snapshot struct {
+	Users  []*UserState  `json:"users"`
+	Groups []*GroupState `json:"groups"`
+}
+
+// A UserState represents an user, with the data of its shadowed entry.
+type UserState struct {
+	Name  string `json:"name"`
+	UID   int    `json:"uid"`
+	GID   int    `json:"gid"`
+	Gecos string `json:"gecos"`
+	Dir   string `json:"dir"`
+	Shell string `json:"shell"`
+
+	// Password is the hashed password; it is nil when it is redacted, and then
+	// it is not handled at applying the snapshot.
+	Password *string `json:"password,omitempty"`
+
+	// Aging is nil whether the user has not a shadowed entry.
+	Aging *AgingState `json:"aging,omitempty"`
+}
+
+// An AgingState represents the password aging of an user.
+//
+// The fields have the values stored in the shadowed file, where the dates are
+// the days since Jan 1, 1970; -1 (Never) is an empty field.
+type AgingState struct {
+	LastChange int `json:"last_change"`
+	Min        int `json:"min"`
+	Max        int `json:"max"`
+	Warn       int `json:"warn"`
+	Inactive   int `json:"inactive"`
+	Expire     int `json:"expire"`
+}
+
+// A GroupState represents a group, with the data of its shadowed entry.
+type GroupState struct {
+	Name    string   `json:"name"`
+	GID     int      `json:"gid"`
+	Members []string `json:"members"`
+	Admins  []string `json:"admins,omitempty"`
+
+	// Password is the hashed password; it is nil when it is redacted, and then
+	// it is not handled at applying the snapshot.
+	Password *string `json:"password,omitempty"`
+}
+
+// SnapshotOptions represents the options to take a snapshot.
+type SnapshotOptions struct {
+	// RedactHashes leaves out the hashed passwords.
+	RedactHashes bool
+}
+
+// TakeSnapshot returns the state of the accounts of the system.
+func TakeSnapshot(opts *SnapshotOptions) (*Snapshot, error) {
+	return defaultDB.TakeSnapshot(opts)
+}
+
+// TakeSnapshot returns the state of the accounts of the database.
+func (db *DB) TakeSnapshot(opts *SnapshotOptions) (*Snapshot, error) {
+	users, err := db.AllUsers()
+	if err != nil {
+		return nil, err
+	}
+	groups, err := db.AllGroups()
+	if err != nil {
+		return nil, err
+	}
+	shadows, err := db.AllShadows()
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	gshadows, err := db.AllGShadows()
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return newSnapshot(users, groups, shadows, gshadows, opts), nil
+}
+
+// TakeSnapshot returns the state of the accounts staged.
+func (tx *Tx) TakeSnapshot(opts *SnapshotOptions) (*Snapshot, error) {
+	users, err := tx.AllUsers()
+	if err != nil {
+		return nil, err
+	}
+	groups, err := tx.AllGroups()
+	if err != nil {
+		return nil, err
+	}
+	shadows, err := tx.AllShadows()
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	gshadows, err := tx.AllGShadows()
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return newSnapshot(users, groups, shadows, gshadows, opts), nil
+}
+
+func newSnapshot(users []*User, groups []*Group, shadows []*Shadow, gshadows []*GShadow,
+	opts *SnapshotOptions) *Snapshot {
+
+	if opts == nil {
+		opts = &SnapshotOptions{}
+	}
+	shadowOf := make(map[string]*Shadow, len(shadows))
+	for _, s := range shadows {
+		shadowOf[s.Name] = s
+	}
+	gshadowOf := make(map[string]*GShadow, len(gshadows))
+	for _, gs := range gshadows {
+		gshadowOf[gs.Name] = gs
+	}
+
+	snap := &Snapshot{
+		Users:  make([]*UserState, 0, len(users)),
+		Groups: make([]*GroupState, 0, len(groups)),
+	}
+
+	for _, u := range users {
+		st := &UserState{
+			Name:  u.Name,
+			UID:   u.UID,
+			GID:   u.GID,
+			Gecos: u.Gecos,
+			Dir:   u.Dir,
+			Shell: u.Shell,
+		}
+		if s, ok := shadowOf[u.Name]; ok {
+			if !opts.RedactHashes {
+				st.Password = stringPtr(s.password)
+			}
+			st.Aging = &AgingState{
+				LastChange: int(s.changed),
+				Min:        s.Min,
+				Max:        s.Max,
+				Warn:       s.Warn,
+				Inactive:   s.Inactive,
+				Expire:     s.expire,
+			}
+		}
+		snap.Users = append(snap.Users, st)
+	}
+
+	for _, g := range groups {
+		st := &GroupState{
+			Name:    g.Name,
+			GID:     g.GID,
+			Members: memberList(g.UserList),
+		}
+		if gs, ok := gshadowOf[g.Name]; ok {
+			if !opts.RedactHashes {
+				st.Password = stringPtr(gs.password)
+			}
+			if admins := memberList(gs.AdminList); len(admins) != 0 {
+				st.Admins = admins
+			}
+		}
+		snap.Groups = append(snap.Groups, st)
+	}
+	return snap
+}
+
+// ReadSnapshot reads a snapshot in JSON.
+func ReadSnapshot(r io.Reader) (*Snapshot, error) {
+	snap := &Snapshot{}
+	if err := json.NewDecoder(r).Decode(snap); err != nil {
+		return nil, err
+	}
+	return snap, nil
+}
+
+// WriteJSON writes the snapshot in JSON, indented.
+func (snap *Snapshot) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(snap)
+}
+
+// == Apply
+//
+
+// An Action represents the kind of change done to an account, at applying a
+// snapshot, or to an entry, in the audit events.
+type Action int
+
+const (
+	ActionAdd Action = iota + 1
+	ActionUpdate
+	ActionRemove
+)
+
+func (a Action) String() string {
+	switch a {
+	case ActionAdd:
+		return "add"
+	case ActionUpdate:
+		return "update"
+	case ActionRemove:
+		return "remove"
+	}
+	return fmt.Sprintf("Action(%d)", int(a))
+}
+
+// A Change represents a change of an account, done to apply a snapshot.
+type Change struct {
+	Action Action
+	Group  bool   // The account is a group, else it is an user.
+	Name   string // Name of the user or group.
+
+	// Fields are the names of the fields to update, in the JSON document.
+	Fields []string
+}
+
+func (c *Change) String() string {
+	kind := "user"
+	if c.Group {
+		kind = "group"
+	}
+	s := c.Action.String() + " " + kind + " " + c.Name
+	if len(c.Fields) != 0 {
+		s += ": " + strings.Join(c.Fields, ", ")
+	}
+	return s
+}
+
+// ApplyOptions represents the options to apply a snapshot.
+type ApplyOptions struct {
+	// Remove removes the users and groups which are not in the snapshot.
+	Remove bool
+
+	// DryRun only returns the changes to do, without doing them.
+	DryRun bool
+}
+
+// ApplySnapshot sets the accounts of the system to the state of the snapshot,
+// returning the changes done.
+func ApplySnapshot(snap *Snapshot, opts *ApplyOptions) ([]*Change, error) {
+	return defaultDB.ApplySnapshot(snap, opts)
+}
+
+// ApplySnapshot sets the accounts of the database to the state of the
+// snapshot, returning the changes done.
+//
+// The users and groups which are not in the database are added, the ones whose
+// fields differ are updated, and the ones which are not in the snapshot are
+// removed whether it is set in the options.
+// In a dry-run, the changes are staged to be validated, and then discarded.
+func (db *DB) ApplySnapshot(snap *Snapshot, opts *ApplyOptions) ([]*Change, error) {
+	if opts == nil {
+		opts = &ApplyOptions{}
+	}
+
+	if !opts.DryRun {
+		var changes []*Change
+		err := db.update(func(tx *Tx) (err error) {
+			changes, err = tx.ApplySnapshot(snap, &ApplyOptions{Remove: opts.Remove})
+			return
+		})
+		return changes, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	changes, err := tx.ApplySnapshot(snap, &ApplyOptions{Remove: opts.Remove})
+	if err != nil {
+		return changes, err
+	}
+	return changes, tx.validate()
+}
+
+// ApplySnapshot stages the changes to set the accounts to the state of the
+// snapshot, returning them. In a dry-run, nothing is staged.
+//
+// The groups are applied before of the users, so these ones can have like
+// primary group one of the groups added.
+func (tx *Tx) ApplySnapshot(snap *Snapshot, opts *ApplyOptions) ([]*Change, error) {
+	if opts == nil {
+		opts = &ApplyOptions{}
+	}
+	changes, err := tx.planSnapshot(snap, opts.Remove)
+	if err != nil || opts.DryRun {
+		return changes, err
+	}
+
+	userOf := make(map[string]*UserState, len(snap.Users))
+	for _, st := range snap.Users {
+		userOf[st.Name] = st
+	}
+	groupOf := make(map[string]*GroupState, len(snap.Groups))
+	for _, st := range snap.Groups {
+		groupOf[st.Name] = st
+	}
+
+	// The accounts are removed at first, to free their ids.
+	for _, c := range changes {
+		if c.Action != ActionRemove {
+			continue
+		}
+		if c.Group {
+			err = tx.DelGroup(c.Name)
+		} else {
+			// The groups to remove are got from the snapshot.
+			err = tx.DelUserWithOptions(c.Name, &DelUserOptions{KeepUserGroup: true})
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, c := range changes {
+		if !c.Group {
+			continue
+		}
+		switch c.Action {
+		case ActionAdd:
+			err = tx.addGroupState(groupOf[c.Name])
+		case ActionUpdate:
+			err = tx.updateGroupState(groupOf[c.Name], c.Fields)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, c := range changes {
+		if c.Group {
+			continue
+		}
+		switch c.Action {
+		case ActionAdd:
+			err = tx.addUserState(userOf[c.Name])
+		case ActionUpdate:
+			err = tx.updateUserState(userOf[c.Name], c.Fields)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return changes, nil
+}
+
+// planSnapshot returns the changes to do to get the state of the snapshot.
+func (tx *Tx) planSnapshot(snap *Snapshot, remove bool) ([]*Change, error) {
+	current, err := tx.TakeSnapshot(nil)
+	if err != nil {
+		return nil, err
+	}
+	changes := make([]*Change, 0)
+
+	groupOf := make(map[string]*GroupState, len(current.Groups))
+	for _, st := range current.Groups {
+		groupOf[st.Name] = st
+	}
+	wanted := make(map[string]bool, len(snap.Groups))
+
+	for _, st := range snap.Groups {
+		wanted[st.Name] = true
+
+		cur, ok := groupOf[st.Name]
+		if !ok {
+			changes = append(changes, &Change{Action: ActionAdd, Group: true, Name: st.Name})
+			continue
+		}
+		fields := make([]string, 0)
+		if st.GID != cur.GID {
+			fields = append(fields, "gid")
+		}
+		if !equalList(st.Members, cur.Members) {
+			fields = append(fields, "members")
+		}
+		if !equalList(st.Admins, cur.Admins) {
+			fields = append(fields, "admins")
+		}
+		if st.Password != nil && (cur.Password == nil || *st.Password != *cur.Password) {
+			fields = append(fields, "password")
+		}
+		if len(fields) != 0 {
+			changes = append(changes, &Change{ActionUpdate, true, st.Name, fields})
+		}
+	}
+	if remove {
+		for _, st := range current.Groups {
+			if !wanted[st.Name] {
+				changes = append(changes, &Change{Action: ActionRemove, Group: true, Name: st.Name})
+			}
+		}
+	}
+
+	userOf := make(map[string]*UserState, len(current.Users))
+	for _, st := range current.Users {
+		userOf[st.Name] = st
+	}
+	wanted = make(map[string]bool, len(snap.Users))
+
+	for _, st := range snap.Users {
+		wanted[st.Name] = true
+
+		cur, ok := userOf[st.Name]
+		if !ok {
+			changes = append(changes, &Change{Action: ActionAdd, Name: st.Name})
+			continue
+		}
+		fields := make([]string, 0)
+		if st.UID != cur.UID {
+			fields = append(fields, "uid")
+		}
+		if st.GID != cur.GID {
+			fields = append(fields, "gid")
+		}
+		if st.Gecos != cur.Gecos {
+			fields = append(fields, "gecos")
+		}
+		if st.Dir != cur.Dir {
+			fields = append(fields, "dir")
+		}
+		if st.Shell != cur.Shell {
+			fields = append(fields, "shell")
+		}
+		if st.Password != nil && (cur.Password == nil || *st.Password != *cur.Password) {
+			fields = append(fields, "password")
+		}
+		if st.Aging != nil && (cur.Aging == nil || *st.Aging != *cur.Aging) {
+			fields = append(fields, "aging")
+		}
+		if len(fields) != 0 {
+			changes = append(changes, &Change{ActionUpdate, false, st.Name, fields})
+		}
+	}
+	if remove {
+		for _, st := range current.Users {
+			if !wanted[st.Name] {
+				changes = append(changes, &Change{Action: ActionRemove, Name: st.Name})
+			}
+		}
+	}
+
+	return changes, nil
+}
+
+// addGroupState stages a group with its shadowed entry.
+func (tx *Tx) addGroupState(st *GroupState) error {
+	gs := tx.db.NewGShadow(st.Name, st.Members...)
+	gs.AdminList = st.Admins
+	if err := tx.addGShadow(gs, nil); err != nil {
+		return err
+	}
+	if st.Password != nil {
+		gs.password = *st.Password
+		if err := tx.edit(gs.Name, gs); err != nil {
+			return err
+		}
+	}
+
+	g := tx.db.NewGroup(st.Name, st.Members...)
+	g.GID = st.GID
+	_, err := tx.addGroup(g)
+	return err
+}
+
+// updateGroupState stages the fields of a group.
+func (tx *Tx) updateGroupState(st *GroupState, fields []string) error {
+	for _, field := range fields {
+		if field == "gid" {
+			if err := tx.ModGroup(st.Name, &GroupMod{GID: &st.GID}); err != nil {
+				return err
+			}
+		}
+	}
+
+	g, err := tx.LookupGroup(st.Name)
+	if err != nil {
+		return err
+	}
+	g.UserList = st.Members
+	if err = tx.edit(g.Name, g); err != nil {
+		return err
+	}
+
+	gs, err := tx.LookupGShadow(st.Name)
+	if err != nil {
+		if _, ok := err.(NoFoundError); !ok {
+			return err
+		}
+		gs = tx.db.NewGShadow(st.Name)
+		if err = tx.addGShadow(gs, nil); err != nil {
+			return err
+		}
+	}
+	gs.UserList = st.Members
+	gs.AdminList = st.Admins
+	if st.Password != nil {
+		gs.password = *st.Password
+	}
+	return tx.edit(gs.Name, gs)
+}
+
+// addUserState stages an user with its shadowed entry.
+func (tx *Tx) addUserState(st *UserState) error {
+	// The aging is set once the entry is added, since the fields could be
+	// empty, which is not allowed for a new entry.
+	s := tx.db.newShadow(st.Name, nil)
+	s.setChange()
+	if err := tx.addShadow(s, nil); err != nil {
+		return err
+	}
+	if st.Password != nil || st.Aging != nil {
+		if st.Password != nil {
+			s.password = *st.Password
+		}
+		if st.Aging != nil {
+			st.Aging.setTo(s)
+		}
+		if err := tx.edit(s.Name, s); err != nil {
+			return err
+		}
+	}
+
+	u := &User{
+		Name:  st.Name,
+		UID:   st.UID,
+		GID:   st.GID,
+		Gecos: st.Gecos,
+		Dir:   st.Dir,
+		Shell: st.Shell,
+
+		db: tx.db,
+	}
+	_, err := tx.addUser(u, nil)
+	return err
+}
+
+// updateUserState stages the fields of an user.
+func (tx *Tx) updateUserState(st *UserState, fields []string) error {
+	mod := &UserMod{}
+	editShadow := false
+
+	for _, field := range fields {
+		switch field {
+		case "uid":
+			mod.UID = &st.UID
+		case "gid":
+			mod.GID = &st.GID
+		case "gecos":
+			mod.Gecos = &st.Gecos
+		case "dir":
+			mod.Dir = &st.Dir
+		case "shell":
+			mod.Shell = &st.Shell
+		case "password", "aging":
+			editShadow = true
+		}
+	}
+	if mod.UID != nil || mod.GID != nil || mod.Gecos != nil || mod.Dir != nil || mod.Shell != nil {
+		if err := tx.ModUser(st.Name, mod); err != nil {
+			return err
+		}
+	}
+	if !editShadow {
+		return nil
+	}
+
+	s, err := tx.LookupShadow(st.Name)
+	if err != nil {
+		if _, ok := err.(NoFoundError); !ok {
+			return err
+		}
+		s = tx.db.newShadow(st.Name, nil)
+		s.setChange()
+		if err = tx.addShadow(s, nil); err != nil {
+			return err
+		}
+	}
+	if st.Password != nil {
+		s.password = *st.Password
+	}
+	if st.Aging != nil {
+		st.Aging.setTo(s)
+	}
+	return tx.edit(s.Name, s)
+}
+
+// setTo sets the password aging to the shadowed entry.
+func (a *AgingState) setTo(s *Shadow) {
+	s.changed = changeType(a.LastChange)
+	s.Min = a.Min
+	s.Max = a.Max
+	s.Warn = a.Warn
+	s.Inactive = a.Inactive
+	s.expire = a.Expire
+}
+
+// == Utility
+//
+
+// memberList returns the list of members without empty names.
+func memberList(list []string) []string {
+	members := make([]string, 0, len(list))
+	for _, v := range list {
+		if v != "" {
+			members = append(members, v)
+		}
+	}
+	return members
+}
+
+// equalList reports whether both lists of members are equal, skipping the
+// empty names.
+func equalList(a, b []string) bool {
+	a, b = memberList(a), memberList(b)
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func stringPtr(s string) *string { return &s }
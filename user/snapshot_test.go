@@ -0,0 +1,192 @@
+// Copyright 2021 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package user
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSnapshot(t *testing.T) {
+	const login = "ENCRYPT_METHOD SHA512\nUID_MIN 1000\nUID_MAX 60000\nGID_MIN 1000\nGID_MAX 60000\n"
+
+	src := newTestDB(t, map[string]string{
+		fileLogin: login,
+		fileUser: "root:x:0:0:root:/root:/bin/sh\n" +
+			"u1:x:1000:1000:User One:/home/u1:/bin/sh\n" +
+			"u2:x:1001:1001::/home/u2:/bin/bash\n",
+		fileGroup: "root:x:0:\ng1:x:1000:u1,u2\ng2:x:1001:\n",
+		fileShadow: "root:*:18000:0:99999:7:::\n" +
+			"u1:$6$salt$hash:18500:0:99999:7::19000:\n" +
+			"u2:!:18600::::::\n",
+		fileGShadow: "root:*::\ng1:!:u1:u1,u2\ng2:*::\n",
+	})
+	dst := newTestDB(t, map[string]string{
+		fileLogin: login,
+		fileUser: "root:x:0:0:root:/root:/bin/sh\n" +
+			"u1:x:1000:1000:Old:/home/u1:/bin/sh\n" +
+			"extra:x:1002:1000::/home/extra:/bin/sh\n",
+		fileGroup:   "root:x:0:\ng1:x:1000:u1,extra\n",
+		fileShadow:  "root:*:18000:0:99999:7:::\nu1:*:18000:0:99999:7:::\nextra:*:18000:0:99999:7:::\n",
+		fileGShadow: "root:*::\ng1:!::u1,extra\n",
+	})
+
+	// Redacted
+	snap, err := src.TakeSnapshot(&SnapshotOptions{RedactHashes: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	if err = snap.WriteJSON(buf); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buf.String(), "password") {
+		t.Errorf("expected the hashes redacted:\n%s", buf)
+	}
+
+	snap, err = src.TakeSnapshot(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	if err = snap.WriteJSON(buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), `"$6$salt$hash"`) {
+		t.Errorf("expected the hashes:\n%s", buf)
+	}
+	if snap, err = ReadSnapshot(buf); err != nil {
+		t.Fatal(err)
+	}
+	if st := snap.Users[1]; st.Aging == nil || st.Aging.LastChange != 18500 || st.Aging.Expire != 19000 {
+		t.Errorf("unexpected aging: %+v", st.Aging)
+	}
+	if st := snap.Users[2]; st.Aging == nil || st.Aging.Max != Never || st.Aging.LastChange != 18600 {
+		t.Errorf("unexpected aging: %+v", st.Aging)
+	}
+	if st := snap.Groups[1]; !reflect.DeepEqual(st.Admins, []string{"u1"}) ||
+		!reflect.DeepEqual(st.Members, []string{"u1", "u2"}) {
+		t.Errorf("unexpected group: %+v", st)
+	}
+
+	// Plan
+	expected := []string{
+		"update group g1: members, admins",
+		"add group g2",
+		"update user u1: gecos, password, aging",
+		"add user u2",
+		"remove user extra",
+	}
+
+	before, err := dst.TakeSnapshot(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes, err := dst.ApplySnapshot(snap, &ApplyOptions{Remove: true, DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := changeStrings(changes); !reflect.DeepEqual(got, expected) {
+		t.Errorf("unexpected plan:\n%q\nexpected\n%q", got, expected)
+	}
+	if after, err := dst.TakeSnapshot(nil); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(after, before) {
+		t.Error("expected no changes in a dry-run")
+	}
+
+	// Apply
+	if changes, err = dst.ApplySnapshot(snap, &ApplyOptions{Remove: true}); err != nil {
+		t.Fatal(err)
+	}
+	if got := changeStrings(changes); !reflect.DeepEqual(got, expected) {
+		t.Errorf("unexpected changes:\n%q\nexpected\n%q", got, expected)
+	}
+	after, err := dst.TakeSnapshot(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(after, snap) {
+		buf.Reset()
+		after.WriteJSON(buf)
+		t.Errorf("expected the state of the snapshot, got:\n%s", buf)
+	}
+
+	if changes, err = dst.ApplySnapshot(snap, &ApplyOptions{Remove: true}); err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 0 {
+		t.Errorf("expected no changes, got %q", changeStrings(changes))
+	}
+}
+
+func changeStrings(changes []*Change) []string {
+	s := make([]string, len(changes))
+	for i, c := range changes {
+		s[i] = c.String()
+	}
+	return s
+}
+
+func TestApplySnapshotUserGroup(t *testing.T) {
+	db := newTestDB(t, map[string]string{
+		fileLogin:   "ENCRYPT_METHOD SHA512\nUSERGROUPS_ENAB yes\n",
+		fileUser:    "ann:x:1000:1000::/home/ann:/bin/sh\nbob:x:1001:1001::/home/bob:/bin/sh\n",
+		fileGroup:   "ann:x:1000:\nbob:x:1001:\n",
+		fileShadow:  "ann:*:18000:0:99999:7:::\nbob:*:18000:0:99999:7:::\n",
+		fileGShadow: "ann:!::\nbob:!::\n",
+	})
+
+	// The snapshot keeps the group "ann" but not its user, and it removes
+	// both the user and the group "bob".
+	snap := &Snapshot{
+		Groups: []*GroupState{{Name: "ann", GID: 1000}},
+	}
+	changes, err := db.ApplySnapshot(snap, &ApplyOptions{Remove: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plan := make([]string, len(changes))
+	for i, c := range changes {
+		plan[i] = c.String()
+	}
+	expected := []string{"remove group bob", "remove user ann", "remove user bob"}
+	if !reflect.DeepEqual(plan, expected) {
+		t.Errorf("unexpected changes: %q", plan)
+	}
+
+	if _, err = db.LookupGroup("ann"); err != nil {
+		t.Errorf("expected to keep the group of the snapshot: %v", err)
+	}
+	if _, err = db.LookupGroup("bob"); err == nil {
+		t.Error("expected to remove the group")
+	}
+}
+
+func TestApplySnapshotValidate(t *testing.T) {
+	db := newTestDB(t, map[string]string{
+		fileLogin:   "ENCRYPT_METHOD SHA512\n",
+		fileUser:    "u1:x:1000:1000::/home/u1:/bin/sh\n",
+		fileGroup:   "g1:x:1000:\n",
+		fileShadow:  "u1:*:18000:0:99999:7:::\nu1:*:18000:0:99999:7:::\n",
+		fileGShadow: "g1:!::\n",
+	})
+	snap, err := db.TakeSnapshot(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	snap.Users[0].Aging.Max = 30
+
+	// The shadowed entry edited is duplicated.
+	_, err = db.ApplySnapshot(snap, &ApplyOptions{DryRun: true})
+	if _, ok := err.(TxError); !ok {
+		t.Errorf("expected to validate the changes in a dry-run, got: %v", err)
+	}
+}
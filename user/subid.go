@@ -0,0 +1,477 @@
+// Copyright 2021 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package user
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Files of subordinate ids, used by the user namespaces (see "subuid(5)").
+const (
+	fileSubUID = "/etc/subuid"
+	fileSubGID = "/etc/subgid"
+)
+
+type subidField int
+
+// Field names for subordinate ids database.
+const (
+	SI_NAME subidField = 1 << iota
+	SI_START
+	SI_COUNT
+
+	SI_ALL
+)
+
+func (f subidField) String() string {
+	switch f {
+	case SI_NAME:
+		return "Name"
+	case SI_START:
+		return "Start"
+	case SI_COUNT:
+		return "Count"
+	}
+	return "ALL"
+}
+
+// A SubID represents a range of subordinate ids which an user is allowed to use
+// in an user namespace. It is stored in '/etc/subuid' for the user ids, and in
+// '/etc/subgid' for the group ids.
+//
+// An user can have several ranges, keyed by its login name or its user id.
+type SubID struct {
+	// Login name or user id.
+	Name string
+
+	// First subordinate id of the range.
+	Start int
+
+	// Number of subordinate ids of the range.
+	Count int
+
+	file string // File where the range is stored.
+	db   *DB    // Database where the range is stored.
+}
+
+// IsGroup indicates whether it is a range of group ids.
+func (s *SubID) IsGroup() bool { return s.file == fileSubGID }
+
+func (s *SubID) filename() string { return s.file }
+
+func (s *SubID) String() string {
+	return fmt.Sprintf("%s:%d:%d\n", s.Name, s.Start, s.Count)
+}
+
+// parseSubID parses the row of a range of subordinate ids.
+func parseSubID(file, row string) (*SubID, error) {
+	fields := strings.Split(row, ":")
+	if len(fields) != 3 {
+		return nil, rowError{file, row}
+	}
+
+	start, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return nil, atoiError{file, row, "Start"}
+	}
+	count, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return nil, atoiError{file, row, "Count"}
+	}
+
+	return &SubID{
+		Name:  fields[0],
+		Start: start,
+		Count: count,
+		file:  file,
+	}, nil
+}
+
+// == Lookup
+//
+
+// lookUp parses the line of subordinate ids searching a value into the field.
+// Returns nil if it is not found.
+func (s *SubID) lookUp(line string, f field, value interface{}) (interface{}, error) {
+	_field := f.(subidField)
+
+	entry, err := parseSubID(s.file, line)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check fields
+	var isField bool
+	if SI_NAME&_field != 0 && entry.Name == value.(string) {
+		isField = true
+	} else if SI_START&_field != 0 && entry.Start == value.(int) {
+		isField = true
+	} else if SI_COUNT&_field != 0 && entry.Count == value.(int) {
+		isField = true
+	} else if SI_ALL&_field != 0 {
+		isField = true
+	}
+
+	if isField {
+		return entry, nil
+	}
+	return nil, nil
+}
+
+// LookupSubUID looks up the ranges of subordinate user ids of an user.
+func LookupSubUID(name string) ([]*SubID, error) { return defaultDB.LookupSubUID(name) }
+
+// LookupSubUID looks up the ranges of subordinate user ids of an user, keyed by
+// its login name or its user id.
+func (db *DB) LookupSubUID(name string) ([]*SubID, error) {
+	return db.lookupSubIDs(false, name)
+}
+
+// LookupSubGID looks up the ranges of subordinate group ids of an user.
+func LookupSubGID(name string) ([]*SubID, error) { return defaultDB.LookupSubGID(name) }
+
+// LookupSubGID looks up the ranges of subordinate group ids of an user, keyed by
+// its login name or its user id.
+func (db *DB) LookupSubGID(name string) ([]*SubID, error) {
+	return db.lookupSubIDs(true, name)
+}
+
+// lookupSubIDs looks up the ranges of subordinate ids of an user.
+func (db *DB) lookupSubIDs(isGroup bool, name string) ([]*SubID, error) {
+	keys, err := subIDKeys(name, db.LookupUser)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := db.LookupInSubID(isGroup, SI_ALL, nil, -1)
+	if ignoreNoFound(err) != nil {
+		return nil, err
+	}
+	return filterSubIDs(entries, keys, db.path(newSubIDRow(isGroup).file), name)
+}
+
+// LookupInSubID looks up ranges of subordinate ids by the given values; of
+// group ids whether isGroup is true, else of user ids.
+//
+// The count determines the number of fields to return:
+//   n > 0: at most n fields
+//   n == 0: the result is nil (zero fields)
+//   n < 0: all fields
+func LookupInSubID(isGroup bool, field subidField, value interface{}, n int) ([]*SubID, error) {
+	return defaultDB.LookupInSubID(isGroup, field, value, n)
+}
+
+// LookupInSubID looks up ranges of subordinate ids by the given values; of
+// group ids whether isGroup is true, else of user ids.
+//
+// The count determines the number of fields to return:
+//   n > 0: at most n fields
+//   n == 0: the result is nil (zero fields)
+//   n < 0: all fields
+func (db *DB) LookupInSubID(isGroup bool, field subidField, value interface{}, n int) ([]*SubID, error) {
+	_row := newSubIDRow(isGroup)
+
+	iEntries, err := db.lookUp(_row, field, value, n)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, NoFoundError{db.path(_row.file), field.String(), value}
+		}
+		return nil, err
+	}
+
+	entries := make([]*SubID, len(iEntries.([]interface{})))
+	for i, v := range iEntries.([]interface{}) {
+		entries[i] = v.(*SubID)
+		entries[i].db = db
+	}
+	return entries, nil
+}
+
+// LookupSubUID looks up the ranges of subordinate user ids of an user, into the
+// changes staged.
+func (tx *Tx) LookupSubUID(name string) ([]*SubID, error) {
+	return tx.lookupSubIDs(false, name)
+}
+
+// LookupSubGID looks up the ranges of subordinate group ids of an user, into the
+// changes staged.
+func (tx *Tx) LookupSubGID(name string) ([]*SubID, error) {
+	return tx.lookupSubIDs(true, name)
+}
+
+// lookupSubIDs looks up the ranges of subordinate ids of an user, into the
+// changes staged.
+func (tx *Tx) lookupSubIDs(isGroup bool, name string) ([]*SubID, error) {
+	keys, err := subIDKeys(name, tx.LookupUser)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := tx.LookupInSubID(isGroup, SI_ALL, nil, -1)
+	if ignoreNoFound(err) != nil {
+		return nil, err
+	}
+	return filterSubIDs(entries, keys, tx.db.path(newSubIDRow(isGroup).file), name)
+}
+
+// LookupInSubID looks up ranges of subordinate ids by the given values, into the
+// changes staged.
+func (tx *Tx) LookupInSubID(isGroup bool, field subidField, value interface{}, n int) ([]*SubID, error) {
+	iEntries, err := tx.lookUp(newSubIDRow(isGroup), field, value, n)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]*SubID, len(iEntries))
+	for i, v := range iEntries {
+		entries[i] = v.(*SubID)
+		entries[i].db = tx.db
+	}
+	return entries, nil
+}
+
+// subIDKeys returns the keys of the ranges of subordinate ids of an user: its
+// login name, and its user id unless it is the login name of other user.
+func subIDKeys(name string, lookupUser func(string) (*User, error)) ([]string, error) {
+	keys := []string{name}
+
+	u, err := lookupUser(name)
+	if err != nil {
+		return keys, ignoreNoFound(err)
+	}
+	id := strconv.Itoa(u.UID)
+	if id == name {
+		return keys, nil
+	}
+	if _, err = lookupUser(id); err == nil {
+		return keys, nil
+	} else if ignoreNoFound(err) != nil {
+		return nil, err
+	}
+	return append(keys, id), nil
+}
+
+// filterSubIDs returns the ranges whose key is into keys, or a NoFoundError for
+// the name whether there is none.
+func filterSubIDs(entries []*SubID, keys []string, filename, name string) ([]*SubID, error) {
+	found := make([]*SubID, 0, len(entries))
+
+	for _, s := range entries {
+		for _, k := range keys {
+			if s.Name == k {
+				found = append(found, s)
+				break
+			}
+		}
+	}
+	if len(found) == 0 {
+		return nil, NoFoundError{filename, SI_NAME.String(), name}
+	}
+	return found, nil
+}
+
+// newSubIDRow returns the row used to look for ranges of subordinate ids.
+func newSubIDRow(isGroup bool) *SubID {
+	if isGroup {
+		return &SubID{file: fileSubGID}
+	}
+	return &SubID{file: fileSubUID}
+}
+
+// == Editing
+//
+
+// AddSubUID allocates a range of subordinate user ids for an user, after the
+// ranges already used, into the limits set in SUB_UID_MIN, SUB_UID_MAX and
+// SUB_UID_COUNT.
+func AddSubUID(name string) (*SubID, error) { return defaultDB.AddSubUID(name) }
+
+// AddSubUID allocates a range of subordinate user ids for an user.
+func (db *DB) AddSubUID(name string) (s *SubID, err error) {
+	err = db.update(func(tx *Tx) (err error) {
+		s, err = tx.AddSubUID(name)
+		return
+	})
+	return
+}
+
+// AddSubUID stages a new range of subordinate user ids for an user.
+func (tx *Tx) AddSubUID(name string) (*SubID, error) {
+	return tx.addSubID(false, name, nil)
+}
+
+// AddSubGID allocates a range of subordinate group ids for an user, after the
+// ranges already used, into the limits set in SUB_GID_MIN, SUB_GID_MAX and
+// SUB_GID_COUNT.
+func AddSubGID(name string) (*SubID, error) { return defaultDB.AddSubGID(name) }
+
+// AddSubGID allocates a range of subordinate group ids for an user.
+func (db *DB) AddSubGID(name string) (s *SubID, err error) {
+	err = db.update(func(tx *Tx) (err error) {
+		s, err = tx.AddSubGID(name)
+		return
+	})
+	return
+}
+
+// AddSubGID stages a new range of subordinate group ids for an user.
+func (tx *Tx) AddSubGID(name string) (*SubID, error) {
+	return tx.addSubID(true, name, nil)
+}
+
+// addSubID stages a new range of subordinate ids, allocated using the policy p
+// or the one of the database whether it is nil.
+func (tx *Tx) addSubID(isGroup bool, name string, p *Policy) (*SubID, error) {
+	if name == "" {
+		return nil, RequiredError("Name")
+	}
+	p = tx.db.policyOf(p)
+
+	s := newSubIDRow(isGroup)
+	s.Name = name
+	s.db = tx.db
+
+	min, max, count := p.SubUIDMin, p.SubUIDMax, p.SubUIDCount
+	if isGroup {
+		min, max, count = p.SubGIDMin, p.SubGIDMax, p.SubGIDCount
+	}
+
+	f, err := tx.file(s.file)
+	if err != nil {
+		return nil, err
+	}
+	if s.Start, err = nextSubID(f.lines, s.file, min, max, count); err != nil {
+		return nil, err
+	}
+	s.Count = count
+
+	if err = tx.appendRow(name, s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// nextSubID returns the first id of a free range of count ids, which does not
+// overlap with the ranges got from the lines, into the limits min and max.
+func nextSubID(lines []string, file string, min, max, count int) (int, error) {
+	ranges := make([]*SubID, 0, len(lines))
+
+	for _, line := range lines {
+		if isComment(line) {
+			continue
+		}
+		s, err := parseSubID(file, line)
+		if err != nil {
+			return 0, err
+		}
+		ranges = append(ranges, s)
+	}
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].Start < ranges[j].Start })
+
+	start := min
+	for _, r := range ranges {
+		if start+count <= r.Start {
+			break
+		}
+		if end := r.Start + r.Count; end > start {
+			start = end
+		}
+	}
+
+	if start+count-1 > max {
+		return 0, &SubIDRangeError{file, max}
+	}
+	return start, nil
+}
+
+// DelSubIDs removes all ranges of subordinate user and group ids of an user.
+func DelSubIDs(name string) error { return defaultDB.DelSubIDs(name) }
+
+// DelSubIDs removes all ranges of subordinate user and group ids of an user.
+func (db *DB) DelSubIDs(name string) error {
+	return db.update(func(tx *Tx) error { return tx.DelSubIDs(name) })
+}
+
+// DelSubIDs stages the removing of all ranges of subordinate user and group ids
+// of an user, keyed by its login name or its user id. It fails whether the user
+// has not ranges.
+func (tx *Tx) DelSubIDs(name string) error {
+	keys, err := subIDKeys(name, tx.LookupUser)
+	if err != nil {
+		return err
+	}
+
+	total := 0
+	for _, key := range keys {
+		for _, isGroup := range []bool{false, true} {
+			n, err := tx.delSubID(isGroup, key)
+			if err != nil {
+				return err
+			}
+			total += n
+		}
+	}
+
+	if total == 0 {
+		return NoFoundError{tx.db.path(fileSubUID), SI_NAME.String(), name}
+	}
+	return nil
+}
+
+// delSubID stages the removing of all ranges of subordinate ids with the key,
+// returning the number of ranges removed.
+func (tx *Tx) delSubID(isGroup bool, key string) (n int, err error) {
+	s := newSubIDRow(isGroup)
+
+	for {
+		if err = tx.del(key, s); err != nil {
+			if _, ok := err.(NoFoundError); ok {
+				return n, nil
+			}
+			return n, err
+		}
+		n++
+	}
+}
+
+// renameSubIDs stages the change of the key of all ranges of subordinate user
+// and group ids with the key oldKey.
+func (tx *Tx) renameSubIDs(oldKey, newKey string) error {
+	for _, isGroup := range []bool{false, true} {
+		entries, err := tx.LookupInSubID(isGroup, SI_NAME, oldKey, -1)
+		if err != nil {
+			if ignoreNoFound(err) != nil {
+				return err
+			}
+			continue
+		}
+
+		// Every edit replaces the first row with the old key, so the rows are
+		// replaced in order.
+		for _, s := range entries {
+			s.Name = newKey
+			if err = tx.edit(oldKey, s); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// A SubIDRangeError reports that there is not a free range of subordinate ids.
+type SubIDRangeError struct {
+	file string
+	max  int
+}
+
+func (e *SubIDRangeError) Error() string {
+	return fmt.Sprintf("no free range of subordinate ids on '%s' until %d", e.file, e.max)
+}
@@ -0,0 +1,168 @@
+// Copyright 2021 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package user
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+func TestNextSubID(t *testing.T) {
+	lines := []string{
+		"foo:100000:65536",
+		"bar:231072:65536",
+	}
+
+	start, err := nextSubID(lines, fileSubUID, 100000, 600100000, 65536)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if start != 165536 {
+		t.Errorf("expected to use the free range between others, got %d", start)
+	}
+
+	start, err = nextSubID(lines, fileSubUID, 100000, 600100000, 100000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if start != 296608 {
+		t.Errorf("expected to use the range after the last one, got %d", start)
+	}
+
+	if _, err = nextSubID(lines, fileSubUID, 100000, 200000, 65536); err == nil {
+		t.Error("expected to report SubIDRangeError")
+	}
+}
+
+func TestSubID(t *testing.T) {
+	const name, name2 = "u_subid", "u_subid2"
+
+	opts := &AddUserOptions{SubIDs: true}
+	if _, err := testDB.AddUserWithOptions(name, GID, opts); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := testDB.AddUserWithOptions(name2, GID, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	uids, err := testDB.LookupSubUID(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uids2, err := testDB.LookupSubUID(name2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(uids) != 1 || len(uids2) != 1 {
+		t.Fatalf("expected to get a range by user, got %d and %d", len(uids), len(uids2))
+	}
+	if uids[0].Start+uids[0].Count > uids2[0].Start && uids2[0].Start+uids2[0].Count > uids[0].Start {
+		t.Errorf("expected ranges not overlapped, got: %s and %s", uids[0], uids2[0])
+	}
+	if uids[0].IsGroup() {
+		t.Error("expected a range of user ids")
+	}
+
+	gids, err := testDB.LookupSubGID(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !gids[0].IsGroup() {
+		t.Error("expected a range of group ids")
+	}
+
+	if err = testDB.DelSubIDs(name2); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = testDB.LookupSubUID(name2); err == nil {
+		t.Error("expected to remove the ranges")
+	}
+	if err = testDB.DelSubIDs(name2); err == nil {
+		t.Error("expected to report NoFoundError")
+	}
+
+	// The ranges are removed together to the user.
+	if err = testDB.DelUser(name); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = testDB.LookupSubGID(name); err == nil {
+		t.Error("expected to remove the ranges of the user")
+	}
+	if err = testDB.DelUser(name2); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSubIDByUID(t *testing.T) {
+	db := newTestDB(t, map[string]string{
+		fileLogin:   "ENCRYPT_METHOD SHA512\nUID_MIN 1000\nUID_MAX 60000\nGID_MIN 1000\nGID_MAX 60000\n",
+		fileUser:    "u1:x:1000:1000::/home/u1:/bin/sh\n1000:x:1001:1000::/home/1000:/bin/sh\n",
+		fileGroup:   "g1:x:1000:\n",
+		fileShadow:  "u1:*:18000:0:99999:7:::\n1000:*:18000:0:99999:7:::\n",
+		fileGShadow: "g1:!::\n",
+		fileSubUID:  "1000:100000:65536\nu1:165536:65536\n1001:231072:65536\n",
+		fileSubGID:  "u1:100000:65536\n",
+	})
+	read := func(name string) string {
+		data, err := ioutil.ReadFile(db.path(name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+
+	// The user id 1000 is the login name of other user.
+	if uids, err := db.LookupSubUID("u1"); err != nil || len(uids) != 1 {
+		t.Errorf("expected only the range keyed by the name, got: %v, %v", uids, err)
+	}
+	if err := db.DelUser("1000"); err != nil {
+		t.Fatal(err)
+	}
+	if data := read(fileSubUID); data != "u1:165536:65536\n" {
+		t.Errorf("expected to remove the ranges of the name and the user id:\n%s", data)
+	}
+
+	if err := ioutil.WriteFile(db.path(fileSubUID), []byte("1000:100000:65536\nu1:165536:65536\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if uids, err := db.LookupSubUID("u1"); err != nil || len(uids) != 2 || uids[0].Name != "1000" {
+		t.Errorf("expected the ranges keyed by the name and the user id, got: %v, %v", uids, err)
+	}
+
+	// Renamed
+	name, uid := "u2", 1005
+	if err := db.ModUser("u1", &UserMod{Name: &name, UID: &uid}); err != nil {
+		t.Fatal(err)
+	}
+	if data := read(fileSubUID); data != "1005:100000:65536\nu2:165536:65536\n" {
+		t.Errorf("expected to rename the ranges:\n%s", data)
+	}
+	if data := read(fileSubGID); data != "u2:100000:65536\n" {
+		t.Errorf("expected to rename the ranges:\n%s", data)
+	}
+	if gids, err := db.LookupSubGID("u1"); err == nil {
+		t.Errorf("expected to not find the old name, got: %v", gids)
+	}
+
+	// Removed
+	if err := db.DelUser("u2"); err != nil {
+		t.Fatal(err)
+	}
+	if data := read(fileSubUID) + read(fileSubGID); data != "" {
+		t.Errorf("expected to remove all ranges:\n%s", data)
+	}
+
+	// Row truncated
+	if err := ioutil.WriteFile(db.path(fileSubUID), []byte("u1:100000\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.LookupSubUID("u1"); err == nil {
+		t.Error("expected to report the row malformed")
+	} else if _, ok := err.(rowError); !ok {
+		t.Errorf("expected rowError, got: %v", err)
+	}
+}
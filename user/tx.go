@@ -0,0 +1,437 @@
+// Copyright 2021 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package user
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// ErrTxDone is returned by any operation of a transaction which has already
+// been committed or rolled back.
+var ErrTxDone = errors.New("transaction already committed or rolled back")
+
+// A Tx represents a transaction to edit the files of a database.
+//
+// The changes are staged in memory, and they are written only at calling
+// Commit, after of validating them together; so a change done in several
+// steps either fully succeeds or it leaves the files like they were.
+// The files are locked until the transaction is finished.
+type Tx struct {
+	db    *DB
+	lk    *dbLock
+	files map[string]*txFile
+	done  bool
+
+	// onlyEntry is set when an entry is added into a file without its pair in
+	// the shadowed file (or vice versa), so the files are not checked among them.
+	onlyEntry bool
+
+	// afterCommit are the actions to run once the files have been written, to
+	// handle the files of the accounts like the home directories.
+	afterCommit []func() error
+}
+
+// A txFile represents the content of a file edited into a transaction.
+type txFile struct {
+	name    string // Relative to the root directory.
+	orig    []byte
+	lines   []string
+	missing bool // The file did not exist.
+
+	changed bool
+	added   []string // Name of entries added.
+	edited  []string // Name of entries edited.
+	removed []string // Name of entries removed.
+}
+
+// commitOrder is the order to replace the files at committing, so a failure in
+// the middle only could leave shadowed entries without their public one.
+var commitOrder = []string{
+	fileSubGID, fileSubUID, fileGShadow, fileShadow, fileGroup, fileUser,
+}
+
+// optionalFiles are the files which are created at committing whether they do
+// not exist.
+var optionalFiles = map[string]bool{fileSubUID: true, fileSubGID: true}
+
+// Begin starts a transaction on the database of the system.
+func Begin() (*Tx, error) { return defaultDB.Begin() }
+
+// Begin starts a transaction, locking the files of the database.
+// The transaction has to be finished calling either Commit or Rollback.
+//
+// While it is open, the methods of DB and the functions of the package which
+// edit a database must not be called, since they wait for the locks held by the
+// transaction, until returning ErrLocked; the methods of Tx have to be used.
+// It returns the error got at loading the configuration of the database.
+func (db *DB) Begin() (*Tx, error) {
+	if err := db.initConfig(); err != nil {
+		return nil, err
+	}
+
+	lk, err := db.lock(fileUser, fileGroup, fileShadow, fileGShadow, fileSubUID, fileSubGID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Tx{
+		db:    db,
+		lk:    lk,
+		files: make(map[string]*txFile, len(commitOrder)),
+	}, nil
+}
+
+// update runs fn into a transaction, which is committed whether fn returns no
+// error, else it is rolled back.
+// The locks are released even if fn panics.
+func (db *DB) update(fn func(tx *Tx) error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if !tx.done {
+			tx.Rollback()
+		}
+	}()
+
+	if err = fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// updateEntry is like update but for the methods that edit an only entry.
+func (db *DB) updateEntry(fn func(tx *Tx) error) error {
+	return db.update(func(tx *Tx) error {
+		tx.onlyEntry = true
+		return fn(tx)
+	})
+}
+
+// Rollback discards the changes staged, and releases the locks.
+func (tx *Tx) Rollback() error {
+	if tx.done {
+		return ErrTxDone
+	}
+	tx.done = true
+	return tx.lk.unlock()
+}
+
+// Commit validates the changes staged, and writes all files edited.
+// Whether some file could not be written, the files already replaced are
+// restored.
+//
+// The actions done after of writing the files, like creating or removing the
+// home directories, do not undo the changes of the files whether they fail;
+// then it returns a PostCommitError, and the changes of the database stand.
+func (tx *Tx) Commit() (err error) {
+	if tx.done {
+		return ErrTxDone
+	}
+
+	// The hook of audit is called once the locks are released, so it can use
+	// the database.
+	var events []*AuditEvent
+	defer func() {
+		if len(events) != 0 {
+			tx.db.audit(events)
+		}
+	}()
+	defer func() {
+		tx.done = true
+		e := tx.lk.unlock()
+		if e != nil && err == nil {
+			err = e
+		}
+	}()
+
+	if err = tx.validate(); err != nil {
+		return err
+	}
+
+	// Write the new files, still without replacing the original ones.
+	tmpFiles := make(map[string]string, len(tx.files))
+	defer func() {
+		for _, tmpName := range tmpFiles {
+			os.Remove(tmpName)
+		}
+	}()
+
+	for _, name := range commitOrder {
+		f := tx.files[name]
+		if f == nil || !f.changed {
+			continue
+		}
+		filename := tx.db.path(name)
+
+		if !f.missing {
+			if err = backup(filename); err != nil {
+				return err
+			}
+		}
+		if tmpFiles[name], err = writeTemp(filename, f.bytes()); err != nil {
+			return err
+		}
+	}
+
+	// Replace the files.
+	replaced := make([]string, 0, len(tmpFiles))
+
+	for _, name := range commitOrder {
+		tmpName, ok := tmpFiles[name]
+		if !ok {
+			continue
+		}
+		if err = os.Rename(tmpName, tx.db.path(name)); err != nil {
+			for _, name := range replaced {
+				if tx.files[name].missing {
+					os.Remove(tx.db.path(name))
+				} else {
+					writeFile(tx.db.path(name), tx.files[name].orig)
+				}
+			}
+			return err
+		}
+		delete(tmpFiles, name)
+		replaced = append(replaced, name)
+	}
+
+	if len(replaced) != 0 {
+		if err = syncDir(tx.db.path("/etc")); err != nil {
+			return err
+		}
+		if tx.db.audit != nil {
+			events = tx.Events()
+		}
+	}
+
+	// The files are already written, so the rest of actions are run although
+	// one fails.
+	for _, fn := range tx.afterCommit {
+		if e := fn(); e != nil && err == nil {
+			err = PostCommitError{e}
+		}
+	}
+	return err
+}
+
+// file returns the content of the named file, loading it at the first use.
+func (tx *Tx) file(name string) (*txFile, error) {
+	if tx.done {
+		return nil, ErrTxDone
+	}
+	if f, ok := tx.files[name]; ok {
+		return f, nil
+	}
+
+	data, err := ioutil.ReadFile(tx.db.path(name))
+	if err != nil {
+		if !os.IsNotExist(err) || !optionalFiles[name] {
+			return nil, err
+		}
+	}
+
+	f := &txFile{name: name, orig: data, lines: splitLines(data), missing: err != nil}
+	tx.files[name] = f
+	return f, nil
+}
+
+// bytes returns the content of the file.
+func (f *txFile) bytes() []byte {
+	if len(f.lines) == 0 {
+		return []byte{}
+	}
+	return []byte(strings.Join(f.lines, "\n") + "\n")
+}
+
+// index returns the position of the line which stores the entry with the given
+// name, or -1 whether it is not found.
+func (f *txFile) index(name string) int {
+	prefix := name + ":"
+
+	for i, line := range f.lines {
+		if strings.HasPrefix(line, prefix) {
+			return i
+		}
+	}
+	return -1
+}
+
+// lookUp looks for a value into the lines staged of the file of the row, like
+// the lookUp of DB.
+func (tx *Tx) lookUp(_row row, _field field, value interface{}, n int) ([]interface{}, error) {
+	if n == 0 {
+		return nil, errSearch
+	}
+
+	f, err := tx.file(_row.filename())
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]interface{}, 0, 0)
+
+	for _, line := range f.lines {
+		if isComment(line) {
+			continue
+		}
+
+		entry, err := _row.lookUp(line, _field, value)
+		if err != nil {
+			return nil, err
+		}
+		if entry != nil {
+			entries = append(entries, entry)
+		}
+
+		if n > 0 && n == len(entries) {
+			break
+		}
+	}
+
+	if len(entries) != 0 {
+		return entries, nil
+	}
+	return nil, NoFoundError{tx.db.path(f.name), _field.String(), value}
+}
+
+// appendRow stages the row at the end of its file, but before of the entries of
+// NIS since the ones placed after them would be hidden, like shadow-utils does.
+func (tx *Tx) appendRow(name string, _row row) error {
+	f, err := tx.file(_row.filename())
+	if err != nil {
+		return err
+	}
+
+	i := len(f.lines)
+	for j, line := range f.lines {
+		if isNIS(line) {
+			i = j
+			break
+		}
+	}
+	f.lines = append(f.lines, "")
+	copy(f.lines[i+1:], f.lines[i:])
+	f.lines[i] = strings.TrimSuffix(_row.String(), "\n")
+	f.added = append(f.added, name)
+	f.changed = true
+	return nil
+}
+
+// edit stages the row which replaces the one of the given user/group name.
+func (tx *Tx) edit(name string, _row row) error { return tx._edit(name, _row, false) }
+
+// del stages the removing of the row of the given user/group name.
+func (tx *Tx) del(name string, _row row) error { return tx._edit(name, _row, true) }
+
+func (tx *Tx) _edit(name string, _row row, remove bool) error {
+	f, err := tx.file(_row.filename())
+	if err != nil {
+		return err
+	}
+
+	i := f.index(name)
+	if i == -1 {
+		return NoFoundError{tx.db.path(f.name), "Name", name}
+	}
+
+	if remove {
+		f.lines = append(f.lines[:i], f.lines[i+1:]...)
+		f.removed = append(f.removed, name)
+	} else {
+		f.lines[i] = strings.TrimSuffix(_row.String(), "\n")
+		f.edited = append(f.edited, name)
+	}
+	f.changed = true
+	return nil
+}
+
+// ignoreNoFound returns nil whether the error reports that an entry or its file
+// is not found. It is used to remove the entries of the shadowed files, which
+// could not exist.
+func ignoreNoFound(err error) error {
+	if _, ok := err.(NoFoundError); ok || os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// == Validation
+//
+
+// validate checks that the changes staged keep the files consistent among them.
+func (tx *Tx) validate() error {
+	// Pairs of files where every entry added or removed in the first one has to
+	// be added or removed in the second one too.
+	pairs := [][2]string{
+		{fileUser, fileShadow},
+		{fileShadow, fileUser},
+		{fileGroup, fileGShadow},
+		{fileGShadow, fileGroup},
+	}
+
+	for _, f := range tx.files {
+		// An user can have several ranges of subordinate ids.
+		if !f.changed || f.name == fileSubUID || f.name == fileSubGID {
+			continue
+		}
+		for _, name := range append(f.added, f.edited...) {
+			if f.count(name) > 1 {
+				return TxError{tx.db.path(f.name), name, "duplicated entry"}
+			}
+		}
+	}
+
+	if tx.onlyEntry {
+		return nil
+	}
+
+	for _, pair := range pairs {
+		f := tx.files[pair[0]]
+		if f == nil || !f.changed {
+			continue
+		}
+
+		other, err := tx.file(pair[1])
+		if err != nil {
+			if os.IsNotExist(err) { // Shadowed files are optional.
+				continue
+			}
+			return err
+		}
+
+		for _, name := range f.added {
+			if f.index(name) != -1 && other.index(name) == -1 {
+				return TxError{tx.db.path(other.name), name, "entry not added"}
+			}
+		}
+		for _, name := range f.removed {
+			if other.index(name) != -1 {
+				return TxError{tx.db.path(other.name), name, "entry not removed"}
+			}
+		}
+	}
+
+	return nil
+}
+
+// count returns the number of lines which store the entry with the given name.
+func (f *txFile) count(name string) int {
+	prefix := name + ":"
+	n := 0
+
+	for _, line := range f.lines {
+		if strings.HasPrefix(line, prefix) {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,260 @@
+// Copyright 2021 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package user
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+const (
+	TX_USER  = "u_tx"
+	TX_GROUP = "g_tx"
+)
+
+func TestTxCommit(t *testing.T) {
+	tx, err := testDB.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gid, err := tx.AddGroup(TX_GROUP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = tx.AddUser(TX_USER, gid); err != nil {
+		t.Fatal(err)
+	}
+	if err = tx.AddUsersToGroup(TX_GROUP, TX_USER); err != nil {
+		t.Fatal(err)
+	}
+	if err = tx.ChPasswd(TX_USER, userKey1); err != nil {
+		t.Fatal(err)
+	}
+
+	// The changes are not written until the commit.
+	if _, err = testDB.LookupUser(TX_USER); err == nil {
+		t.Fatal("expected to stage the user, not to write it")
+	}
+	if _, err = tx.LookupUser(TX_USER); err != nil {
+		t.Fatalf("expected to find the user staged: %s", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err = tx.Commit(); err != ErrTxDone {
+		t.Error("expected to report ErrTxDone")
+	}
+
+	if _, err = testDB.LookupUser(TX_USER); err != nil {
+		t.Error(err)
+	}
+	if _, err = testDB.LookupShadow(TX_USER); err != nil {
+		t.Error(err)
+	}
+	g, err := testDB.LookupGroup(TX_GROUP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !checkGroup(g.UserList, TX_USER) {
+		t.Errorf("expected to get member %q", TX_USER)
+	}
+
+	if err = testDB.DelUser(TX_USER); err != nil {
+		t.Error(err)
+	}
+	if err = testDB.DelGroup(TX_GROUP); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestTxRollback(t *testing.T) {
+	tx, err := testDB.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gid, err := tx.AddGroup(TX_GROUP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = tx.AddUser(TX_USER, gid); err != nil {
+		t.Fatal(err)
+	}
+	// The user does not exist, so the transaction has to be rolled back.
+	if err = tx.ChPasswd("!!!???", userKey1); err == nil {
+		t.Fatal("expected to report NoFoundError")
+	}
+	if err = tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = testDB.LookupGroup(TX_GROUP); err == nil {
+		t.Error("group: expected to discard the changes")
+	}
+	if _, err = testDB.LookupUser(TX_USER); err == nil {
+		t.Error("user: expected to discard the changes")
+	}
+}
+
+func TestTxValidate(t *testing.T) {
+	tx, err := testDB.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An user without its shadowed entry.
+	if _, err = tx.addUser(testDB.NewUser(TX_USER, 0), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err = tx.Commit(); err == nil {
+		t.Fatal("expected to report TxError")
+	} else if _, ok := err.(TxError); !ok {
+		t.Fatalf("expected to report TxError, got: %s", err)
+	}
+
+	if _, err = testDB.LookupUser(TX_USER); err == nil {
+		t.Error("expected to discard the changes")
+	}
+}
+
+func TestTxRoundTrip(t *testing.T) {
+	const (
+		userData = "# local accounts\n" +
+			"root:x:0:0:root:/root:/bin/sh\n" +
+			"bobby:x:1001:1001::/home/bobby:/bin/sh\n" +
+			"\n" +
+			"bob:x:1000:1000::/home/bob:/bin/sh\n" +
+			"+bob::::::\n" +
+			"+@admins::::::\n" +
+			"-@guests::::::\n" +
+			"+::::::\n"
+		groupData = "root:x:0:\n" +
+			"bobby:x:1001:\n" +
+			"# staff\n" +
+			"bob:x:1000:bobby\n" +
+			"+:::\n"
+	)
+
+	db := newTestDB(t, map[string]string{
+		fileLogin:   "ENCRYPT_METHOD SHA512\nGID_MIN 1000\nGID_MAX 60000\n",
+		fileUser:    userData,
+		fileGroup:   groupData,
+		fileShadow:  "root:*:18000:0:99999:7:::\nbobby:*:18000:0:99999:7:::\nbob:*:18000:0:99999:7:::\n",
+		fileGShadow: "root:!::\nbobby:!::\n# staff\nbob:!::bobby\n",
+	})
+
+	if u, err := db.LookupUser("bob"); err != nil {
+		t.Fatal(err)
+	} else if u.UID != 1000 {
+		t.Fatalf("expected the exact name to match, got %v", u)
+	}
+
+	err := db.update(func(tx *Tx) error {
+		gecos := "Bob"
+		if err := tx.ModUser("bob", &UserMod{Gecos: &gecos}); err != nil {
+			return err
+		}
+		if err := tx.DelUsersInGroup("bob", "bobby"); err != nil {
+			return err
+		}
+		_, err := tx.AddGroup("carol")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		fileUser: "# local accounts\n" +
+			"root:x:0:0:root:/root:/bin/sh\n" +
+			"bobby:x:1001:1001::/home/bobby:/bin/sh\n" +
+			"\n" +
+			"bob:x:1000:1000:Bob:/home/bob:/bin/sh\n" +
+			"+bob::::::\n" +
+			"+@admins::::::\n" +
+			"-@guests::::::\n" +
+			"+::::::\n",
+		fileGroup: "root:x:0:\n" +
+			"bobby:x:1001:\n" +
+			"# staff\n" +
+			"bob:x:1000:\n" +
+			"carol:x:1002:\n" +
+			"+:::\n",
+		fileGShadow: "root:!::\n" +
+			"bobby:!::\n" +
+			"# staff\n" +
+			"bob:!::\n" +
+			"carol:*::\n",
+	}
+	for name, data := range expected {
+		got, err := ioutil.ReadFile(db.path(name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != data {
+			t.Errorf("%s: expected\n%s\ngot\n%s", name, data, got)
+		}
+	}
+}
+
+func TestTxPanic(t *testing.T) {
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("expected to panic")
+			}
+		}()
+		testDB.update(func(tx *Tx) error { panic("fail") })
+	}()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- testDB.update(func(tx *Tx) error { return nil })
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected to release the locks after a panic")
+	}
+}
+
+func TestTxLockedInProcess(t *testing.T) {
+	db := newTestDB(t, map[string]string{
+		fileLogin:   "ENCRYPT_METHOD SHA512\n",
+		fileUser:    "u1:x:1000:1000::/home/u1:/bin/sh\n",
+		fileGroup:   "g1:x:1000:\n",
+		fileShadow:  "u1:*:18000:0:99999:7:::\n",
+		fileGShadow: "g1:!::\n",
+	})
+	defer func(d time.Duration) { lockTimeout = d }(lockTimeout)
+	lockTimeout = 200 * time.Millisecond
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A method of DB into an open transaction.
+	if err = db.AddUsersToGroup("g1", "u1"); err != ErrLocked {
+		t.Errorf("expected ErrLocked, got: %v", err)
+	}
+	if _, err = db.Begin(); err != ErrLocked {
+		t.Errorf("expected ErrLocked, got: %v", err)
+	}
+	if err = tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = db.AddUsersToGroup("g1", "u1"); err != nil {
+		t.Errorf("expected to get the locks after the rollback: %v", err)
+	}
+}
@@ -96,24 +96,41 @@ type User struct {
 	Shell string
 
 	addSystemUser bool
+
+	db *DB // Database where the user is stored.
 }
 
 // NewUser returns a new User with both fields "Dir" and "Shell" got from
 // the system configuration.
-func NewUser(name string, gid int) *User {
-	loadConfig()
+func NewUser(name string, gid int) *User { return defaultDB.NewUser(name, gid) }
+
+// NewUser returns a new User with both fields "Dir" and "Shell" got from
+// the configuration of the database.
+func (db *DB) NewUser(name string, gid int) *User { return db.newUser(name, gid, nil) }
+
+// newUser returns a new User with both fields "Dir" and "Shell" got from the
+// policy p, or from the one of the database whether it is nil.
+func (db *DB) newUser(name string, gid int, p *Policy) *User {
+	p = db.policyOf(p)
 
 	return &User{
 		Name:  name,
-		Dir:   path.Join(config.useradd.HOME, name),
-		Shell: config.useradd.SHELL,
+		Dir:   path.Join(p.Home, name),
+		Shell: p.Shell,
 		UID:   -1,
 		GID:   gid,
+
+		db: db,
 	}
 }
 
 // NewSystemUser returns a new system user.
 func NewSystemUser(name, homeDir string, gid int) *User {
+	return defaultDB.NewSystemUser(name, homeDir, gid)
+}
+
+// NewSystemUser returns a new system user to add into the database.
+func (db *DB) NewSystemUser(name, homeDir string, gid int) *User {
 	return &User{
 		Name:  name,
 		Dir:   homeDir,
@@ -122,16 +139,21 @@ func NewSystemUser(name, homeDir string, gid int) *User {
 		GID:   gid,
 
 		addSystemUser: true,
+		db:            db,
 	}
 }
 
 func (u *User) filename() string { return fileUser }
 
-// IsOfSystem indicates whether it is a system user.
+// IsOfSystem indicates whether it is a system user, whose id is into the range
+// set by SYS_UID_MIN and SYS_UID_MAX, both included like in "useradd(8)".
 func (u *User) IsOfSystem() bool {
-	//loadConfig()
+	db := dbOf(u.db)
+	if db.initConfig() != nil {
+		return false
+	}
 
-	if u.UID > config.login.SYS_UID_MIN && u.UID < config.login.SYS_UID_MAX {
+	if u.UID >= db.config.policy.SysUIDMin && u.UID <= db.config.policy.SysUIDMax {
 		return true
 	}
 	return false
@@ -174,57 +196,46 @@ func parseUser(row string) (*User, error) {
 
 // lookUp parses the user line searching a value into the field.
 // Returns nil if is not found.
-func (*User) lookUp(line string, f field, value interface{}) interface{} {
+func (*User) lookUp(line string, f field, value interface{}) (interface{}, error) {
 	_field := f.(userField)
-	allField := strings.Split(line, ":")
-	intField := make(map[int]int)
 
-	// Check integers
-	var err error
-	if intField[2], err = strconv.Atoi(allField[2]); err != nil {
-		panic(atoiError{fileUser, line, "UID"})
-	}
-	if intField[3], err = strconv.Atoi(allField[3]); err != nil {
-		panic(atoiError{fileUser, line, "GID"})
+	u, err := parseUser(line)
+	if err != nil {
+		return nil, err
 	}
 
 	// Check fields
 	var isField bool
-	if U_NAME&_field != 0 && allField[0] == value.(string) {
+	if U_NAME&_field != 0 && u.Name == value.(string) {
 		isField = true
-	} else if U_PASSWD&_field != 0 && allField[1] == value.(string) {
+	} else if U_PASSWD&_field != 0 && u.password == value.(string) {
 		isField = true
-	} else if U_UID&_field != 0 && intField[2] == value.(int) {
+	} else if U_UID&_field != 0 && u.UID == value.(int) {
 		isField = true
-	} else if U_GID&_field != 0 && intField[3] == value.(int) {
+	} else if U_GID&_field != 0 && u.GID == value.(int) {
 		isField = true
-	} else if U_GECOS&_field != 0 && allField[4] == value.(string) {
+	} else if U_GECOS&_field != 0 && u.Gecos == value.(string) {
 		isField = true
-	} else if U_DIR&_field != 0 && allField[5] == value.(string) {
+	} else if U_DIR&_field != 0 && u.Dir == value.(string) {
 		isField = true
-	} else if U_SHELL&_field != 0 && allField[6] == value.(string) {
+	} else if U_SHELL&_field != 0 && u.Shell == value.(string) {
 		isField = true
 	} else if U_ALL&_field != 0 {
 		isField = true
 	}
 
 	if isField {
-		return &User{
-			Name:     allField[0],
-			password: allField[1],
-			UID:      intField[2],
-			GID:      intField[3],
-			Gecos:    allField[4],
-			Dir:      allField[5],
-			Shell:    allField[6],
-		}
+		return u, nil
 	}
-	return nil
+	return nil, nil
 }
 
 // LookupUID looks up an user by user ID.
-func LookupUID(uid int) (*User, error) {
-	entries, err := LookupInUser(U_UID, uid, 1)
+func LookupUID(uid int) (*User, error) { return defaultDB.LookupUID(uid) }
+
+// LookupUID looks up an user by user ID.
+func (db *DB) LookupUID(uid int) (*User, error) {
+	entries, err := db.LookupInUser(U_UID, uid, 1)
 	if err != nil {
 		return nil, err
 	}
@@ -233,8 +244,11 @@ func LookupUID(uid int) (*User, error) {
 }
 
 // LookupUser looks up an user by name.
-func LookupUser(name string) (*User, error) {
-	entries, err := LookupInUser(U_NAME, name, 1)
+func LookupUser(name string) (*User, error) { return defaultDB.LookupUser(name) }
+
+// LookupUser looks up an user by name.
+func (db *DB) LookupUser(name string) (*User, error) {
+	entries, err := db.LookupInUser(U_NAME, name, 1)
 	if err != nil {
 		return nil, err
 	}
@@ -249,7 +263,17 @@ func LookupUser(name string) (*User, error) {
 //   n == 0: the result is nil (zero fields)
 //   n < 0: all fields
 func LookupInUser(field userField, value interface{}, n int) ([]*User, error) {
-	iEntries, err := lookUp(&User{}, field, value, n)
+	return defaultDB.LookupInUser(field, value, n)
+}
+
+// LookupInUser looks up an user by the given values.
+//
+// The count determines the number of fields to return:
+//   n > 0: at most n fields
+//   n == 0: the result is nil (zero fields)
+//   n < 0: all fields
+func (db *DB) LookupInUser(field userField, value interface{}, n int) ([]*User, error) {
+	iEntries, err := db.lookUp(&User{}, field, value, n)
 	if err != nil {
 		return nil, err
 	}
@@ -260,11 +284,47 @@ func LookupInUser(field userField, value interface{}, n int) ([]*User, error) {
 
 	for i := 0; i < valueSlice.Len(); i++ {
 		entries[i] = valueSlice.Index(i).Interface().(*User)
+		entries[i].db = db
 	}
 
 	return entries, err
 }
 
+// LookupUID looks up an user by user ID, into the changes staged.
+func (tx *Tx) LookupUID(uid int) (*User, error) {
+	entries, err := tx.LookupInUser(U_UID, uid, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	return entries[0], err
+}
+
+// LookupUser looks up an user by name, into the changes staged.
+func (tx *Tx) LookupUser(name string) (*User, error) {
+	entries, err := tx.LookupInUser(U_NAME, name, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	return entries[0], err
+}
+
+// LookupInUser looks up an user by the given values, into the changes staged.
+func (tx *Tx) LookupInUser(field userField, value interface{}, n int) ([]*User, error) {
+	iEntries, err := tx.lookUp(&User{}, field, value, n)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]*User, len(iEntries))
+	for i, v := range iEntries {
+		entries[i] = v.(*User)
+		entries[i].db = tx.db
+	}
+	return entries, nil
+}
+
 // GetUsername returns the user name from the password database for the actual
 // process.
 // It panics whther there is an error at searching the UID.
@@ -295,31 +355,211 @@ func GetUsernameFromEnv() string {
 
 // AddUser adds an user to both user and shadow files.
 func AddUser(name string, gid int) (uid int, err error) {
-	s := NewShadow(name)
-	if err = s.Add(nil); err != nil {
+	return defaultDB.AddUser(name, gid)
+}
+
+// AddUser adds an user to both user and shadow files.
+func (db *DB) AddUser(name string, gid int) (uid int, err error) {
+	err = db.update(func(tx *Tx) (err error) {
+		uid, err = tx.AddUser(name, gid)
+		return
+	})
+	return
+}
+
+// AddUser stages an user to add to both user and shadow files.
+// The home directory is created whether the policy sets CreateHome.
+func (tx *Tx) AddUser(name string, gid int) (uid int, err error) {
+	return tx.AddUserWithOptions(name, gid, nil)
+}
+
+// AddUserOptions represents the options to add an user.
+type AddUserOptions struct {
+	// CreateHome creates the home directory with the files of the skeleton
+	// directory, like "useradd -m".
+	// When the options are nil, it is got from the policy (CREATE_HOME).
+	CreateHome bool
+
+	// SubIDs allocates ranges of subordinate user and group ids, to be used in
+	// user namespaces.
+	SubIDs bool
+
+	// UserGroup creates a group with the same name of the user to be its
+	// primary group, like "useradd -U"; so the gid has to be negative.
+	// It is also done when the gid is negative and the policy has set
+	// UserGroupsEnab.
+	UserGroup bool
+
+	// Policy overrides the policy of the database for this call.
+	Policy *Policy
+}
+
+// AddUserWithOptions adds an user to both user and shadow files, handling the
+// given options.
+func AddUserWithOptions(name string, gid int, opts *AddUserOptions) (uid int, err error) {
+	return defaultDB.AddUserWithOptions(name, gid, opts)
+}
+
+// AddUserWithOptions adds an user to both user and shadow files, handling the
+// given options.
+func (db *DB) AddUserWithOptions(name string, gid int, opts *AddUserOptions) (uid int, err error) {
+	err = db.update(func(tx *Tx) (err error) {
+		uid, err = tx.AddUserWithOptions(name, gid, opts)
+		return
+	})
+	return
+}
+
+// AddUserWithOptions stages an user to add to both user and shadow files,
+// handling the given options.
+// The home directory is created once the transaction is committed.
+func (tx *Tx) AddUserWithOptions(name string, gid int, opts *AddUserOptions) (uid int, err error) {
+	// Without options, the home directory is created like the policy sets it.
+	if opts == nil {
+		opts = &AddUserOptions{CreateHome: tx.db.policyOf(nil).CreateHome}
+	}
+	p := tx.db.policyOf(opts.Policy)
+
+	userGroup := opts.UserGroup || (gid < 0 && p.UserGroupsEnab)
+	if userGroup && gid >= 0 {
+		return 0, ErrUserGroup
+	}
+
+	// The primary group is got from the policy when it is not set.
+	if !userGroup && gid < 0 && p.Group != "" {
+		if gid, err = tx.lookupGroupID(p.Group); err != nil {
+			return
+		}
+	}
+
+	if err = tx.addShadow(tx.db.newShadow(name, p), nil); err != nil {
+		return
+	}
+
+	u := tx.db.newUser(name, gid, p)
+	if userGroup {
+		if u.UID, u.GID, err = tx.addUserGroup(name, p); err != nil {
+			return
+		}
+	}
+	if uid, err = tx.addUser(u, p); err != nil {
 		return
 	}
 
-	return NewUser(name, gid).Add()
+	if opts.SubIDs {
+		if _, err = tx.addSubID(false, name, p); err != nil {
+			return
+		}
+		if _, err = tx.addSubID(true, name, p); err != nil {
+			return
+		}
+	}
+
+	if opts.CreateHome {
+		if err = tx.db.checkHomes(u.Dir); err != nil {
+			return
+		}
+		tx.afterCommit = append(tx.afterCommit, func() error {
+			return tx.db.createHome(u, p)
+		})
+	}
+	return uid, nil
+}
+
+// addUserGroup stages the group private of a new user, returning the ids to
+// use for the user and the group; they are the same one whether it is free in
+// both files.
+func (tx *Tx) addUserGroup(name string, p *Policy) (uid, gid int, err error) {
+	if _, err = tx.LookupGroup(name); err == nil {
+		return 0, 0, ErrGroupExist
+	} else if _, ok := err.(NoFoundError); !ok {
+		return 0, 0, err
+	}
+
+	fUser, err := tx.file(fileUser)
+	if err != nil {
+		return 0, 0, err
+	}
+	fGroup, err := tx.file(fileGroup)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if uid, err = p.nextUID(fUser.lines, false, name, -1); err != nil {
+		return 0, 0, err
+	}
+	if gid, err = p.nextGUID(fGroup.lines, false, name, uid); err != nil {
+		return 0, 0, err
+	}
+	if gid != uid {
+		if id, err := p.nextUID(fUser.lines, false, name, gid); err == nil && id == gid {
+			uid = gid
+		}
+	}
+
+	if err = tx.addGShadow(tx.db.NewGShadow(name), nil); err != nil {
+		return 0, 0, err
+	}
+	g := tx.db.NewGroup(name)
+	g.GID = gid
+	if _, err = tx.addGroup(g); err != nil {
+		return 0, 0, err
+	}
+	return uid, gid, nil
+}
+
+// lookupGroupID returns the id of the group given by its name or by its id.
+func (tx *Tx) lookupGroupID(group string) (int, error) {
+	if gid, err := strconv.Atoi(group); err == nil {
+		return gid, nil
+	}
+	g, err := tx.LookupGroup(group)
+	if err != nil {
+		return 0, err
+	}
+	return g.GID, nil
 }
 
 // AddSystemUser adds a system user to both user and shadow files.
 func AddSystemUser(name, homeDir string, gid int) (uid int, err error) {
-	s := NewShadow(name)
-	if err = s.Add(nil); err != nil {
+	return defaultDB.AddSystemUser(name, homeDir, gid)
+}
+
+// AddSystemUser adds a system user to both user and shadow files.
+func (db *DB) AddSystemUser(name, homeDir string, gid int) (uid int, err error) {
+	err = db.update(func(tx *Tx) (err error) {
+		uid, err = tx.AddSystemUser(name, homeDir, gid)
+		return
+	})
+	return
+}
+
+// AddSystemUser stages a system user to add to both user and shadow files.
+func (tx *Tx) AddSystemUser(name, homeDir string, gid int) (uid int, err error) {
+	if err = tx.addShadow(tx.db.NewShadow(name), nil); err != nil {
 		return
 	}
 
-	return NewSystemUser(name, homeDir, gid).Add()
+	return tx.addUser(tx.db.NewSystemUser(name, homeDir, gid), nil)
 }
 
 // Add adds a new user.
 // Whether UID is < 0, it will choose the first id available in the range set
 // in the system configuration.
 func (u *User) Add() (uid int, err error) {
-	loadConfig()
+	err = dbOf(u.db).updateEntry(func(tx *Tx) (err error) {
+		uid, err = tx.addUser(u, nil)
+		return
+	})
+	return
+}
+
+// addUser stages a new user, using the policy p or the one of the database
+// whether it is nil.
+func (tx *Tx) addUser(u *User, p *Policy) (uid int, err error) {
+	p = tx.db.policyOf(p)
 
-	user, err := LookupUser(u.Name)
+	user, err := tx.LookupUser(u.Name)
 	if err != nil {
 		if _, ok := err.(NoFoundError); !ok {
 			return
@@ -329,59 +569,355 @@ func (u *User) Add() (uid int, err error) {
 		return 0, ErrUserExist
 	}
 
-	if u.Name == "" {
-		return 0, RequiredError("Name")
+	if err = p.ValidateName(u.Name); err != nil {
+		return 0, err
 	}
 	if u.Dir == "" {
 		return 0, RequiredError("Dir")
 	}
-	if u.Dir == config.useradd.HOME {
-		return 0, HomeError(config.useradd.HOME)
+	if u.Dir == p.Home {
+		return 0, HomeError(p.Home)
 	}
 	if u.Shell == "" {
 		return 0, RequiredError("Shell")
 	}
 
-	var db *dbfile
 	if u.UID < 0 {
-		db, uid, err = nextUID(u.addSystemUser)
+		f, err := tx.file(fileUser)
 		if err != nil {
-			db.close()
 			return 0, err
 		}
-		u.UID = uid
-	} else {
-		db, err = openDBFile(fileUser, os.O_WRONLY|os.O_APPEND)
-		if err != nil {
+		prefer := -1
+		if p.PreferSameID {
+			prefer = u.GID
+		}
+		if uid, err = p.nextUID(f.lines, u.addSystemUser, u.Name, prefer); err != nil {
 			return 0, err
 		}
-
+		u.UID = uid
+	} else {
 		// Check if Id is unique.
-		_, err = LookupUID(u.UID)
+		_, err = tx.LookupUID(u.UID)
 		if err == nil {
 			return 0, IdUsedError(u.UID)
 		} else if _, ok := err.(NoFoundError); !ok {
 			return 0, err
 		}
+		uid = u.UID
 	}
 
 	u.password = "x"
 
-	_, err = db.file.WriteString(u.String())
-	err2 := db.close()
-	if err2 != nil && err == nil {
-		err = err2
+	if err = tx.appendRow(u.Name, u); err != nil {
+		return 0, err
 	}
-	return
+	return uid, nil
 }
 
 // DelUser removes an user from the system.
-func DelUser(name string) (err error) {
-	err = del(name, &User{})
+func DelUser(name string) error { return defaultDB.DelUser(name) }
+
+// DelUser removes an user from the database.
+func (db *DB) DelUser(name string) error {
+	return db.update(func(tx *Tx) error { return tx.DelUser(name) })
+}
+
+// DelUser stages the removing of an user from both user and shadow files.
+func (tx *Tx) DelUser(name string) error {
+	return tx.DelUserWithOptions(name, nil)
+}
+
+// DelUserOptions represents the options to remove an user.
+type DelUserOptions struct {
+	// RemoveHome removes the home directory and the mail spool of the user,
+	// like "userdel -r". It fails with a HomeError whether the home directory
+	// is a symbolic link or it is not owned by the user.
+	RemoveHome bool
+
+	// KeepUserGroup keeps the group with the same name of the user, which is
+	// removed when the policy has set UserGroupsEnab, it is the primary group
+	// of the user, and it has no other members.
+	KeepUserGroup bool
+
+	// Policy overrides the policy of the database for this call.
+	Policy *Policy
+}
+
+// DelUserWithOptions removes an user from the system, handling the given
+// options.
+func DelUserWithOptions(name string, opts *DelUserOptions) error {
+	return defaultDB.DelUserWithOptions(name, opts)
+}
+
+// DelUserWithOptions removes an user from the database, handling the given
+// options.
+func (db *DB) DelUserWithOptions(name string, opts *DelUserOptions) error {
+	return db.update(func(tx *Tx) error { return tx.DelUserWithOptions(name, opts) })
+}
+
+// DelUserWithOptions stages the removing of an user from both user and shadow
+// files, and its ranges of subordinate ids, handling the given options.
+// The home directory is removed once the transaction is committed.
+func (tx *Tx) DelUserWithOptions(name string, opts *DelUserOptions) error {
+	if opts == nil {
+		opts = &DelUserOptions{}
+	}
+
+	u, err := tx.LookupUser(name)
+	if err != nil {
+		return err
+	}
+	// The ranges are removed while the user exists, to get the ones keyed by
+	// its user id.
+	if err = ignoreNoFound(tx.DelSubIDs(name)); err != nil {
+		return err
+	}
+	if err = tx.del(name, &User{}); err != nil {
+		return err
+	}
+	if err = ignoreNoFound(tx.del(name, &Shadow{})); err != nil {
+		return err
+	}
+
+	p := tx.db.policyOf(opts.Policy)
+	if p.UserGroupsEnab && !opts.KeepUserGroup {
+		if err = tx.delUserGroup(u); err != nil {
+			return err
+		}
+	}
+
+	if opts.RemoveHome {
+		if u.Dir != "" && path.Clean(u.Dir) != "/" && path.Clean(u.Dir) != path.Clean(p.Home) {
+			// It fails before of writing the files whether the home directory
+			// would not be removed.
+			if err = tx.db.checkHomeOwner(u); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		tx.afterCommit = append(tx.afterCommit, func() error {
+			return tx.db.removeHome(u, p)
+		})
+	}
+	return nil
+}
+
+// delUserGroup stages the removing of the group private of an user removed,
+// which has its name and it is its primary group, whether it has no other
+// members, like "userdel(8)" does.
+func (tx *Tx) delUserGroup(u *User) error {
+	g, err := tx.LookupGroup(u.Name)
+	if err != nil {
+		return ignoreNoFound(err)
+	}
+	if g.GID != u.GID {
+		return nil
+	}
+
+	members, err := tx.MembersOfGroup(u.Name)
+	if err != nil {
+		return err
+	}
+	for _, m := range members {
+		if m.Name != u.Name && m.IsMember() {
+			return nil
+		}
+	}
+	return tx.DelGroup(u.Name)
+}
+
+// == Modify
+//
+
+// A UserMod represents the changes to do in an user account, like it is done
+// by "usermod(8)". The fields which are nil are not changed.
+type UserMod struct {
+	Name  *string
+	UID   *int
+	GID   *int
+	Gecos *string
+	Dir   *string
+	Shell *string
+
+	// MoveHome moves the home directory to the new one set in Dir. Whether it
+	// is in other file system, the files are copied and then removed.
+	MoveHome bool
+
+	// ChownHome changes the owner of the files into the home directory which
+	// belong to the old UID or primary GID, when those ones are changed.
+	ChownHome bool
+}
+
+// ModUser modifies an user account of the system.
+func ModUser(name string, changes *UserMod) error {
+	return defaultDB.ModUser(name, changes)
+}
+
+// ModUser modifies an user account of the database.
+func (db *DB) ModUser(name string, changes *UserMod) error {
+	return db.update(func(tx *Tx) error { return tx.ModUser(name, changes) })
+}
+
+// ModUser stages the changes of an user account.
+// Whether the login name is changed, it is also changed in the shadowed file,
+// in the member lists of groups and in the ranges of subordinate ids; and so
+// the user id whether it is the key of those ranges.
+//
+// The home directory is moved and its files are re-owned once the transaction
+// has been committed. A nil changes does not change anything.
+func (tx *Tx) ModUser(name string, changes *UserMod) error {
+	if changes == nil {
+		changes = &UserMod{}
+	}
+
+	u, err := tx.LookupUser(name)
+	if err != nil {
+		return err
+	}
+	old := *u
+
+	if changes.Name != nil && *changes.Name != name {
+		if err = tx.db.policyOf(nil).ValidateName(*changes.Name); err != nil {
+			return err
+		}
+		if _, err = tx.LookupUser(*changes.Name); err == nil {
+			return ErrUserExist
+		} else if _, ok := err.(NoFoundError); !ok {
+			return err
+		}
+		u.Name = *changes.Name
+	}
+	if changes.UID != nil && *changes.UID != u.UID {
+		if _, err = tx.LookupUID(*changes.UID); err == nil {
+			return IdUsedError(*changes.UID)
+		} else if _, ok := err.(NoFoundError); !ok {
+			return err
+		}
+		u.UID = *changes.UID
+	}
+	if changes.GID != nil && *changes.GID != u.GID {
+		if _, err = tx.LookupGID(*changes.GID); err != nil {
+			return err
+		}
+		u.GID = *changes.GID
+	}
+	if changes.Gecos != nil {
+		u.Gecos = *changes.Gecos
+	}
+	if changes.Dir != nil {
+		if *changes.Dir == "" {
+			return RequiredError("Dir")
+		}
+		if *changes.Dir == tx.db.config.policy.Home {
+			return HomeError(tx.db.config.policy.Home)
+		}
+		u.Dir = *changes.Dir
+	}
+	if changes.Shell != nil {
+		if *changes.Shell == "" {
+			return RequiredError("Shell")
+		}
+		u.Shell = *changes.Shell
+	}
+
+	if err = tx.edit(name, u); err != nil {
+		return err
+	}
+	if u.Name != name {
+		if err = tx.renameUser(name, u.Name); err != nil {
+			return err
+		}
+	}
+	if u.UID != old.UID {
+		// The old user id is not a key whether it is the login name of an user.
+		oldID := strconv.Itoa(old.UID)
+		if _, err = tx.LookupUser(oldID); err != nil {
+			if ignoreNoFound(err) != nil {
+				return err
+			}
+			if err = tx.renameSubIDs(oldID, strconv.Itoa(u.UID)); err != nil {
+				return err
+			}
+		}
+	}
+
+	if changes.MoveHome && u.Dir != old.Dir {
+		// The directories are checked too at staging, to fail before of writing
+		// the files.
+		if err = tx.db.checkHomes(old.Dir, u.Dir); err != nil {
+			return err
+		}
+		tx.afterCommit = append(tx.afterCommit, func() error {
+			if err := tx.db.checkHomes(old.Dir, u.Dir); err != nil {
+				return err
+			}
+			return moveHome(tx.db.path(old.Dir), tx.db.path(u.Dir))
+		})
+	}
+	if changes.ChownHome && (u.UID != old.UID || u.GID != old.GID) {
+		dir := u.Dir
+		if !changes.MoveHome {
+			dir = old.Dir
+		}
+		if err = tx.db.checkHomes(dir); err != nil {
+			return err
+		}
+		tx.afterCommit = append(tx.afterCommit, func() error {
+			name, err := tx.db.homePath(dir)
+			if err != nil {
+				return err
+			}
+			if err = checkNoSymlink(name, dir); err != nil {
+				if os.IsNotExist(err) {
+					return nil
+				}
+				return err
+			}
+			return chownTree(name, old.UID, old.GID, u.UID, u.GID)
+		})
+	}
+	return nil
+}
+
+// renameUser stages the change of the login name in the shadowed file, in the
+// lists of members and administrators of the groups, and in the ranges of
+// subordinate ids.
+func (tx *Tx) renameUser(oldName, newName string) error {
+	s, err := tx.LookupShadow(oldName)
 	if err == nil {
-		err = del(name, &Shadow{})
+		s.Name = newName
+		if err = tx.edit(oldName, s); err != nil {
+			return err
+		}
+	} else if ignoreNoFound(err) != nil {
+		return err
 	}
-	return
+	if err = tx.renameSubIDs(oldName, newName); err != nil {
+		return err
+	}
+
+	groups, err := tx.LookupInGroup(G_MEMBER, oldName, -1)
+	if ignoreNoFound(err) != nil {
+		return err
+	}
+	for _, g := range groups {
+		renameMember(g.UserList, oldName, newName)
+		if err = tx.edit(g.Name, g); err != nil {
+			return err
+		}
+	}
+
+	gshadows, err := tx.LookupInGShadow(GS_MEMBER|GS_ADMIN, oldName, -1)
+	if ignoreNoFound(err) != nil {
+		return err
+	}
+	for _, gs := range gshadows {
+		renameMember(gs.AdminList, oldName, newName)
+		renameMember(gs.UserList, oldName, newName)
+		if err = tx.edit(gs.Name, gs); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // == Errors
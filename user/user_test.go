@@ -9,12 +9,13 @@ package user
 import (
 	"bufio"
 	"io"
+	"io/ioutil"
 	"os"
 	"testing"
 )
 
 func TestUserParser(t *testing.T) {
-	f, err := os.Open(fileUser)
+	f, err := os.Open(testDB.path(fileUser))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -39,37 +40,37 @@ func TestUserParser(t *testing.T) {
 }
 
 func TestUserFull(t *testing.T) {
-	entry, err := LookupUID(os.Getuid())
+	entry, err := testDB.LookupUID(os.Getuid())
 	if err != nil || entry == nil {
 		t.Error(err)
 	}
 
-	entry, err = LookupUser("root")
+	entry, err = testDB.LookupUser("root")
 	if err != nil || entry == nil {
 		t.Error(err)
 	}
 
-	entries, err := LookupInUser(U_GID, 65534, -1)
+	entries, err := testDB.LookupInUser(U_GID, 65534, -1)
 	if err != nil || entries == nil {
 		t.Error(err)
 	}
 
-	entries, err = LookupInUser(U_GECOS, "", -1)
+	entries, err = testDB.LookupInUser(U_GECOS, "", -1)
 	if err != nil || entries == nil {
 		t.Error(err)
 	}
 
-	entries, err = LookupInUser(U_DIR, "/bin", -1)
+	entries, err = testDB.LookupInUser(U_DIR, "/bin", -1)
 	if err != nil || entries == nil {
 		t.Error(err)
 	}
 
-	entries, err = LookupInUser(U_SHELL, "/bin/false", -1)
+	entries, err = testDB.LookupInUser(U_SHELL, "/bin/false", -1)
 	if err != nil || entries == nil {
 		t.Error(err)
 	}
 
-	entries, err = LookupInUser(U_ALL, nil, -1)
+	entries, err = testDB.LookupInUser(U_ALL, nil, -1)
 	if err != nil || len(entries) == 0 {
 		t.Error(err)
 	}
@@ -77,45 +78,45 @@ func TestUserFull(t *testing.T) {
 
 func TestUserCount(t *testing.T) {
 	count := 2
-	entries, err := LookupInUser(U_SHELL, "/bin/false", count)
+	entries, err := testDB.LookupInUser(U_SHELL, "/bin/false", count)
 	if err != nil || len(entries) != count {
 		t.Error(err)
 	}
 
 	count = 5
-	entries, err = LookupInUser(U_ALL, nil, count)
+	entries, err = testDB.LookupInUser(U_ALL, nil, count)
 	if err != nil || len(entries) != count {
 		t.Error(err)
 	}
 }
 
 func TestUserError(t *testing.T) {
-	_, err := LookupUser("!!!???")
+	_, err := testDB.LookupUser("!!!???")
 	if _, ok := err.(NoFoundError); !ok {
 		t.Error("expected to report NoFoundError")
 	}
 
-	if _, err = LookupInUser(U_SHELL, "/bin/false", 0); err != errSearch {
+	if _, err = testDB.LookupInUser(U_SHELL, "/bin/false", 0); err != errSearch {
 		t.Error("expected to report errSearch")
 	}
 
-	u := &User{}
+	u := &User{db: testDB}
 	if _, err = u.Add(); err != RequiredError("Name") {
 		t.Error("expected to report RequiredError")
 	}
 
-	u = &User{Name: USER, Dir: config.useradd.HOME, Shell: config.useradd.SHELL}
-	if _, err = u.Add(); err != HomeError(config.useradd.HOME) {
+	u = &User{db: testDB, Name: USER, Dir: testDB.config.policy.Home, Shell: testDB.config.policy.Shell}
+	if _, err = u.Add(); err != HomeError(testDB.config.policy.Home) {
 		t.Error("expected to report HomeError")
 	}
 }
 
 func TestUser_Add(t *testing.T) {
-	user := NewUser(USER, GID)
+	user := testDB.NewUser(USER, GID)
 	user.Dir = "/tmp"
 	testUserAdd(t, user, false)
 
-	user = NewSystemUser(SYS_USER, "/tmp", GID)
+	user = testDB.NewSystemUser(SYS_USER, "/tmp", GID)
 	testUserAdd(t, user, true)
 }
 
@@ -160,7 +161,7 @@ func testUserAdd(t *testing.T, user *User, ofSystem bool) {
 		name = USER
 	}
 
-	u, err := LookupUser(name)
+	u, err := testDB.LookupUser(name)
 	if err != nil {
 		t.Fatalf("%s: ", err)
 	}
@@ -171,11 +172,11 @@ func testUserAdd(t *testing.T, user *User, ofSystem bool) {
 }
 
 func TestUserLock(t *testing.T) {
-	err := LockUser(USER)
+	err := testDB.LockUser(USER)
 	if err != nil {
 		t.Fatal(err)
 	}
-	s, err := LookupShadow(USER)
+	s, err := testDB.LookupShadow(USER)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -184,11 +185,11 @@ func TestUserLock(t *testing.T) {
 			lockChar, s.password[0])
 	}
 
-	err = UnlockUser(USER)
+	err = testDB.UnlockUser(USER)
 	if err != nil {
 		t.Fatal(err)
 	}
-	s, err = LookupShadow(USER)
+	s, err = testDB.LookupShadow(USER)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -196,3 +197,212 @@ func TestUserLock(t *testing.T) {
 		t.Fatalf("no expected to get password starting with '%c'", lockChar)
 	}
 }
+
+func TestModUser(t *testing.T) {
+	const name, newName = "u_mod", "u_mod2"
+
+	uid, err := testDB.AddUser(name, GID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = testDB.AddUsersToGroup(GROUP, name); err != nil {
+		t.Fatal(err)
+	}
+
+	home := "/home/" + name
+	if err = os.MkdirAll(testDB.path(home), 0700); err != nil {
+		t.Fatal(err)
+	}
+	login, newHome, newUID, gecos := newName, "/home/"+newName, uid+1000, "Foo Bar"
+
+	err = testDB.ModUser(name, &UserMod{
+		Name:     &login,
+		UID:      &newUID,
+		Gecos:    &gecos,
+		Dir:      &newHome,
+		MoveHome: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = testDB.LookupUser(name); err == nil {
+		t.Errorf("expected to rename user %q", name)
+	}
+	u, err := testDB.LookupUser(newName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.UID != newUID || u.Gecos != gecos || u.Dir != newHome {
+		t.Errorf("expected to modify the user, got: %s", u)
+	}
+	if _, err = testDB.LookupShadow(newName); err != nil {
+		t.Error(err)
+	}
+	g, err := testDB.LookupGroup(GROUP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checkGroup(g.UserList, name) || !checkGroup(g.UserList, newName) {
+		t.Errorf("group: expected to rename the member, got: %s", g.UserList)
+	}
+	gs, err := testDB.LookupGShadow(GROUP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checkGroup(gs.UserList, name) || !checkGroup(gs.UserList, newName) {
+		t.Errorf("gshadow: expected to rename the member, got: %s", gs.UserList)
+	}
+	if found, _ := exist(testDB.path(newHome)); !found {
+		t.Error("expected to move the home directory")
+	}
+
+	if err = testDB.ModUser(newName, &UserMod{UID: &newUID}); err != nil {
+		t.Error(err)
+	}
+	rootUID := 0
+	if err = testDB.ModUser(newName, &UserMod{UID: &rootUID}); err != IdUsedError(0) {
+		t.Errorf("expected to report IdUsedError, got: %v", err)
+	}
+
+	if err = testDB.DelUsersInGroup(GROUP, newName); err != nil {
+		t.Error(err)
+	}
+	if err = testDB.DelUser(newName); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestUserGroup(t *testing.T) {
+	db := newTestDB(t, map[string]string{
+		fileLogin: "ENCRYPT_METHOD SHA512\nUSERGROUPS_ENAB yes\n" +
+			"UID_MIN 1000\nUID_MAX 60000\nGID_MIN 1000\nGID_MAX 60000\n",
+		fileUser:    "u1:x:1000:1000::/home/u1:/bin/sh\n",
+		fileGroup:   "u1:x:1000:\ng1:x:1001:\n",
+		fileShadow:  "u1:*:18000::::::\n",
+		fileGShadow: "u1:!::\ng1:!::\n",
+	})
+
+	uid, err := db.AddUser("u2", -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := db.LookupGroup("u2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uid != 1002 || g.GID != uid {
+		t.Errorf("expected the same id 1002 for user and group, got: %d, %d", uid, g.GID)
+	}
+	if _, err = db.LookupGShadow("u2"); err != nil {
+		t.Error(err)
+	}
+	u, err := db.LookupUser("u2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.GID != g.GID {
+		t.Errorf("expected the private group like primary, got: %d", u.GID)
+	}
+
+	if _, err = db.AddUserWithOptions("u3", 1001, &AddUserOptions{UserGroup: true}); err != ErrUserGroup {
+		t.Errorf("expected to report ErrUserGroup, got: %v", err)
+	}
+	if _, err = db.AddUser("g1", -1); err != ErrGroupExist {
+		t.Errorf("expected to report ErrGroupExist, got: %v", err)
+	}
+
+	// The group is kept while it has other members.
+	if err = db.AddUsersToGroup("u2", "u1"); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.DelUser("u2"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = db.LookupGroup("u2"); err != nil {
+		t.Errorf("expected to keep the group with members: %v", err)
+	}
+
+	if _, err = db.AddUser("u4", -1); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.DelUserWithOptions("u4", &DelUserOptions{KeepUserGroup: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = db.LookupGroup("u4"); err != nil {
+		t.Errorf("expected to keep the private group: %v", err)
+	}
+
+	if err = db.DelUser("u1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = db.LookupGroup("u1"); err == nil {
+		t.Error("expected to remove the private group")
+	}
+	if _, err = db.LookupGShadow("u1"); err == nil {
+		t.Error("expected to remove the private shadowed group")
+	}
+}
+
+func TestUserIsOfSystem(t *testing.T) {
+	db := newTestDB(t, map[string]string{
+		fileLogin: "ENCRYPT_METHOD SHA512\nSYS_UID_MIN 100\nSYS_UID_MAX 999\nUID_MIN 1000\nUID_MAX 60000\n" +
+			"SYS_GID_MIN 100\nSYS_GID_MAX 999\nGID_MIN 1000\nGID_MAX 60000\n",
+	})
+
+	// The configuration is loaded before of checking the range.
+	for uid, expected := range map[int]bool{0: false, 99: false, 100: true, 999: true, 1000: false} {
+		if got := (&User{UID: uid, db: db}).IsOfSystem(); got != expected {
+			t.Errorf("UID %d: expected %v", uid, expected)
+		}
+		if got := (&Group{GID: uid, db: db}).IsOfSystem(); got != expected {
+			t.Errorf("GID %d: expected %v", uid, expected)
+		}
+	}
+}
+
+func TestModUserNil(t *testing.T) {
+	const userData = "u1:x:1000:1000::/home/u1:/bin/sh\n"
+
+	db := newTestDB(t, map[string]string{
+		fileLogin:   "ENCRYPT_METHOD SHA512\n",
+		fileUser:    userData,
+		fileGroup:   "g1:x:1000:\n",
+		fileShadow:  "u1:*:18000:0:99999:7:::\n",
+		fileGShadow: "g1:!::\n",
+	})
+	if err := db.ModUser("u1", nil); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := ioutil.ReadFile(db.path(fileUser)); string(data) != userData {
+		t.Errorf("expected to not change the user, got:\n%s", data)
+	}
+	if err := db.ModUser("u2", nil); err == nil {
+		t.Error("expected to report the user not found")
+	}
+}
+
+func TestAddUserCreateHome(t *testing.T) {
+	db := newTestDB(t, map[string]string{
+		fileLogin:   "ENCRYPT_METHOD SHA512\nCREATE_HOME yes\nUID_MIN 1000\nUID_MAX 60000\n",
+		fileUser:    "",
+		fileGroup:   "g1:x:1000:\n",
+		fileShadow:  "",
+		fileGShadow: "g1:!::\n",
+	})
+
+	// The policy is used when there are not options.
+	if _, err := db.AddUser("u1", 1000); err != nil {
+		t.Fatal(err)
+	}
+	if found, _ := exist(db.path("/home/u1")); !found {
+		t.Error("expected to create the home directory set in the policy")
+	}
+
+	if _, err := db.AddUserWithOptions("u2", 1000, &AddUserOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if found, _ := exist(db.path("/home/u2")); found {
+		t.Error("expected to not create the home directory set in the options")
+	}
+}
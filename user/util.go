@@ -6,7 +6,12 @@
 
 package user
 
-import "os"
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
 
 var isRoot bool
 
@@ -28,7 +33,7 @@ func checkRoot() {
 func exist(file string) (bool, error) {
 	_, err := os.Stat(file)
 	if err != nil {
-		if err == os.ErrNotExist {
+		if os.IsNotExist(err) {
 			return false, nil
 		}
 		return false, err
@@ -41,3 +46,134 @@ const _SEC_PER_DAY = 24 * 60 * 60
 
 // secToDay converts from secons to days.
 func secToDay(sec int64) int { return int(sec / _SEC_PER_DAY) }
+
+// dayToTime converts from days since Jan 1, 1970 to time, in UTC.
+func dayToTime(day int) time.Time { return time.Unix(int64(day)*_SEC_PER_DAY, 0).UTC() }
+
+// renameMember changes the name of a member into a list.
+func renameMember(list []string, oldName, newName string) {
+	for i, v := range list {
+		if v == oldName {
+			list[i] = newName
+		}
+	}
+}
+
+// moveHome moves a home directory. It fails whether the new one already exists.
+// Whether both directories are in different file systems, the files are copied
+// and the old directory is removed, like "usermod -m".
+func moveHome(oldDir, newDir string) error {
+	if found, err := exist(newDir); found {
+		return HomeError(newDir)
+	} else if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(newDir), 0755); err != nil {
+		return err
+	}
+	err := os.Rename(oldDir, newDir)
+	if e, ok := err.(*os.LinkError); !ok || e.Err != syscall.EXDEV {
+		return err
+	}
+
+	if err = copyTree(oldDir, newDir); err != nil {
+		os.RemoveAll(newDir)
+		return err
+	}
+	return os.RemoveAll(oldDir)
+}
+
+// copyTree copies the directory src to the new one dst, keeping the owners, the
+// modes and the times of modification of the files.
+func copyTree(src, dst string) error {
+	// The modes of the directories are set at the end, since they could not
+	// allow to write into.
+	type dirInfo struct {
+		name string
+		info os.FileInfo
+	}
+	var dirs []dirInfo
+
+	err := filepath.Walk(src, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, name)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		switch mode := info.Mode(); {
+		case mode.IsDir():
+			dirs = append(dirs, dirInfo{target, info})
+			return os.Mkdir(target, 0700)
+		case mode&os.ModeSymlink != 0:
+			link, err := os.Readlink(name)
+			if err != nil {
+				return err
+			}
+			if err = os.Symlink(link, target); err != nil {
+				return err
+			}
+			uid, gid := fileOwner(info)
+			return os.Lchown(target, uid, gid)
+		case mode.IsRegular():
+			if err = copyFile(name, target, mode.Perm()); err != nil {
+				return err
+			}
+			return setFileInfo(target, info)
+		}
+		return nil // Devices, sockets and pipes are not copied.
+	})
+	if err != nil {
+		return err
+	}
+
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err = setFileInfo(dirs[i].name, dirs[i].info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setFileInfo sets the owner, the mode and the time of modification of the
+// named file from info. The mode is set after of the owner, which clears the
+// bits setuid and setgid.
+func setFileInfo(name string, info os.FileInfo) error {
+	uid, gid := fileOwner(info)
+	if err := os.Lchown(name, uid, gid); err != nil {
+		return err
+	}
+
+	mode := info.Mode()
+	if err := os.Chmod(name, mode.Perm()|mode&(os.ModeSetuid|os.ModeSetgid|os.ModeSticky)); err != nil {
+		return err
+	}
+	return os.Chtimes(name, info.ModTime(), info.ModTime())
+}
+
+// chownTree changes the owner of the files into the directory which belong to
+// the old user or group ids.
+func chownTree(dir string, oldUID, oldGID, newUID, newGID int) error {
+	if found, err := exist(dir); !found {
+		return err
+	}
+
+	return filepath.Walk(dir, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		uid, gid := fileOwner(info)
+		if uid == oldUID {
+			uid = newUID
+		}
+		if gid == oldGID {
+			gid = newGID
+		}
+		return os.Lchown(name, uid, gid)
+	})
+}
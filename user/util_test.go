@@ -7,6 +7,9 @@
 package user
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 )
@@ -25,3 +28,55 @@ func TestSecToDays(t *testing.T) {
 		}
 	}
 }
+
+func TestCopyTree(t *testing.T) {
+	src, err := ioutil.TempDir("", "test-user-tree_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(src)
+	dst := src + ".copy"
+	defer os.RemoveAll(dst)
+
+	if err = os.Mkdir(filepath.Join(src, "dir"), 0750); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(src, "dir", "file")
+	if err = ioutil.WriteFile(file, []byte("data"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Symlink("dir/file", filepath.Join(src, "link")); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Lchown(file, 1000, 1001); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err = os.Chtimes(file, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = copyTree(src, dst); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(filepath.Join(dst, "dir", "file"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uid, gid := fileOwner(info); uid != 1000 || gid != 1001 {
+		t.Errorf("expected to keep the owner, got %d:%d", uid, gid)
+	}
+	if info.Mode().Perm() != 0640 || !info.ModTime().Equal(mtime) {
+		t.Errorf("expected to keep the mode and time, got %o, %s", info.Mode().Perm(), info.ModTime())
+	}
+	if data, _ := ioutil.ReadFile(filepath.Join(dst, "dir", "file")); string(data) != "data" {
+		t.Errorf("unexpected content: %q", data)
+	}
+	if info, err = os.Stat(filepath.Join(dst, "dir")); err != nil || info.Mode().Perm() != 0750 {
+		t.Errorf("expected to keep the mode of the directory, got: %v, %v", info, err)
+	}
+	if link, err := os.Readlink(filepath.Join(dst, "link")); err != nil || link != "dir/file" {
+		t.Errorf("expected to copy the link, got: %q, %v", link, err)
+	}
+}
@@ -9,32 +9,32 @@ package user
 import "testing"
 
 func TestDelUser(t *testing.T) {
-	err := DelUser(USER)
+	err := testDB.DelUser(USER)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	_, err = LookupUser(USER)
+	_, err = testDB.LookupUser(USER)
 	if _, ok := err.(NoFoundError); !ok {
 		t.Error("expected to get error NoFoundError")
 	}
-	_, err = LookupShadow(USER)
+	_, err = testDB.LookupShadow(USER)
 	if _, ok := err.(NoFoundError); !ok {
 		t.Error("expected to get error NoFoundError")
 	}
 }
 
 func TestDelGroup(t *testing.T) {
-	err := DelGroup(GROUP)
+	err := testDB.DelGroup(GROUP)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	_, err = LookupGroup(GROUP)
+	_, err = testDB.LookupGroup(GROUP)
 	if _, ok := err.(NoFoundError); !ok {
 		t.Error("expected to get error NoFoundError")
 	}
-	_, err = LookupGShadow(GROUP)
+	_, err = testDB.LookupGShadow(GROUP)
 	if _, ok := err.(NoFoundError); !ok {
 		t.Error("expected to get error NoFoundError")
 	}